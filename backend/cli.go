@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"visioncloud/services"
+)
+
+// runCommand runs a one-off CLI subcommand and returns the process exit code
+func runCommand(ctx context.Context, app *application, name string, args []string) int {
+	// Ctrl+C cancels the command; work already done is kept in checkpoints
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch name {
+	case "sweep":
+		err = runSweep(ctx, app, args)
+	case "retry-failed":
+		err = runRetryFailed(ctx, app, args)
+	case "flush-spool":
+		err = runFlushSpool(ctx, app)
+	case "migrate-layout":
+		err = runMigrateLayout(ctx, app, args)
+	case "retention":
+		err = runRetention(ctx, app, args)
+	case "thumbnails":
+		err = runThumbnails(ctx, app, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		fmt.Fprintln(os.Stderr, "available commands: sweep, retry-failed, flush-spool, migrate-layout, retention, thumbnails")
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// runSweep reprocesses existing objects under a prefix
+// Usage: visioncloud sweep -prefix raw [-concurrency 4] [-checkpoint sweep.json] [-ext .jpg,.png]
+func runSweep(ctx context.Context, app *application, args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "source prefix to sweep (required)")
+	concurrency := fs.Int("concurrency", 4, "number of images processed in parallel")
+	checkpoint := fs.String("checkpoint", "", "checkpoint file used to resume an interrupted sweep")
+	extensions := fs.String("ext", "", "comma-separated list of extensions to include, e.g. .jpg,.png")
+	minSize := fs.Int64("min-size", 0, "skip objects smaller than this many bytes")
+	maxSize := fs.Int64("max-size", 0, "skip objects larger than this many bytes")
+	since := fs.String("since", "", "only include objects modified at or after this RFC3339 time or date")
+	until := fs.String("until", "", "only include objects modified at or before this RFC3339 time, or during this date")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := services.SweepOptions{
+		SourcePrefix:   *prefix,
+		Concurrency:    *concurrency,
+		CheckpointPath: *checkpoint,
+		MinSize:        *minSize,
+		MaxSize:        *maxSize,
+	}
+	if *extensions != "" {
+		opts.Extensions = strings.Split(*extensions, ",")
+	}
+
+	var err error
+	if opts.ModifiedAfter, err = services.ParseTimeBound(*since, false); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	if opts.ModifiedBefore, err = services.ParseTimeBound(*until, true); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+
+	report, err := app.sweeper.Sweep(ctx, opts)
+	if report != nil {
+		printJSON(report)
+	}
+	return err
+}
+
+// runRetryFailed re-runs the pipeline on images in the couldn't_upscale folder
+// Usage: visioncloud retry-failed [-prefix batch1/] [-reason upscale_failed] [-concurrency 2]
+func runRetryFailed(ctx context.Context, app *application, args []string) error {
+	fs := flag.NewFlagSet("retry-failed", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only retry keys starting with this prefix")
+	reason := fs.String("reason", "", "only retry images that failed for this reason (assessment_failed, upscale_failed)")
+	concurrency := fs.Int("concurrency", 2, "number of images retried in parallel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := app.retrier.RetryFailed(ctx, services.RetryFailedOptions{
+		Prefix:      *prefix,
+		Reason:      *reason,
+		Concurrency: *concurrency,
+	})
+	if report != nil {
+		printJSON(report)
+	}
+	return err
+}
+
+// runFlushSpool uploads images left in the local spool by failed storage writes
+// Usage: visioncloud flush-spool
+func runFlushSpool(ctx context.Context, app *application) error {
+	flushed, err := app.spool.Flush(ctx)
+	fmt.Printf("Flushed %d spooled images\n", flushed)
+	if err != nil {
+		return err
+	}
+
+	pending, err := app.spool.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		printJSON(pending)
+		return fmt.Errorf("%d images are still spooled", len(pending))
+	}
+	return nil
+}
+
+// runMigrateLayout moves stored images from an old folder layout to the configured one
+// Usage: visioncloud migrate-layout [-from-template {folder}/{key}] [-from-names couldn't_upscale=failed] [-dry-run]
+func runMigrateLayout(ctx context.Context, app *application, args []string) error {
+	fs := flag.NewFlagSet("migrate-layout", flag.ContinueOnError)
+	fromTemplate := fs.String("from-template", services.DefaultKeyTemplate, "key template of the current layout")
+	fromNames := fs.String("from-names", "", "comma-separated folder=name renames of the current layout")
+	folders := fs.String("folders", "", "comma-separated logical folders to move; empty means the built-in folders")
+	dryRun := fs.Bool("dry-run", false, "report the moves without touching the bucket")
+	concurrency := fs.Int("concurrency", 4, "number of objects moved in parallel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	names := make(map[string]string)
+	for _, pair := range splitFlagList(*fromNames) {
+		folder, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid -from-names entry %q, expected folder=name", pair)
+		}
+		names[strings.TrimSpace(folder)] = strings.TrimSpace(name)
+	}
+	from, err := services.NewFolderLayout(*fromTemplate, names)
+	if err != nil {
+		return fmt.Errorf("invalid source layout: %w", err)
+	}
+
+	report, err := services.MigrateLayout(ctx, app.storageService, services.LayoutMigrationOptions{
+		From:        from,
+		To:          app.orchestrator.Layout(),
+		Folders:     splitFlagList(*folders),
+		DryRun:      *dryRun,
+		Concurrency: *concurrency,
+	})
+	if report != nil {
+		printJSON(report)
+		if report.Failed > 0 && err == nil {
+			err = fmt.Errorf("%d objects could not be moved", report.Failed)
+		}
+	}
+	return err
+}
+
+// runRetention applies the retention policies once
+// Usage: visioncloud retention [-dry-run]
+func runRetention(ctx context.Context, app *application, args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted or transitioned without touching the bucket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(app.janitor.Policies()) == 0 {
+		return fmt.Errorf("no retention policies configured; set RETENTION_POLICIES_PATH")
+	}
+
+	report, err := app.janitor.Enforce(ctx, *dryRun)
+	if report != nil {
+		printJSON(report)
+		if report.Failed > 0 && err == nil {
+			err = fmt.Errorf("%d objects could not be deleted or transitioned", report.Failed)
+		}
+	}
+	return err
+}
+
+// runThumbnails generates the missing thumbnails of images already in the bucket
+// Usage: visioncloud thumbnails [-folders good_quality,upscaled] [-force] [-dry-run] [-concurrency 4]
+func runThumbnails(ctx context.Context, app *application, args []string) error {
+	fs := flag.NewFlagSet("thumbnails", flag.ContinueOnError)
+	folders := fs.String("folders", "", "comma-separated logical folders to cover; empty means good_quality, upscaled and couldn't_upscale")
+	force := fs.Bool("force", false, "regenerate thumbnails that already exist")
+	dryRun := fs.Bool("dry-run", false, "list the images missing thumbnails without generating them")
+	concurrency := fs.Int("concurrency", 4, "number of images processed in parallel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := app.orchestrator.BackfillThumbnails(ctx, services.ThumbnailBackfillOptions{
+		Folders:     splitFlagList(*folders),
+		DryRun:      *dryRun,
+		Force:       *force,
+		Concurrency: *concurrency,
+	})
+	if report != nil {
+		printJSON(report)
+		if report.Failed > 0 && err == nil {
+			err = fmt.Errorf("%d images could not get thumbnails", report.Failed)
+		}
+	}
+	return err
+}
+
+// splitFlagList splits a comma-separated flag value, ignoring empty entries
+func splitFlagList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// printJSON writes a command report to stdout
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -15,6 +17,102 @@ type Config struct {
 	QualityThreshold float64
 	UpscaleScript    string
 	UpscaleScale     int
+
+	// S3-compatible stores (MinIO, LocalStack); an empty endpoint uses AWS
+	S3Endpoint     string
+	S3UsePathStyle bool
+	S3DisableTLS   bool
+
+	// Encryption, storage class and tags of written objects
+	S3Encryption   string
+	S3KMSKeyID     string
+	S3StorageClass string
+	S3FolderTags   map[string]string
+
+	// Headers of stored objects and lifetimes of pre-signed URLs
+	S3CacheControl    string
+	S3URLExpiry       time.Duration
+	S3UploadURLExpiry time.Duration
+
+	// Direct uploads: "presigned" URLs to S3, or "local" URLs signed by this server
+	UploadURLMode       string
+	UploadSigningSecret string
+
+	// Adaptive scale selection; with no target every image gets UpscaleScale
+	UpscaleTargetPixels    int
+	UpscaleTargetQuality   float64
+	UpscaleMaxOutputPixels int
+
+	// Images with more pixels are refused before decoding, uploads and stored
+	// images alike, so keep it at least UpscaleMaxOutputPixels
+	DecodeMaxPixels int
+
+	// Declarative routing rules, evaluated before the quality threshold
+	RoutingRulesPath string
+
+	// Upscaling models; without a registry file the built-in script and bicubic models are used
+	ModelRegistryPath string
+	TenantModels      map[string]string
+
+	// Content classification; the label can pick the model and output format
+	ClassifierEndpoint      string
+	ClassifierMinConfidence float64
+	ContentModels           map[string]string
+	ContentOutputFormats    map[string]string
+
+	// Output format of upscaled images (png, jpeg, webp, original)
+	OutputFormat      string
+	OutputJPEGQuality int
+
+	// Shadow testing: a sample of upscale jobs also runs through a candidate model
+	ShadowModel       string
+	ShadowPercent     float64
+	ShadowConcurrency int
+
+	// Retry and fallback behaviour for upscaling and storage writes
+	UpscaleFallbackChain  []string
+	UpscaleMaxAttempts    int
+	UpscaleAttemptTimeout time.Duration
+	StorageMaxAttempts    int
+	RetryInitialBackoff   time.Duration
+	RetryMaxBackoff       time.Duration
+
+	// Tiled upscaling of large images
+	TileSize            int
+	TileOverlap         int
+	TileThresholdPixels int
+	TileConcurrency     int
+
+	// Bucket layout: folder renames, key template and staging in the processing folder
+	FolderNames       map[string]string
+	FolderKeyTemplate string
+	FolderStaging     bool
+	KeepOriginals     bool
+
+	// Thumbnails generated for every stored image; no sizes disables them
+	ThumbnailSizes   []int
+	ThumbnailFormat  string
+	ThumbnailQuality int
+
+	// Checkpoints of sweeps started over HTTP; a repeated sweep of a prefix resumes
+	SweepCheckpointDir string
+
+	// Local spool for images whose storage write failed
+	SpoolDir           string
+	SpoolRetryInterval time.Duration
+
+	// Retention policies enforced by the background janitor; 0 disables it
+	RetentionPoliciesPath string
+	RetentionInterval     time.Duration
+
+	// Embedded database holding every processing result
+	HistoryDBPath string
+
+	// Completion webhooks
+	WebhookSecret      string
+	TenantWebhooks     map[string]string
+	WebhookMaxAttempts int
+	CallbackHosts      []string // hosts client callback URLs may use; empty allows any public host
 }
 
 func init() {
@@ -135,6 +233,80 @@ func LoadConfig() *Config {
 		QualityThreshold: qualityThreshold,
 		UpscaleScript:    getEnv("UPSCALE_SCRIPT", "../python/upscaler/upscale.py"),
 		UpscaleScale:     upscaleScale,
+
+		S3Endpoint:     getEnv("S3_ENDPOINT", ""),
+		S3UsePathStyle: getEnvBool("S3_USE_PATH_STYLE", false),
+		S3DisableTLS:   getEnvBool("S3_DISABLE_TLS", false),
+
+		S3Encryption:   getEnv("S3_ENCRYPTION", ""),
+		S3KMSKeyID:     getEnv("S3_KMS_KEY_ID", ""),
+		S3StorageClass: getEnv("S3_STORAGE_CLASS", ""),
+		S3FolderTags:   getEnvMap("S3_FOLDER_TAGS"),
+
+		S3CacheControl:    getEnv("S3_CACHE_CONTROL", "public, max-age=86400"),
+		S3URLExpiry:       getEnvDuration("S3_URL_EXPIRY", time.Hour),
+		S3UploadURLExpiry: getEnvDuration("S3_UPLOAD_URL_EXPIRY", 15*time.Minute),
+
+		UploadURLMode:       getEnv("UPLOAD_URL_MODE", "presigned"),
+		UploadSigningSecret: getEnv("UPLOAD_SIGNING_SECRET", ""),
+
+		UpscaleTargetPixels:    getEnvInt("UPSCALE_TARGET_PIXELS", 0),
+		UpscaleTargetQuality:   getEnvFloat("UPSCALE_TARGET_QUALITY", 0),
+		UpscaleMaxOutputPixels: getEnvInt("UPSCALE_MAX_OUTPUT_PIXELS", 7680*4320),
+		DecodeMaxPixels:        getEnvInt("DECODE_MAX_PIXELS", 7680*4320),
+
+		RoutingRulesPath: getEnv("ROUTING_RULES_PATH", ""),
+
+		ModelRegistryPath: getEnv("MODEL_REGISTRY_PATH", ""),
+		TenantModels:      getEnvMap("TENANT_MODELS"),
+
+		ClassifierEndpoint:      getEnv("CLASSIFIER_ENDPOINT", ""),
+		ClassifierMinConfidence: getEnvFloat("CLASSIFIER_MIN_CONFIDENCE", 0.5),
+		ContentModels:           getEnvMap("CONTENT_MODELS"),
+		ContentOutputFormats:    getEnvMap("CONTENT_OUTPUT_FORMATS"),
+
+		OutputFormat:      getEnv("OUTPUT_FORMAT", "original"),
+		OutputJPEGQuality: getEnvInt("OUTPUT_JPEG_QUALITY", 90),
+
+		ShadowModel:       getEnv("SHADOW_MODEL", ""),
+		ShadowPercent:     getEnvFloat("SHADOW_PERCENT", 10),
+		ShadowConcurrency: getEnvInt("SHADOW_CONCURRENCY", 1),
+
+		UpscaleFallbackChain:  getEnvList("UPSCALE_FALLBACK_CHAIN", []string{"script", "bicubic"}),
+		UpscaleMaxAttempts:    getEnvInt("UPSCALE_MAX_ATTEMPTS", 3),
+		UpscaleAttemptTimeout: getEnvDuration("UPSCALE_ATTEMPT_TIMEOUT", 2*time.Minute),
+		StorageMaxAttempts:    getEnvInt("STORAGE_MAX_ATTEMPTS", 3),
+		RetryInitialBackoff:   getEnvDuration("RETRY_INITIAL_BACKOFF", time.Second),
+		RetryMaxBackoff:       getEnvDuration("RETRY_MAX_BACKOFF", 30*time.Second),
+
+		TileSize:            getEnvInt("TILE_SIZE", 512),
+		TileOverlap:         getEnvInt("TILE_OVERLAP", 32),
+		TileThresholdPixels: getEnvInt("TILE_THRESHOLD_PIXELS", 4_000_000),
+		TileConcurrency:     getEnvInt("TILE_CONCURRENCY", 4),
+
+		FolderNames:       getEnvMap("FOLDER_NAMES"),
+		FolderKeyTemplate: getEnv("FOLDER_KEY_TEMPLATE", "{folder}/{key}"),
+		FolderStaging:     getEnvBool("FOLDER_STAGING", true),
+		KeepOriginals:     getEnvBool("KEEP_ORIGINALS", true),
+
+		ThumbnailSizes:   getEnvIntList("THUMBNAIL_SIZES", []int{256, 512}),
+		ThumbnailFormat:  getEnv("THUMBNAIL_FORMAT", "webp"),
+		ThumbnailQuality: getEnvInt("THUMBNAIL_QUALITY", 80),
+
+		SweepCheckpointDir: getEnv("SWEEP_CHECKPOINT_DIR", "./data/sweeps"),
+
+		SpoolDir:           getEnv("SPOOL_DIR", "./spool"),
+		SpoolRetryInterval: getEnvDuration("SPOOL_RETRY_INTERVAL", time.Minute),
+
+		RetentionPoliciesPath: getEnv("RETENTION_POLICIES_PATH", ""),
+		RetentionInterval:     getEnvDuration("RETENTION_INTERVAL", 24*time.Hour),
+
+		HistoryDBPath: getEnv("HISTORY_DB_PATH", "./data/history.db"),
+
+		WebhookSecret:      getEnv("WEBHOOK_SECRET", ""),
+		TenantWebhooks:     getEnvMap("TENANT_WEBHOOKS"),
+		WebhookMaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		CallbackHosts:      getEnvList("WEBHOOK_ALLOWED_HOSTS", nil),
 	}
 }
 
@@ -144,3 +316,91 @@ func getEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+func getEnvInt(key string, defaultVal int) int {
+	if val := os.Getenv(key); val != "" {
+		if i, err := strconv.Atoi(val); err == nil {
+			return i
+		}
+		log.Printf("Warning: invalid integer for %s: %q", key, val)
+	}
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+		log.Printf("Warning: invalid boolean for %s: %q", key, val)
+	}
+	return defaultVal
+}
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+		log.Printf("Warning: invalid number for %s: %q", key, val)
+	}
+	return defaultVal
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+		log.Printf("Warning: invalid duration for %s: %q", key, val)
+	}
+	return defaultVal
+}
+
+// getEnvList reads a comma-separated list, ignoring empty entries
+func getEnvList(key string, defaultVal []string) []string {
+	val, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvIntList reads a comma-separated list of integers, skipping invalid entries
+func getEnvIntList(key string, defaultVal []int) []int {
+	if _, exists := os.LookupEnv(key); !exists {
+		return defaultVal
+	}
+
+	var list []int
+	for _, item := range getEnvList(key, nil) {
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid integer in %s: %q", key, item)
+			continue
+		}
+		list = append(list, i)
+	}
+	return list
+}
+
+// getEnvMap reads a comma-separated list of key=value pairs
+func getEnvMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range getEnvList(key, nil) {
+		parts := splitKeyValue(item)
+		if len(parts) != 2 {
+			log.Printf("Warning: ignoring malformed %s entry %q", key, item)
+			continue
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m
+}
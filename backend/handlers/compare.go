@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"visioncloud/services"
+)
+
+// Comparison serves a before/after PNG of an upscaled job: the original,
+// enlarged with nearest-neighbor to the output size, next to the output
+// GET /api/images/{job_id}/compare.png?layout=side-by-side|slider&split=0.5&crop=x,y,w,h&zoom=4&diff=true
+func (h *ImageHandler) Comparison(w http.ResponseWriter, r *http.Request, jobID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, err := parseComparisonOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, status, err := h.lookupJob(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	comparison, err := h.orchestrator.Compare(r.Context(), result, opts)
+	if errors.Is(err, services.ErrCannotCompare) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render comparison: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(comparison)
+}
+
+// parseComparisonOptions reads the comparison query parameters. The crop
+// region is given in pixels of the upscaled image.
+func parseComparisonOptions(r *http.Request) (services.ComparisonOptions, error) {
+	query := r.URL.Query()
+	opts := services.ComparisonOptions{
+		Layout:     query.Get("layout"),
+		Difference: query.Get("diff") == "true",
+	}
+
+	if value := query.Get("split"); value != "" {
+		split, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid split %q", value)
+		}
+		opts.Split = split
+	}
+
+	if value := query.Get("zoom"); value != "" {
+		zoom, err := strconv.Atoi(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid zoom %q", value)
+		}
+		opts.Zoom = zoom
+	}
+
+	if value := query.Get("crop"); value != "" {
+		parts := strings.Split(value, ",")
+		if len(parts) != 4 {
+			return opts, fmt.Errorf("crop must be x,y,width,height")
+		}
+		var n [4]int
+		for i, part := range parts {
+			v, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || v < 0 {
+				return opts, fmt.Errorf("crop must be x,y,width,height")
+			}
+			n[i] = v
+		}
+		if n[2] == 0 || n[3] == 0 {
+			return opts, fmt.Errorf("crop width and height must be positive")
+		}
+		opts.Crop = image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3])
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestComparison(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	h := NewImageHandler(po, history)
+
+	var upload UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &upload); status != http.StatusOK {
+		t.Fatalf("upload = %d %+v", status, upload)
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"bad split", "/api/images/job-1/compare.png?split=half", http.StatusBadRequest},
+		{"bad zoom", "/api/images/job-1/compare.png?zoom=x", http.StatusBadRequest},
+		{"bad crop", "/api/images/job-1/compare.png?crop=1,2,3", http.StatusBadRequest},
+		{"empty crop", "/api/images/job-1/compare.png?crop=1,2,0,3", http.StatusBadRequest},
+		{"unknown job", "/api/images/unknown/compare.png", http.StatusNotFound},
+		// Good quality images are stored as received, so there is nothing to compare
+		{"not upscaled", "/api/images/job-1/compare.png", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.GetImage(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.GetImage(rec, httptest.NewRequest(http.MethodPost, "/api/images/job-1/compare.png", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want 405", rec.Code)
+	}
+}
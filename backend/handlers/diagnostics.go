@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"visioncloud/services"
+)
+
+// DiagnosticsResponse represents an image diagnostics response
+type DiagnosticsResponse struct {
+	Success     bool                       `json:"success"`
+	Diagnostics *services.ImageDiagnostics `json:"diagnostics,omitempty"`
+	HeatmapURL  string                     `json:"heatmap_url,omitempty"` // sharpness overlay PNG
+	Error       string                     `json:"error,omitempty"`
+}
+
+// Diagnostics explains the quality metrics and routing decision of a job
+// GET /api/images/{job_id}/diagnostics
+func (h *ImageHandler) Diagnostics(w http.ResponseWriter, r *http.Request, jobID string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, status, err := h.lookupJob(jobID)
+	if err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(DiagnosticsResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	diagnostics, err := h.orchestrator.Diagnose(r.Context(), result)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(DiagnosticsResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to analyze stored image: %v", err),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DiagnosticsResponse{
+		Success:     true,
+		Diagnostics: diagnostics,
+		HeatmapURL:  fmt.Sprintf("/api/images/%s/diagnostics/heatmap.png", jobID),
+	})
+}
+
+// DiagnosticsHeatmap serves the local sharpness overlay of a job's stored image
+// GET /api/images/{job_id}/diagnostics/heatmap.png
+func (h *ImageHandler) DiagnosticsHeatmap(w http.ResponseWriter, r *http.Request, jobID string) {
+	result, status, err := h.lookupJob(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	heatmap, err := h.orchestrator.SharpnessHeatmap(r.Context(), result)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render heatmap: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(heatmap)
+}
+
+// lookupJob returns a job's recorded result, or an error and the HTTP status for it
+func (h *ImageHandler) lookupJob(jobID string) (*services.ProcessingResult, int, error) {
+	if h.history == nil {
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("processing history is not available")
+	}
+	result, err := h.history.Get(jobID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if result == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("job %q not found", jobID)
+	}
+	return result, http.StatusOK, nil
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	h := NewImageHandler(po, history)
+
+	var upload UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &upload); status != http.StatusOK {
+		t.Fatalf("upload = %d %+v", status, upload)
+	}
+
+	var resp DiagnosticsResponse
+	status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/job-1/diagnostics", nil), &resp)
+	if status != http.StatusOK || !resp.Success {
+		t.Errorf("diagnostics = %d %+v", status, resp)
+	}
+
+	rec := httptest.NewRecorder()
+	h.GetImage(rec, httptest.NewRequest(http.MethodGet, "/api/images/job-1/diagnostics/heatmap.png", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("heatmap = %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	resp = DiagnosticsResponse{}
+	if status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/unknown/diagnostics", nil), &resp); status != http.StatusNotFound {
+		t.Errorf("unknown job = %d, want 404", status)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"visioncloud/services"
+)
+
+// sseHeartbeatInterval keeps idle streams alive through proxies
+const sseHeartbeatInterval = 15 * time.Second
+
+// EventsHandler streams pipeline progress as Server-Sent Events
+type EventsHandler struct {
+	broker *services.EventBroker
+}
+
+// NewEventsHandler creates a new events handler
+func NewEventsHandler(broker *services.EventBroker) *EventsHandler {
+	return &EventsHandler{
+		broker: broker,
+	}
+}
+
+// JobEvents streams the stage transitions of one job, ending after the done event
+// GET /api/jobs/{id}/events
+func (h *EventsHandler) JobEvents(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	jobID, rest, _ := strings.Cut(path, "/")
+	if jobID == "" || rest != "events" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Use GET /api/jobs/{id}/events",
+		})
+		return
+	}
+
+	h.stream(w, r, jobID)
+}
+
+// AllEvents streams the stage transitions of every job, for dashboards
+// GET /api/events
+func (h *EventsHandler) AllEvents(w http.ResponseWriter, r *http.Request) {
+	h.stream(w, r, "")
+}
+
+func (h *EventsHandler) stream(w http.ResponseWriter, r *http.Request, jobID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Streams outlive the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	events, backlog, cancel := h.broker.Subscribe(jobID)
+	defer cancel()
+
+	for _, event := range backlog {
+		if writeSSE(w, event) != nil {
+			return
+		}
+		if jobID != "" && event.Stage == services.EventDone {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-events:
+			if writeSSE(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+			if jobID != "" && event.Stage == services.EventDone {
+				return
+			}
+		}
+	}
+}
+
+// writeSSE writes one event in text/event-stream framing
+func writeSSE(w http.ResponseWriter, event services.PipelineEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Stage, data)
+	return err
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"visioncloud/services"
+)
+
+func TestJobEvents(t *testing.T) {
+	broker := services.NewEventBroker(10)
+	broker.Publish(services.PipelineEvent{JobID: "job-1", Stage: services.EventAssessing})
+	broker.Publish(services.PipelineEvent{JobID: "job-2", Stage: services.EventAssessing})
+	broker.Publish(services.PipelineEvent{JobID: "job-1", Stage: services.EventDone, Status: "success"})
+	h := NewEventsHandler(broker)
+
+	// A finished job's stream replays its events and ends after done
+	rec := httptest.NewRecorder()
+	h.JobEvents(rec, httptest.NewRequest(http.MethodGet, "/api/jobs/job-1/events", nil))
+	body := rec.Body.String()
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, "event: assessing\n") || !strings.HasSuffix(body, "\n\n") || strings.Contains(body, "job-2") {
+		t.Errorf("stream = %q", body)
+	}
+	if i, j := strings.Index(body, "event: assessing"), strings.Index(body, "event: done"); i < 0 || j < i {
+		t.Errorf("events out of order: %q", body)
+	}
+
+	var resp map[string]any
+	if status := serveJSON(t, h.JobEvents, httptest.NewRequest(http.MethodGet, "/api/jobs/job-1", nil), &resp); status != http.StatusNotFound {
+		t.Errorf("GET without /events = %d, want 404", status)
+	}
+}
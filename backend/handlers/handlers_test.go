@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	"visioncloud/services"
+)
+
+const testBucket = "test-bucket"
+
+// stubS3 is an in-memory, path-style stand-in for the bucket that can list,
+// read, write and delete objects with their user metadata, encryption and
+// storage class. Signatures and checksums are not checked, and sub-resources
+// such as tagging are missing.
+type stubS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]*stubObject
+}
+
+type stubObject struct {
+	data     []byte
+	metadata http.Header // x-amz-meta-*, encryption and storage class headers as sent
+}
+
+// newStubS3 starts an empty stub bucket, closed with the test
+func newStubS3(t *testing.T) *stubS3 {
+	t.Helper()
+	stub := &stubS3{objects: make(map[string]*stubObject)}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// put seeds an object
+func (s *stubS3) put(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &stubObject{data: data, metadata: make(http.Header)}
+}
+
+// keys returns the stored keys in order
+func (s *stubS3) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// storage returns a storage service for the stub bucket
+func (s *stubS3) storage() *services.StorageService {
+	return services.NewStorageService(aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		BaseEndpoint: aws.String(s.URL),
+	}, testBucket)
+}
+
+func (s *stubS3) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	switch {
+	case key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case key == "" || r.URL.Query().Has("tagging"):
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		obj = &stubObject{data: body, metadata: make(http.Header)}
+		for name, values := range r.Header {
+			name := strings.ToLower(name)
+			if strings.HasPrefix(name, "x-amz-meta-") || name == "x-amz-server-side-encryption" || name == "x-amz-storage-class" {
+				obj.metadata[name] = values
+			}
+		}
+		s.objects[key] = obj
+		w.Header().Set("ETag", `"stub"`)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case !ok:
+		http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		header := w.Header()
+		for name, values := range obj.metadata {
+			header[name] = values
+		}
+		header.Set("Content-Length", strconv.Itoa(len(obj.data)))
+		header.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		header.Set("ETag", `"stub"`)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	default:
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+	}
+}
+
+// list answers a ListObjectsV2 request in a single page; the caller holds mu
+func (s *stubS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string
+		KeyCount int
+		Contents []content
+	}{Name: testBucket}
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				Size:         len(obj.data),
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// newTestPipeline returns a pipeline storing in the stub bucket. Its zero
+// quality threshold stores every image as good quality, without upscaling.
+func newTestPipeline(t *testing.T, stub *stubS3) *services.PipelineOrchestrator {
+	t.Helper()
+	return services.NewPipelineOrchestrator(services.NewQualityService(0), stub.storage(), "upscale.py", 2)
+}
+
+// newTestHistory opens a history store in a temporary directory
+func newTestHistory(t *testing.T) *services.HistoryStore {
+	t.Helper()
+	history, err := services.NewHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("NewHistoryStore: %v", err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+// testPNG returns a small gradient PNG
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// serveJSON runs a handler and decodes its JSON response into v, returning
+// the status code
+func serveJSON(t *testing.T, handler http.HandlerFunc, r *http.Request, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: Content-Type = %q, body %s", r.Method, r.URL, ct, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", r.Method, r.URL, err)
+	}
+	return rec.Code
+}
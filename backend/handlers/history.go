@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"visioncloud/services"
+)
+
+// HistoryHandler serves the persisted processing history
+type HistoryHandler struct {
+	history *services.HistoryStore
+	storage *services.StorageService
+}
+
+// NewHistoryHandler creates a new history handler; storage re-signs image URLs
+func NewHistoryHandler(history *services.HistoryStore, storage *services.StorageService) *HistoryHandler {
+	return &HistoryHandler{
+		history: history,
+		storage: storage,
+	}
+}
+
+// HistoryResponse represents a history listing response
+type HistoryResponse struct {
+	Success bool                  `json:"success"`
+	Page    *services.HistoryPage `json:"page,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// ListHistory lists processing results, newest first
+// GET /api/history?folder=&status=&tenant=&hash=&from=&to=&min_score=&max_score=&offset=&limit=
+func (h *HistoryHandler) ListHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(HistoryResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	page, err := h.history.Query(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(HistoryResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Recorded URLs have expired by now
+	for _, result := range page.Results {
+		h.storage.SignResultURL(r.Context(), result)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HistoryResponse{
+		Success: true,
+		Page:    page,
+	})
+}
+
+// GetHistoryEntry returns the recorded result of a single job
+// GET /api/history/{job_id}
+func (h *HistoryHandler) GetHistoryEntry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	jobID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/history/"), "/")
+	result, err := h.history.Get(jobID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Job %q not found", jobID),
+		})
+		return
+	}
+
+	h.storage.SignResultURL(r.Context(), result)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UploadImageResponse{
+		Success: true,
+		Result:  result,
+	})
+}
+
+// parseHistoryFilter builds a history filter from query parameters
+func parseHistoryFilter(query url.Values) (services.HistoryFilter, error) {
+	filter := services.HistoryFilter{
+		Folder:      query.Get("folder"),
+		Status:      query.Get("status"),
+		Tenant:      query.Get("tenant"),
+		ContentHash: query.Get("hash"),
+	}
+
+	var err error
+	if filter.From, err = services.ParseTimeBound(query.Get("from"), false); err != nil {
+		return filter, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = services.ParseTimeBound(query.Get("to"), true); err != nil {
+		return filter, fmt.Errorf("invalid to: %w", err)
+	}
+
+	for name, target := range map[string]**float64{"min_score": &filter.MinScore, "max_score": &filter.MaxScore} {
+		if val := query.Get(name); val != "" {
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = &f
+		}
+	}
+
+	for name, target := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if val := query.Get(name); val != "" {
+			i, err := strconv.Atoi(val)
+			if err != nil || i < 0 {
+				return filter, fmt.Errorf("invalid %s: %q", name, val)
+			}
+			*target = i
+		}
+	}
+	if filter.Limit > 500 {
+		filter.Limit = 500
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"visioncloud/services"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	day := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	for _, result := range []*services.ProcessingResult{
+		{JobID: "morning", Status: "success", Folder: services.FolderGoodQuality, StorageKey: "good_quality/a.png", S3URL: "https://expired", ProcessedAt: day.Add(9 * time.Hour)},
+		{JobID: "evening", Status: "error", Folder: services.FolderCouldntUpscale, ProcessedAt: day.Add(23 * time.Hour)},
+		{JobID: "next-day", Status: "success", Folder: services.FolderGoodQuality, ProcessedAt: day.AddDate(0, 0, 1)},
+	} {
+		if err := history.Record(result); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	h := NewHistoryHandler(history, stub.storage())
+
+	// A date as the upper bound covers the whole day
+	var resp HistoryResponse
+	status := serveJSON(t, h.ListHistory, httptest.NewRequest(http.MethodGet, "/api/history?from=2026-05-04&to=2026-05-04", nil), &resp)
+	if status != http.StatusOK || resp.Page == nil || len(resp.Page.Results) != 2 {
+		t.Fatalf("listing one day = %d %+v", status, resp)
+	}
+	if signed := resp.Page.Results[1]; signed.JobID != "morning" || !strings.HasPrefix(signed.S3URL, stub.URL+"/") {
+		t.Errorf("oldest result = %+v, want the morning job with a signed URL", signed)
+	}
+
+	for _, query := range []string{"from=yesterday", "to=2026-13-01", "min_score=high", "limit=-1"} {
+		resp = HistoryResponse{}
+		if status := serveJSON(t, h.ListHistory, httptest.NewRequest(http.MethodGet, "/api/history?"+query, nil), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("%s = %d %+v, want 400", query, status, resp)
+		}
+	}
+
+	var entry UploadImageResponse
+	if status := serveJSON(t, h.GetHistoryEntry, httptest.NewRequest(http.MethodGet, "/api/history/evening", nil), &entry); status != http.StatusOK || entry.Result == nil || entry.Result.Status != "error" {
+		t.Errorf("GET evening = %d %+v", status, entry)
+	}
+	entry = UploadImageResponse{}
+	if status := serveJSON(t, h.GetHistoryEntry, httptest.NewRequest(http.MethodGet, "/api/history/unknown", nil), &entry); status != http.StatusNotFound || entry.Success {
+		t.Errorf("GET unknown = %d %+v, want 404", status, entry)
+	}
+}
@@ -3,10 +3,14 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"visioncloud/services"
@@ -15,12 +19,14 @@ import (
 // ImageHandler handles image-related HTTP requests
 type ImageHandler struct {
 	orchestrator *services.PipelineOrchestrator
+	history      *services.HistoryStore
 }
 
 // NewImageHandler creates a new image handler
-func NewImageHandler(orchestrator *services.PipelineOrchestrator) *ImageHandler {
+func NewImageHandler(orchestrator *services.PipelineOrchestrator, history *services.HistoryStore) *ImageHandler {
 	return &ImageHandler{
 		orchestrator: orchestrator,
+		history:      history,
 	}
 }
 
@@ -94,12 +100,48 @@ func (h *ImageHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Clients may choose the job ID to subscribe to /api/jobs/{id}/events before
+	// the upload completes. The model falls back to the tenant's model and then
+	// the default chain; the callback URL is notified when processing finishes.
+	opts := services.ProcessOptions{
+		JobID:        r.FormValue("job_id"),
+		ModelID:      r.FormValue("model_id"),
+		OutputFormat: r.FormValue("output_format"),
+		CallbackURL:  r.FormValue("callback_url"),
+	}
+	// Tenant may come from the form or a header set by an upstream gateway
+	opts.Tenant = r.FormValue("tenant")
+	if opts.Tenant == "" {
+		opts.Tenant = r.Header.Get("X-Tenant-ID")
+	}
+	if val := r.FormValue("output_quality"); val != "" {
+		q, err := strconv.Atoi(val)
+		if err != nil || q == 0 {
+			q = -1 // rejected by validateProcessOptions
+		}
+		opts.OutputQuality = q
+	}
+	if err := h.validateProcessOptions(r.Context(), &opts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	release, ok := h.claimJobID(w, opts.JobID)
+	if !ok {
+		return
+	}
+	defer release()
+
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
 
 	// Process image through pipeline
-	result := h.orchestrator.ProcessImage(ctx, imageData, header.Filename)
+	result := h.orchestrator.ProcessImageWithOptions(ctx, imageData, header.Filename, opts)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(UploadImageResponse{
@@ -109,9 +151,105 @@ func (h *ImageHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// validateProcessOptions checks client-supplied processing options, normalizing
+// the output format name
+func (h *ImageHandler) validateProcessOptions(ctx context.Context, opts *services.ProcessOptions) error {
+	if !validJobID(opts.JobID) {
+		return fmt.Errorf("job_id may only contain letters, digits, '-' and '_' (max 64 characters)")
+	}
+	if err := services.ValidateTenant(opts.Tenant); err != nil {
+		return err
+	}
+	if opts.ModelID != "" && !h.orchestrator.Models().Has(opts.ModelID) {
+		return fmt.Errorf("Unknown model %q, see /api/models", opts.ModelID)
+	}
+
+	opts.OutputFormat = strings.ToLower(opts.OutputFormat)
+	if opts.OutputFormat == "jpg" {
+		opts.OutputFormat = services.FormatJPEG
+	}
+	if opts.OutputFormat != "" && opts.OutputFormat != services.FormatOriginal && !services.ValidOutputFormat(opts.OutputFormat) {
+		return fmt.Errorf("output_format must be one of png, jpeg, webp, original")
+	}
+	if opts.OutputQuality < 0 || opts.OutputQuality > 100 {
+		return fmt.Errorf("output_quality must be an integer between 1 and 100")
+	}
+
+	if opts.CallbackURL != "" {
+		if err := h.orchestrator.ValidateCallback(ctx, opts.CallbackURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimJobID reserves a client-chosen job ID for the duration of the request,
+// answering 409 if another job already uses it
+func (h *ImageHandler) claimJobID(w http.ResponseWriter, jobID string) (release func(), ok bool) {
+	release, err := h.orchestrator.ClaimJobID(jobID)
+	if err == nil {
+		return release, true
+	}
+
+	status := http.StatusInternalServerError
+	message := fmt.Sprintf("Failed to check job_id: %v", err)
+	if errors.Is(err, services.ErrJobExists) {
+		status = http.StatusConflict
+		message = fmt.Sprintf("job_id %q is already in use", jobID)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UploadImageResponse{
+		Success: false,
+		Error:   message,
+	})
+	return nil, false
+}
+
+// validJobID reports whether a client-supplied job ID is acceptable; empty is allowed
+func validJobID(jobID string) bool {
+	if len(jobID) > 64 {
+		return false
+	}
+	for _, c := range jobID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 // GetImage retrieves a processed image information
 // GET /api/images/{folder}/{filename}
+// Per-job views are served under the same prefix:
+// GET /api/images/{job_id}/metadata
+// GET /api/images/{job_id}/versions
+// GET /api/images/{job_id}/diagnostics
+// GET /api/images/{job_id}/diagnostics/heatmap.png
+// GET /api/images/{job_id}/compare.png
+// GET /api/images/{job_id}/thumbnail
 func (h *ImageHandler) GetImage(w http.ResponseWriter, r *http.Request) {
+	jobID, view, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/images/"), "/"), "/")
+	switch view {
+	case "metadata":
+		h.ObjectMetadata(w, r, jobID)
+		return
+	case "versions":
+		h.Versions(w, r, jobID)
+		return
+	case "diagnostics":
+		h.Diagnostics(w, r, jobID)
+		return
+	case "diagnostics/heatmap.png":
+		h.DiagnosticsHeatmap(w, r, jobID)
+		return
+	case "compare.png":
+		h.Comparison(w, r, jobID)
+		return
+	case "thumbnail":
+		h.Thumbnail(w, r, jobID)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	// In a real implementation, you'd extract folder and filename from URL params
@@ -126,21 +264,153 @@ func (h *ImageHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// ListProcessed lists all processed images in a folder
-// GET /api/images/list/{folder}
+// ObjectMetadataResponse represents a stored object metadata response
+type ObjectMetadataResponse struct {
+	Success  bool                     `json:"success"`
+	Metadata *services.ObjectMetadata `json:"metadata,omitempty"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// ObjectMetadata returns the headers, pipeline metadata, encryption, storage
+// class and tags of a job's stored image
+// GET /api/images/{job_id}/metadata
+func (h *ImageHandler) ObjectMetadata(w http.ResponseWriter, r *http.Request, jobID string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, status, err := h.lookupJob(jobID)
+	if err == nil && result.StorageKey == "" {
+		status, err = http.StatusNotFound, fmt.Errorf("job %q has no stored image", jobID)
+	}
+	if err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ObjectMetadataResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	storage := h.orchestrator.Storage()
+	metadata, err := storage.HeadObject(r.Context(), result.StorageKey)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ObjectMetadataResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	// Reading tags needs its own permission; the rest is still useful without it
+	if tags, err := storage.ObjectTags(r.Context(), result.StorageKey); err != nil {
+		log.Printf("Warning: failed to read tags of %s: %v", result.StorageKey, err)
+	} else {
+		metadata.Tags = tags
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ObjectMetadataResponse{
+		Success:  true,
+		Metadata: metadata,
+	})
+}
+
+// ImageVersionsResponse represents an original/derivative pair response
+type ImageVersionsResponse struct {
+	Success  bool                    `json:"success"`
+	Versions *services.ImageVersions `json:"versions,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+// Versions returns the original a job received and the upscaled image made
+// from it, with download URLs for comparing or reverting
+// GET /api/images/{job_id}/versions
+func (h *ImageHandler) Versions(w http.ResponseWriter, r *http.Request, jobID string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, status, err := h.lookupJob(jobID)
+	if err == nil && result.StorageKey == "" {
+		status, err = http.StatusNotFound, fmt.Errorf("job %q has no stored image", jobID)
+	}
+	if err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ImageVersionsResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	versions, err := h.orchestrator.Versions(r.Context(), result)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ImageVersionsResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ImageVersionsResponse{
+		Success:  true,
+		Versions: versions,
+	})
+}
+
+// ListImagesResponse represents a folder listing response
+type ListImagesResponse struct {
+	Success bool                   `json:"success"`
+	Folder  string                 `json:"folder"`
+	Images  []services.StoredImage `json:"images"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// ListProcessed lists all processed images in a folder with pre-signed download URLs
+// GET /api/images/list/{folder}?tenant=
 func (h *ImageHandler) ListProcessed(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// In a real implementation, you'd extract folder from URL params
-	// This is a placeholder response
+	folder := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/images/list/"), "/")
+	if folder == "" || strings.Contains(folder, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ListImagesResponse{
+			Success: false,
+			Error:   "Use GET /api/images/list/{folder}",
+		})
+		return
+	}
 
-	response := map[string]interface{}{
-		"success": true,
-		"message": "Image listing not yet implemented",
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		tenant = r.Header.Get("X-Tenant-ID")
+	}
+	if err := services.ValidateTenant(tenant); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ListImagesResponse{
+			Success: false,
+			Folder:  folder,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	images, err := h.orchestrator.ListStored(r.Context(), folder, tenant)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ListImagesResponse{
+			Success: false,
+			Folder:  folder,
+			Error:   err.Error(),
+		})
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(ListImagesResponse{
+		Success: true,
+		Folder:  folder,
+		Images:  images,
+	})
 }
 
 // HealthCheck returns the health status
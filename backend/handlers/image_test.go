@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// uploadRequest builds a multipart upload of one image with form fields
+func uploadRequest(t *testing.T, filename string, data []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, err := form.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write(data)
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/images/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func TestUploadImageJobID(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	h := NewImageHandler(po, history)
+
+	var resp UploadImageResponse
+	status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &resp)
+	if status != http.StatusOK || !resp.Success || resp.Result == nil || resp.Result.JobID != "job-1" {
+		t.Fatalf("upload = %d %+v", status, resp)
+	}
+
+	for _, tt := range []struct {
+		jobID string
+		want  int
+	}{
+		{"not a valid id!", http.StatusBadRequest},
+		{"job-1", http.StatusConflict}, // already recorded
+	} {
+		resp = UploadImageResponse{}
+		status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": tt.jobID}), &resp)
+		if status != tt.want || resp.Success {
+			t.Errorf("job_id %q = %d %+v, want %d", tt.jobID, status, resp, tt.want)
+		}
+	}
+
+	resp = UploadImageResponse{}
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.gif", testPNG(t), nil), &resp); status != http.StatusBadRequest {
+		t.Errorf("gif upload = %d, want 400", status)
+	}
+}
+
+func TestUploadImageModel(t *testing.T) {
+	stub := newStubS3(t)
+	h := NewImageHandler(newTestPipeline(t, stub), newTestHistory(t))
+
+	var resp UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"model_id": "missing"}), &resp); status != http.StatusBadRequest || resp.Success {
+		t.Errorf("unknown model = %d %+v, want 400", status, resp)
+	}
+}
+
+func TestUploadImageTenant(t *testing.T) {
+	stub := newStubS3(t)
+	h := NewImageHandler(newTestPipeline(t, stub), newTestHistory(t))
+
+	for _, tenant := range []string{"../acme", "default", "acme corp"} {
+		var resp UploadImageResponse
+		if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"tenant": tenant}), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("tenant %q = %d %+v, want 400", tenant, status, resp)
+		}
+	}
+
+	// The gateway header names the tenant when the form does not
+	r := uploadRequest(t, "cat.png", testPNG(t), nil)
+	r.Header.Set("X-Tenant-ID", "acme")
+	var resp UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, r, &resp); status != http.StatusOK || resp.Result == nil || resp.Result.Tenant != "acme" {
+		t.Errorf("upload for acme = %d %+v", status, resp)
+	}
+}
+
+func TestUploadImageOutputOptions(t *testing.T) {
+	stub := newStubS3(t)
+	h := NewImageHandler(newTestPipeline(t, stub), newTestHistory(t))
+
+	for _, tt := range []struct {
+		fields map[string]string
+		want   int
+	}{
+		{map[string]string{"output_format": "gif"}, http.StatusBadRequest},
+		{map[string]string{"output_format": "jpeg", "output_quality": "high"}, http.StatusBadRequest},
+		{map[string]string{"output_format": "jpeg", "output_quality": "0"}, http.StatusBadRequest},
+		{map[string]string{"output_format": "jpeg", "output_quality": "101"}, http.StatusBadRequest},
+		{map[string]string{"output_format": "JPG", "output_quality": "80"}, http.StatusOK},
+		{map[string]string{"output_format": "original"}, http.StatusOK},
+	} {
+		var resp UploadImageResponse
+		if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), tt.fields), &resp); status != tt.want {
+			t.Errorf("%v = %d %+v, want %d", tt.fields, status, resp, tt.want)
+		}
+	}
+}
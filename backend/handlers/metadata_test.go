@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"visioncloud/services"
+)
+
+func TestObjectMetadata(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	h := NewImageHandler(po, history)
+
+	var upload UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &upload); status != http.StatusOK {
+		t.Fatalf("upload = %d %+v", status, upload)
+	}
+	if err := history.Record(&services.ProcessingResult{JobID: "job-2", Status: "error"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	var resp ObjectMetadataResponse
+	status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/job-1/metadata", nil), &resp)
+	if status != http.StatusOK || resp.Metadata == nil || resp.Metadata.Key != upload.Result.StorageKey || resp.Metadata.ContentLength == 0 || resp.Metadata.JobID != "job-1" {
+		t.Errorf("metadata = %d %+v", status, resp.Metadata)
+	}
+
+	for _, jobID := range []string{"job-2", "unknown"} {
+		resp = ObjectMetadataResponse{}
+		if status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/"+jobID+"/metadata", nil), &resp); status != http.StatusNotFound || resp.Success {
+			t.Errorf("%s = %d %+v, want 404", jobID, status, resp)
+		}
+	}
+}
+
+func TestObjectMetadataWriteSettings(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	if err := po.Storage().SetWriteSettings(services.WriteSettings{
+		Encryption:   services.EncryptionSSES3,
+		StorageClass: "standard_ia",
+		FolderTags:   map[string]map[string]string{services.AllFolders: {"classification": "internal"}},
+	}); err != nil {
+		t.Fatalf("SetWriteSettings: %v", err)
+	}
+	h := NewImageHandler(po, history)
+
+	var upload UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &upload); status != http.StatusOK {
+		t.Fatalf("upload = %d %+v", status, upload)
+	}
+
+	// The stub cannot return tags, which leaves the rest of the response intact
+	var resp ObjectMetadataResponse
+	status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/job-1/metadata", nil), &resp)
+	if status != http.StatusOK || resp.Metadata == nil {
+		t.Fatalf("metadata = %d %+v", status, resp)
+	}
+	if resp.Metadata.Encryption != "AES256" || resp.Metadata.StorageClass != "STANDARD_IA" || resp.Metadata.Tags != nil {
+		t.Errorf("encryption %q, storage class %q, tags %v", resp.Metadata.Encryption, resp.Metadata.StorageClass, resp.Metadata.Tags)
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"visioncloud/services"
+)
+
+// ModelsHandler lists the available upscaling models
+type ModelsHandler struct {
+	models *services.ModelRegistry
+}
+
+// NewModelsHandler creates a new models handler
+func NewModelsHandler(models *services.ModelRegistry) *ModelsHandler {
+	return &ModelsHandler{
+		models: models,
+	}
+}
+
+// ModelsResponse represents the model listing response
+type ModelsResponse struct {
+	Success      bool                 `json:"success"`
+	Models       []services.ModelSpec `json:"models"`
+	DefaultChain []string             `json:"default_chain"`
+}
+
+// ListModels lists registered models and the default fallback chain
+// GET /api/models
+func (h *ModelsHandler) ListModels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ModelsResponse{
+		Success:      true,
+		Models:       h.models.Models(),
+		DefaultChain: h.models.DefaultChain(),
+	})
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"visioncloud/services"
+)
+
+func TestListModels(t *testing.T) {
+	registry, err := services.NewModelRegistry([]services.ModelSpec{
+		{ID: "fast", Type: services.ModelTypeBicubic, Scales: []int{2, 4}},
+	})
+	if err != nil {
+		t.Fatalf("NewModelRegistry: %v", err)
+	}
+	if err := registry.SetDefaultChain([]string{"fast"}); err != nil {
+		t.Fatalf("SetDefaultChain: %v", err)
+	}
+
+	var resp ModelsResponse
+	status := serveJSON(t, NewModelsHandler(registry).ListModels, httptest.NewRequest(http.MethodGet, "/api/models", nil), &resp)
+	if status != http.StatusOK || !resp.Success || len(resp.DefaultChain) != 1 || resp.DefaultChain[0] != "fast" {
+		t.Fatalf("models = %d %+v", status, resp)
+	}
+	found := false
+	for _, model := range resp.Models {
+		found = found || model.ID == "fast"
+	}
+	if !found {
+		t.Errorf("models %+v do not include the registered model", resp.Models)
+	}
+}
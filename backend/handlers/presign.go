@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"visioncloud/services"
+)
+
+// uploadExtensions are the file extensions accepted for originals
+var uploadExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+// PresignUploadRequest represents a request for a direct upload URL
+type PresignUploadRequest struct {
+	Filename string `json:"filename"`
+	Tenant   string `json:"tenant,omitempty"`
+	Size     int64  `json:"size"` // bytes the client will upload; checked before processing
+}
+
+// PresignUploadResponse represents a direct upload URL response
+type PresignUploadResponse struct {
+	Success bool                   `json:"success"`
+	Upload  *services.DirectUpload `json:"upload,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// ProcessByKeyRequest represents a request to process an uploaded original
+type ProcessByKeyRequest struct {
+	Key           string `json:"key"`
+	JobID         string `json:"job_id,omitempty"`
+	Tenant        string `json:"tenant,omitempty"`
+	ModelID       string `json:"model_id,omitempty"`
+	OutputFormat  string `json:"output_format,omitempty"`
+	OutputQuality int    `json:"output_quality,omitempty"`
+	CallbackURL   string `json:"callback_url,omitempty"`
+}
+
+// PresignUpload returns a pre-signed URL for uploading an original straight to
+// the bucket; POST /api/images/process starts processing once it is uploaded
+// POST /api/images/presign
+func (h *ImageHandler) PresignUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+		return
+	}
+
+	var req PresignUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+	var problem string
+	switch {
+	case !slices.Contains(uploadExtensions, strings.ToLower(filepath.Ext(req.Filename))):
+		problem = "Invalid image format. Supported: jpg, jpeg, png, webp"
+	case req.Size <= 0 || req.Size > services.MaxDirectUploadSize:
+		problem = fmt.Sprintf("size must be between 1 and %d bytes", services.MaxDirectUploadSize)
+	}
+	if problem != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   problem,
+		})
+		return
+	}
+	if req.Tenant == "" {
+		req.Tenant = r.Header.Get("X-Tenant-ID")
+	}
+	if err := services.ValidateTenant(req.Tenant); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	upload, err := h.orchestrator.PresignUpload(r.Context(), services.DirectUploadRequest{
+		Filename: req.Filename,
+		Tenant:   req.Tenant,
+		Size:     req.Size,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PresignUploadResponse{
+		Success: true,
+		Upload:  upload,
+	})
+}
+
+// ProcessByKey runs the pipeline on an original uploaded with a pre-signed URL
+// POST /api/images/process
+func (h *ImageHandler) ProcessByKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+		return
+	}
+
+	var req ProcessByKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   "Request body must be JSON with the uploaded key",
+		})
+		return
+	}
+
+	opts := services.ProcessOptions{
+		JobID:         req.JobID,
+		Tenant:        req.Tenant,
+		ModelID:       req.ModelID,
+		OutputFormat:  req.OutputFormat,
+		OutputQuality: req.OutputQuality,
+		CallbackURL:   req.CallbackURL,
+	}
+	if err := h.validateProcessOptions(r.Context(), &opts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	release, ok := h.claimJobID(w, opts.JobID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	result, err := h.orchestrator.ProcessStagedObject(ctx, req.Key, opts)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrUploadMismatch) {
+			status = http.StatusUnprocessableEntity
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UploadImageResponse{
+		Success: result.Status == "success",
+		Message: fmt.Sprintf("Image processed: %s", result.Status),
+		Result:  result,
+	})
+}
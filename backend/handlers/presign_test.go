@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPresignUpload(t *testing.T) {
+	stub := newStubS3(t)
+	h := NewImageHandler(newTestPipeline(t, stub), newTestHistory(t))
+
+	var resp PresignUploadResponse
+	if status := serveJSON(t, h.PresignUpload, httptest.NewRequest(http.MethodGet, "/api/images/presign", nil), &resp); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want 405", status)
+	}
+	for _, body := range []string{
+		`not json`,
+		`{"filename": "cat.gif", "size": 10}`,
+		`{"filename": "cat.png"}`,
+		`{"filename": "cat.png", "size": 2147483648}`,
+		`{"filename": "cat.png", "size": 10, "tenant": "../other"}`,
+	} {
+		resp = PresignUploadResponse{}
+		if status := serveJSON(t, h.PresignUpload, httptest.NewRequest(http.MethodPost, "/api/images/presign", strings.NewReader(body)), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("%s = %d %+v, want 400", body, status, resp)
+		}
+	}
+
+	resp = PresignUploadResponse{}
+	r := httptest.NewRequest(http.MethodPost, "/api/images/presign", strings.NewReader(`{"filename": "cat.png", "size": 10}`))
+	r.Header.Set("X-Tenant-ID", "acme")
+	status := serveJSON(t, h.PresignUpload, r, &resp)
+	if status != http.StatusOK || resp.Upload == nil || !strings.HasSuffix(resp.Upload.Key, "/cat.png") || !strings.HasPrefix(resp.Upload.Upload.URL, stub.URL+"/") {
+		t.Fatalf("presign = %d %+v", status, resp)
+	}
+}
+
+func TestListProcessed(t *testing.T) {
+	stub := newStubS3(t)
+	stub.put("good_quality/cat.png", testPNG(t))
+	h := NewImageHandler(newTestPipeline(t, stub), newTestHistory(t))
+
+	var resp ListImagesResponse
+	status := serveJSON(t, h.ListProcessed, httptest.NewRequest(http.MethodGet, "/api/images/list/good_quality", nil), &resp)
+	if status != http.StatusOK || len(resp.Images) != 1 || !strings.HasPrefix(resp.Images[0].URL, stub.URL+"/") {
+		t.Fatalf("listing = %d %+v", status, resp)
+	}
+
+	for _, target := range []string{"/api/images/list/", "/api/images/list/good_quality/cats", "/api/images/list/good_quality?tenant=default"} {
+		resp = ListImagesResponse{}
+		if status := serveJSON(t, h.ListProcessed, httptest.NewRequest(http.MethodGet, target, nil), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("%s = %d %+v, want 400", target, status, resp)
+		}
+	}
+}
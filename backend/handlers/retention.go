@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"visioncloud/services"
+)
+
+// RetentionHandler handles retention policy enforcement
+type RetentionHandler struct {
+	janitor *services.RetentionJanitor
+}
+
+// NewRetentionHandler creates a new retention handler
+func NewRetentionHandler(janitor *services.RetentionJanitor) *RetentionHandler {
+	return &RetentionHandler{
+		janitor: janitor,
+	}
+}
+
+// RetentionResponse represents the retention status/run response
+type RetentionResponse struct {
+	Success  bool                       `json:"success"`
+	Message  string                     `json:"message,omitempty"`
+	Policies []services.RetentionPolicy `json:"policies,omitempty"`
+	Metrics  *services.RetentionMetrics `json:"metrics,omitempty"`
+	Report   *services.RetentionReport  `json:"report,omitempty"`
+	Error    string                     `json:"error,omitempty"`
+}
+
+// Status returns the configured policies and the bytes reclaimed so far
+// GET /api/retention
+func (h *RetentionHandler) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	metrics := h.janitor.Metrics()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RetentionResponse{
+		Success:  true,
+		Policies: h.janitor.Policies(),
+		Metrics:  &metrics,
+	})
+}
+
+// Run enforces the retention policies now; ?dry_run=true only reports what
+// would be deleted or transitioned
+// POST /api/retention/run
+func (h *RetentionHandler) Run(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(RetentionResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	report, err := h.janitor.Enforce(ctx, dryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if report == nil {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(RetentionResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Retention run failed: %v", err),
+			Report:  report,
+		})
+		return
+	}
+
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RetentionResponse{
+		Success: true,
+		Message: fmt.Sprintf("%s %d objects (%d bytes), %d transitioned", verb, report.Deleted, report.BytesReclaimed, report.Transitioned),
+		Report:  report,
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"visioncloud/services"
+)
+
+func TestRetention(t *testing.T) {
+	stub := newStubS3(t)
+	po := newTestPipeline(t, stub)
+	// Staged copies of the same file by two jobs are versions of one key
+	older := po.Layout().Key(services.FolderProcessing, "", time.Now(), "job-1/cat.png")
+	newer := po.Layout().Key(services.FolderProcessing, "", time.Now(), "job-2/cat.png")
+	stub.put(older, testPNG(t))
+	stub.put(newer, testPNG(t))
+
+	janitor, err := services.NewRetentionJanitor(po, po.Storage(), []services.RetentionPolicy{
+		{Folder: services.FolderProcessing, KeepLatest: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewRetentionJanitor: %v", err)
+	}
+	h := NewRetentionHandler(janitor)
+
+	var resp RetentionResponse
+	if status := serveJSON(t, h.Status, httptest.NewRequest(http.MethodGet, "/api/retention", nil), &resp); status != http.StatusOK || len(resp.Policies) != 1 || resp.Metrics == nil {
+		t.Errorf("status = %d %+v", status, resp)
+	}
+
+	resp = RetentionResponse{}
+	if status := serveJSON(t, h.Run, httptest.NewRequest(http.MethodGet, "/api/retention/run", nil), &resp); status != http.StatusMethodNotAllowed || resp.Success {
+		t.Errorf("GET run = %d %+v, want 405", status, resp)
+	}
+
+	// A dry run reports one of the two versions without deleting it
+	resp = RetentionResponse{}
+	status := serveJSON(t, h.Run, httptest.NewRequest(http.MethodPost, "/api/retention/run?dry_run=true", nil), &resp)
+	if status != http.StatusOK || resp.Report == nil || !resp.Report.DryRun || resp.Report.Scanned != 2 || resp.Report.Deleted != 1 || len(resp.Report.Actions) != 1 {
+		t.Fatalf("dry run = %d %+v", status, resp)
+	}
+	if keys := stub.keys(); !slices.Equal(keys, []string{older, newer}) && !slices.Equal(keys, []string{newer, older}) {
+		t.Errorf("dry run left %v", keys)
+	}
+
+	resp = RetentionResponse{}
+	status = serveJSON(t, h.Run, httptest.NewRequest(http.MethodPost, "/api/retention/run", nil), &resp)
+	if status != http.StatusOK || resp.Report == nil || resp.Report.Deleted != 1 || resp.Report.Failed != 0 {
+		t.Fatalf("run = %d %+v", status, resp)
+	}
+	deleted := resp.Report.Actions[0].Key
+	if keys := stub.keys(); len(keys) != 1 || keys[0] == deleted {
+		t.Errorf("after deleting %s the bucket holds %v", deleted, keys)
+	}
+}
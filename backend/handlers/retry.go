@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"visioncloud/services"
+)
+
+// RetryHandler handles retries of images in the couldn't_upscale folder
+type RetryHandler struct {
+	retrier *services.FailedImageRetrier
+}
+
+// NewRetryHandler creates a new retry handler
+func NewRetryHandler(retrier *services.FailedImageRetrier) *RetryHandler {
+	return &RetryHandler{
+		retrier: retrier,
+	}
+}
+
+// RetryFailedResponse represents the retry response
+type RetryFailedResponse struct {
+	Success bool                        `json:"success"`
+	Message string                      `json:"message,omitempty"`
+	Report  *services.RetryFailedReport `json:"report,omitempty"`
+	Error   string                      `json:"error,omitempty"`
+}
+
+// RetryFailed re-runs the pipeline on images in couldn't_upscale
+// POST /api/images/retry
+func (h *RetryHandler) RetryFailed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(RetryFailedResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+		return
+	}
+
+	// An empty body retries everything
+	var opts services.RetryFailedOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(RetryFailedResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid retry request: %v", err),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	report, err := h.retrier.RetryFailed(ctx, opts)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(RetryFailedResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Retry failed: %v", err),
+			Report:  report,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RetryFailedResponse{
+		Success: true,
+		Message: fmt.Sprintf("Retried %d images, %d recovered", report.Retried, report.Recovered),
+		Report:  report,
+	})
+}
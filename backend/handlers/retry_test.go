@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"visioncloud/services"
+)
+
+func TestRetryFailed(t *testing.T) {
+	stub := newStubS3(t)
+	h := NewRetryHandler(services.NewFailedImageRetrier(newTestPipeline(t, stub), stub.storage()))
+
+	var resp RetryFailedResponse
+	if status := serveJSON(t, h.RetryFailed, httptest.NewRequest(http.MethodGet, "/api/images/retry", nil), &resp); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want 405", status)
+	}
+	if status := serveJSON(t, h.RetryFailed, httptest.NewRequest(http.MethodPost, "/api/images/retry", strings.NewReader("{")), &resp); status != http.StatusBadRequest {
+		t.Errorf("invalid body = %d, want 400", status)
+	}
+
+	// An empty body retries everything, which here is nothing
+	resp = RetryFailedResponse{}
+	status := serveJSON(t, h.RetryFailed, httptest.NewRequest(http.MethodPost, "/api/images/retry", nil), &resp)
+	if status != http.StatusOK || !resp.Success || resp.Report == nil || resp.Report.Retried != 0 {
+		t.Errorf("empty retry = %d %+v", status, resp)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"visioncloud/services"
+)
+
+// ShadowHandler serves shadow model comparisons
+type ShadowHandler struct {
+	history *services.HistoryStore
+}
+
+// NewShadowHandler creates a new shadow testing handler
+func NewShadowHandler(history *services.HistoryStore) *ShadowHandler {
+	return &ShadowHandler{
+		history: history,
+	}
+}
+
+// ShadowResponse represents a shadow comparison listing or report
+type ShadowResponse struct {
+	Success     bool                         `json:"success"`
+	Report      *services.ShadowReport       `json:"report,omitempty"`
+	Comparisons []*services.ShadowComparison `json:"comparisons,omitempty"`
+	Error       string                       `json:"error,omitempty"`
+}
+
+// Report summarizes which model wins the recorded comparisons
+// GET /api/shadow/report?candidate=&since=
+func (h *ShadowHandler) Report(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	since, err := services.ParseTimeBound(r.URL.Query().Get("since"), false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ShadowResponse{
+			Success: false,
+			Error:   fmt.Sprintf("invalid since: %v", err),
+		})
+		return
+	}
+
+	comparisons, err := h.history.ListComparisons(r.URL.Query().Get("candidate"), since, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ShadowResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ShadowResponse{
+		Success: true,
+		Report:  services.SummarizeComparisons(comparisons),
+	})
+}
+
+// ListComparisons lists individual comparison records, newest first
+// GET /api/shadow/comparisons?candidate=&since=&limit=
+func (h *ShadowHandler) ListComparisons(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	since, err := services.ParseTimeBound(query.Get("since"), false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ShadowResponse{
+			Success: false,
+			Error:   fmt.Sprintf("invalid since: %v", err),
+		})
+		return
+	}
+
+	limit := 50
+	if val := query.Get("limit"); val != "" {
+		if limit, err = strconv.Atoi(val); err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ShadowResponse{
+				Success: false,
+				Error:   fmt.Sprintf("invalid limit: %q", val),
+			})
+			return
+		}
+	}
+	limit = min(limit, 500)
+
+	comparisons, err := h.history.ListComparisons(query.Get("candidate"), since, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ShadowResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ShadowResponse{
+		Success:     true,
+		Comparisons: comparisons,
+	})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"visioncloud/services"
+)
+
+func TestShadowHandler(t *testing.T) {
+	history := newTestHistory(t)
+	day := time.Date(2026, 5, 4, 12, 0, 0, 0, time.UTC)
+	for i, winner := range []string{"primary", "candidate", "candidate"} {
+		err := history.RecordComparison(&services.ShadowComparison{
+			ID:        string(rune('a' + i)),
+			JobID:     "job",
+			CreatedAt: day.AddDate(0, 0, i-1),
+			Primary:   services.ModelScore{Model: "primary"},
+			Candidate: services.ModelScore{Model: "candidate"},
+			Winner:    winner,
+		})
+		if err != nil {
+			t.Fatalf("RecordComparison: %v", err)
+		}
+	}
+	h := NewShadowHandler(history)
+
+	var resp ShadowResponse
+	status := serveJSON(t, h.Report, httptest.NewRequest(http.MethodGet, "/api/shadow/report?candidate=candidate&since=2026-05-04", nil), &resp)
+	if status != http.StatusOK || resp.Report == nil || resp.Report.Comparisons != 2 || resp.Report.Leader != "candidate" {
+		t.Errorf("report = %d %+v", status, resp.Report)
+	}
+
+	resp = ShadowResponse{}
+	status = serveJSON(t, h.ListComparisons, httptest.NewRequest(http.MethodGet, "/api/shadow/comparisons?limit=1", nil), &resp)
+	if status != http.StatusOK || len(resp.Comparisons) != 1 || resp.Comparisons[0].ID != "c" {
+		t.Errorf("newest comparison = %d %+v", status, resp.Comparisons)
+	}
+
+	for _, tt := range []struct {
+		handler http.HandlerFunc
+		query   string
+	}{
+		{h.Report, "since=last-week"},
+		{h.ListComparisons, "since=2026-05"},
+		{h.ListComparisons, "limit=0"},
+	} {
+		resp = ShadowResponse{}
+		if status := serveJSON(t, tt.handler, httptest.NewRequest(http.MethodGet, "/api/shadow/report?"+tt.query, nil), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("%s = %d %+v, want 400", tt.query, status, resp)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"visioncloud/services"
+)
+
+// SweepHandler handles bucket sweep requests
+type SweepHandler struct {
+	sweeper       *services.BucketSweeper
+	checkpointDir string
+}
+
+// NewSweepHandler creates a new sweep handler. Sweeps keep their checkpoints in
+// checkpointDir, one file per prefix; an empty directory disables them.
+func NewSweepHandler(sweeper *services.BucketSweeper, checkpointDir string) *SweepHandler {
+	return &SweepHandler{
+		sweeper:       sweeper,
+		checkpointDir: checkpointDir,
+	}
+}
+
+// SweepResponse represents the sweep start/status response
+type SweepResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message,omitempty"`
+	Report  *services.SweepReport `json:"report,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// StartSweep starts reprocessing existing objects under a prefix in the background
+// POST /api/sweep
+func (h *SweepHandler) StartSweep(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(SweepResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+		return
+	}
+
+	var opts services.SweepOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SweepResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid sweep request: %v", err),
+		})
+		return
+	}
+
+	if opts.SourcePrefix == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SweepResponse{
+			Success: false,
+			Error:   "source_prefix is required",
+		})
+		return
+	}
+
+	// Clients cannot name the checkpoint file; a repeated sweep of the same
+	// prefix resumes from the server's own checkpoint
+	if h.checkpointDir != "" {
+		opts.CheckpointPath = services.SweepCheckpointPath(h.checkpointDir, opts.SourcePrefix)
+	}
+
+	report, err := h.sweeper.Start(opts)
+	if errors.Is(err, services.ErrSweepRunning) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(SweepResponse{
+			Success: false,
+			Error:   err.Error(),
+			Report:  h.sweeper.Status(),
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SweepResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SweepResponse{
+		Success: true,
+		Message: fmt.Sprintf("Sweep of %q started", report.SourcePrefix),
+		Report:  report,
+	})
+}
+
+// SweepStatus returns progress of the current or most recent sweep
+// GET /api/sweep/status
+func (h *SweepHandler) SweepStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := h.sweeper.Status()
+	if status == nil {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SweepResponse{
+			Success: true,
+			Message: "No sweep has been run",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SweepResponse{
+		Success: true,
+		Report:  status,
+	})
+}
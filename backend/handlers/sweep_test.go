@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"visioncloud/services"
+)
+
+func TestStartSweep(t *testing.T) {
+	stub := newStubS3(t)
+	stub.put("raw/cat.png", testPNG(t))
+	checkpointDir := t.TempDir()
+	h := NewSweepHandler(services.NewBucketSweeper(newTestPipeline(t, stub), stub.storage()), checkpointDir)
+
+	var resp SweepResponse
+	if status := serveJSON(t, h.SweepStatus, httptest.NewRequest(http.MethodGet, "/api/sweep/status", nil), &resp); status != http.StatusOK || resp.Report != nil {
+		t.Errorf("status before any sweep = %d %+v", status, resp)
+	}
+	if status := serveJSON(t, h.StartSweep, httptest.NewRequest(http.MethodGet, "/api/sweep", nil), &resp); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want 405", status)
+	}
+	for _, body := range []string{`{"concurrency": 2}`, `{"source_prefix": 3}`} {
+		resp = SweepResponse{}
+		if status := serveJSON(t, h.StartSweep, httptest.NewRequest(http.MethodPost, "/api/sweep", strings.NewReader(body)), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("POST %s = %d %+v, want 400", body, status, resp)
+		}
+	}
+
+	// A checkpoint path sent by the client is ignored in favor of the server's own
+	clientPath := filepath.Join(t.TempDir(), "client.json")
+	body := fmt.Sprintf(`{"source_prefix": "raw", "checkpoint_path": %q}`, clientPath)
+	resp = SweepResponse{}
+	if status := serveJSON(t, h.StartSweep, httptest.NewRequest(http.MethodPost, "/api/sweep", strings.NewReader(body)), &resp); status != http.StatusAccepted || resp.Report == nil {
+		t.Fatalf("POST = %d %+v, want 202 with a report", status, resp)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp = SweepResponse{}
+		serveJSON(t, h.SweepStatus, httptest.NewRequest(http.MethodGet, "/api/sweep/status", nil), &resp)
+		if resp.Report != nil && !resp.Report.Running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sweep did not finish: %+v", resp.Report)
+		}
+	}
+	if report := resp.Report; report.Listed != 1 || report.Processed != 1 || report.Failed != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := os.Stat(services.SweepCheckpointPath(checkpointDir, "raw")); err != nil {
+		t.Errorf("server checkpoint: %v", err)
+	}
+	if _, err := os.Stat(clientPath); !os.IsNotExist(err) {
+		t.Errorf("client checkpoint path was written: %v", err)
+	}
+}
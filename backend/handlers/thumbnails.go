@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"visioncloud/services"
+)
+
+// Thumbnail serves a preview of a job's stored image, generating it if the
+// image predates thumbnails. Without a size the smallest configured size is
+// served. Responses carry an ETag so browsers can revalidate cached copies.
+// GET /api/images/{job_id}/thumbnail?size=256
+func (h *ImageHandler) Thumbnail(w http.ResponseWriter, r *http.Request, jobID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sizes := h.orchestrator.Thumbnails().Sizes
+	if len(sizes) == 0 {
+		http.Error(w, "Thumbnails are disabled", http.StatusNotFound)
+		return
+	}
+	size := sizes[0]
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || !slices.Contains(sizes, size) {
+			http.Error(w, fmt.Sprintf("size must be one of %v", sizes), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, status, err := h.lookupJob(jobID)
+	if err == nil && result.StorageKey == "" {
+		status, err = http.StatusNotFound, fmt.Errorf("job %q has no stored image", jobID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	thumbnail, err := h.orchestrator.Thumbnail(r.Context(), result.StorageKey, size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load thumbnail: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	sum := sha256.Sum256(thumbnail)
+	w.Header().Set("Content-Type", services.DetectContentType(thumbnail, ""))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(thumbnail))
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"visioncloud/services"
+)
+
+func TestThumbnail(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	h := NewImageHandler(po, history)
+
+	var upload UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &upload); status != http.StatusOK {
+		t.Fatalf("upload = %d %+v", status, upload)
+	}
+	if err := history.Record(&services.ProcessingResult{JobID: "job-2", Status: "error"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.GetImage(rec, httptest.NewRequest(http.MethodGet, "/api/images/job-1/thumbnail", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("thumbnail while disabled = %d, want 404", rec.Code)
+	}
+
+	// The image predates thumbnails, so it is generated on request
+	if err := po.SetThumbnails(services.ThumbnailSettings{Sizes: []int{32, 16}, Format: services.FormatWebP}); err != nil {
+		t.Fatalf("SetThumbnails: %v", err)
+	}
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"size not configured", "/api/images/job-1/thumbnail?size=8", http.StatusBadRequest},
+		{"no stored image", "/api/images/job-2/thumbnail", http.StatusNotFound},
+		{"unknown job", "/api/images/unknown/thumbnail", http.StatusNotFound},
+		{"default size", "/api/images/job-1/thumbnail", http.StatusOK},
+		{"chosen size", "/api/images/job-1/thumbnail?size=32", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.GetImage(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+		if tt.status == http.StatusOK && (rec.Header().Get("Content-Type") != "image/webp" || rec.Header().Get("ETag") == "") {
+			t.Errorf("%s: Content-Type %q, ETag %q", tt.name, rec.Header().Get("Content-Type"), rec.Header().Get("ETag"))
+		}
+	}
+
+	// Browsers revalidate with the ETag
+	rec = httptest.NewRecorder()
+	h.GetImage(rec, httptest.NewRequest(http.MethodGet, "/api/images/job-1/thumbnail?size=32", nil))
+	r := httptest.NewRequest(http.MethodGet, "/api/images/job-1/thumbnail?size=32", nil)
+	r.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.GetImage(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation = %d, want 304", rec.Code)
+	}
+}
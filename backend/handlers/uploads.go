@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"visioncloud/services"
+)
+
+// UploadsHandler handles direct-to-storage uploads
+type UploadsHandler struct {
+	images *ImageHandler
+}
+
+// NewUploadsHandler creates a new uploads handler; processing options are
+// validated like those of the image upload endpoint
+func NewUploadsHandler(images *ImageHandler) *UploadsHandler {
+	return &UploadsHandler{
+		images: images,
+	}
+}
+
+// UploadResponse represents a signed upload response
+type UploadResponse struct {
+	Success bool   `json:"success"`
+	Key     string `json:"key,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// InitUpload reserves a key and returns a signed URL for uploading an original
+// of the given size and SHA-256 without passing the bytes through this server.
+// When local signing is configured the URL points back at PUT /api/uploads/{key}.
+// POST /api/uploads/init
+func (h *UploadsHandler) InitUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+		return
+	}
+
+	var req services.DirectUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	var problem string
+	switch {
+	case !slices.Contains(uploadExtensions, strings.ToLower(filepath.Ext(req.Filename))):
+		problem = "Invalid image format. Supported: jpg, jpeg, png, webp"
+	case req.Size <= 0 || req.Size > services.MaxDirectUploadSize:
+		problem = fmt.Sprintf("size must be between 1 and %d bytes", services.MaxDirectUploadSize)
+	case req.SHA256 == "":
+		problem = "sha256 of the image is required"
+	}
+	if problem != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   problem,
+		})
+		return
+	}
+	if req.Tenant == "" {
+		req.Tenant = r.Header.Get("X-Tenant-ID")
+	}
+
+	upload, err := h.images.orchestrator.PresignUpload(r.Context(), req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PresignUploadResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PresignUploadResponse{
+		Success: true,
+		Upload:  upload,
+	})
+}
+
+// Upload routes requests for a single upload key
+// PUT /api/uploads/{key}?expires=&signature=... (locally signed URLs only)
+// POST /api/uploads/{key}/complete
+func (h *UploadsHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, services.UploadPathPrefix)
+	switch {
+	case r.Method == http.MethodPut:
+		h.receive(w, r, key)
+	case r.Method == http.MethodPost && strings.HasSuffix(key, "/complete"):
+		h.CompleteUpload(w, r, strings.TrimSuffix(key, "/complete"))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(UploadResponse{
+			Success: false,
+			Error:   "Use POST /api/uploads/init, then POST /api/uploads/{key}/complete",
+		})
+	}
+}
+
+// CompleteUpload verifies the uploaded original against the size and checksum
+// given at init and runs the pipeline on it. The optional JSON body carries
+// the same processing options as POST /api/images/process.
+// POST /api/uploads/{key}/complete
+func (h *UploadsHandler) CompleteUpload(w http.ResponseWriter, r *http.Request, key string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ProcessByKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	opts := services.ProcessOptions{
+		JobID:         req.JobID,
+		Tenant:        req.Tenant,
+		ModelID:       req.ModelID,
+		OutputFormat:  req.OutputFormat,
+		OutputQuality: req.OutputQuality,
+		CallbackURL:   req.CallbackURL,
+	}
+	if err := h.images.validateProcessOptions(r.Context(), &opts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	release, ok := h.images.claimJobID(w, opts.JobID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	result, err := h.images.orchestrator.ProcessStagedObject(ctx, key, opts)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrUploadMismatch) {
+			status = http.StatusUnprocessableEntity
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(UploadImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UploadImageResponse{
+		Success: result.Status == "success",
+		Message: fmt.Sprintf("Image processed: %s", result.Status),
+		Result:  result,
+	})
+}
+
+// receive stores the body of a PUT to a locally signed upload URL
+func (h *UploadsHandler) receive(w http.ResponseWriter, r *http.Request, key string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	signer := h.images.orchestrator.UploadSigner()
+	if signer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(UploadResponse{
+			Success: false,
+			Error:   "Uploads go directly to storage; use the URL returned by /api/uploads/init",
+		})
+		return
+	}
+
+	metadata, err := signer.Verify(key, r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(UploadResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	limit := int64(services.MaxDirectUploadSize)
+	if size, err := strconv.ParseInt(metadata[services.MetadataExpectedSize], 10, 64); err == nil {
+		limit = size
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(UploadResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to read upload: %v", err),
+		})
+		return
+	}
+
+	if _, err := h.images.orchestrator.Storage().UploadObject(r.Context(), key, data, metadata); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(UploadResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UploadResponse{
+		Success: true,
+		Key:     key,
+	})
+}
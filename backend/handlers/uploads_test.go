@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"visioncloud/services"
+)
+
+// initUpload starts a direct upload of data, declaring sum as its checksum
+func initUpload(t *testing.T, h *UploadsHandler, data []byte, sum string) *services.DirectUpload {
+	t.Helper()
+	body := fmt.Sprintf(`{"filename": "cat.png", "size": %d, "sha256": %q}`, len(data), sum)
+	var resp PresignUploadResponse
+	if status := serveJSON(t, h.InitUpload, httptest.NewRequest(http.MethodPost, "/api/uploads/init", strings.NewReader(body)), &resp); status != http.StatusOK || resp.Upload == nil {
+		t.Fatalf("init = %d %+v", status, resp)
+	}
+	return resp.Upload
+}
+
+func TestDirectUpload(t *testing.T) {
+	stub := newStubS3(t)
+	po := newTestPipeline(t, stub)
+	po.SetUploadSigner(services.NewUploadSigner("secret", time.Minute))
+	h := NewUploadsHandler(NewImageHandler(po, newTestHistory(t)))
+	data := testPNG(t)
+	sum := sha256.Sum256(data)
+
+	var resp PresignUploadResponse
+	if status := serveJSON(t, h.InitUpload, httptest.NewRequest(http.MethodGet, "/api/uploads/init", nil), &resp); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET init = %d, want 405", status)
+	}
+	for _, body := range []string{
+		`{"filename": "cat.png", "size": 10}`,
+		`{"filename": "cat.png", "sha256": "` + hex.EncodeToString(sum[:]) + `"}`,
+		`{"filename": "cat.bmp", "size": 10, "sha256": "` + hex.EncodeToString(sum[:]) + `"}`,
+	} {
+		resp = PresignUploadResponse{}
+		if status := serveJSON(t, h.InitUpload, httptest.NewRequest(http.MethodPost, "/api/uploads/init", strings.NewReader(body)), &resp); status != http.StatusBadRequest || resp.Success {
+			t.Errorf("init %s = %d %+v, want 400", body, status, resp)
+		}
+	}
+
+	// The URL points back at this server, which stores the body and processes it on completion
+	upload := initUpload(t, h, data, hex.EncodeToString(sum[:]))
+	if !strings.HasPrefix(upload.Upload.URL, services.UploadPathPrefix+upload.Key+"?") {
+		t.Fatalf("upload URL = %q", upload.Upload.URL)
+	}
+	var put UploadResponse
+	if status := serveJSON(t, h.Upload, httptest.NewRequest(http.MethodPut, upload.Upload.URL+"x", bytes.NewReader(data)), &put); status != http.StatusForbidden {
+		t.Errorf("PUT with a bad signature = %d, want 403", status)
+	}
+	if status := serveJSON(t, h.Upload, httptest.NewRequest(http.MethodPut, upload.Upload.URL, bytes.NewReader(append(data, 0))), &put); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT of more than the declared size = %d, want 413", status)
+	}
+	put = UploadResponse{}
+	if status := serveJSON(t, h.Upload, httptest.NewRequest(http.MethodPut, upload.Upload.URL, bytes.NewReader(data)), &put); status != http.StatusOK || put.Key != upload.Key {
+		t.Fatalf("PUT = %d %+v", status, put)
+	}
+	var done UploadImageResponse
+	if status := serveJSON(t, h.Upload, httptest.NewRequest(http.MethodPost, services.UploadPathPrefix+upload.Key+"/complete", nil), &done); status != http.StatusOK || !done.Success {
+		t.Errorf("complete = %d %+v", status, done)
+	}
+
+	// An upload that does not match its checksum is refused
+	other := sha256.Sum256([]byte("something else"))
+	upload = initUpload(t, h, data, hex.EncodeToString(other[:]))
+	serveJSON(t, h.Upload, httptest.NewRequest(http.MethodPut, upload.Upload.URL, bytes.NewReader(data)), &put)
+	done = UploadImageResponse{}
+	if status := serveJSON(t, h.Upload, httptest.NewRequest(http.MethodPost, services.UploadPathPrefix+upload.Key+"/complete", nil), &done); status != http.StatusUnprocessableEntity || done.Success {
+		t.Errorf("complete with the wrong checksum = %d %+v, want 422", status, done)
+	}
+
+	if status := serveJSON(t, h.Upload, httptest.NewRequest(http.MethodGet, services.UploadPathPrefix+upload.Key, nil), &put); status != http.StatusNotFound {
+		t.Errorf("GET upload = %d, want 404", status)
+	}
+}
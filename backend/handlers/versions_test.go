@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"visioncloud/services"
+)
+
+func TestVersions(t *testing.T) {
+	stub := newStubS3(t)
+	history := newTestHistory(t)
+	po := newTestPipeline(t, stub)
+	po.SetHistoryStore(history)
+	h := NewImageHandler(po, history)
+
+	var upload UploadImageResponse
+	if status := serveJSON(t, h.UploadImage, uploadRequest(t, "cat.png", testPNG(t), map[string]string{"job_id": "job-1"}), &upload); status != http.StatusOK {
+		t.Fatalf("upload = %d %+v", status, upload)
+	}
+	if err := history.Record(&services.ProcessingResult{JobID: "job-2", Status: "error"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	// Good quality images are stored as received, so the stored image is the original
+	var resp ImageVersionsResponse
+	status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/job-1/versions", nil), &resp)
+	if status != http.StatusOK || resp.Versions == nil {
+		t.Fatalf("versions = %d %+v", status, resp)
+	}
+	original := resp.Versions.Original
+	if original == nil || original.Key != upload.Result.StorageKey || !strings.HasPrefix(original.URL, stub.URL) || resp.Versions.Derivative != nil {
+		t.Errorf("versions = %+v", resp.Versions)
+	}
+
+	for _, jobID := range []string{"job-2", "unknown"} {
+		resp = ImageVersionsResponse{}
+		if status := serveJSON(t, h.GetImage, httptest.NewRequest(http.MethodGet, "/api/images/"+jobID+"/versions", nil), &resp); status != http.StatusNotFound || resp.Success {
+			t.Errorf("%s = %d %+v, want 404", jobID, status, resp)
+		}
+	}
+}
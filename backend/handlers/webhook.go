@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"visioncloud/services"
+)
+
+// WebhookHandler exposes the webhook delivery log and replays
+type WebhookHandler struct {
+	webhooks *services.WebhookNotifier
+	history  *services.HistoryStore
+}
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(webhooks *services.WebhookNotifier, history *services.HistoryStore) *WebhookHandler {
+	return &WebhookHandler{
+		webhooks: webhooks,
+		history:  history,
+	}
+}
+
+// WebhookDeliveriesResponse represents the delivery log response
+type WebhookDeliveriesResponse struct {
+	Success    bool                        `json:"success"`
+	Deliveries []*services.WebhookDelivery `json:"deliveries,omitempty"`
+	Delivery   *services.WebhookDelivery   `json:"delivery,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+}
+
+// ListDeliveries lists logged webhook deliveries
+// GET /api/webhooks/deliveries?job_id=&status=
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	deliveries, err := h.history.ListDeliveries(r.URL.Query().Get("job_id"), r.URL.Query().Get("status"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+		Success:    true,
+		Deliveries: deliveries,
+	})
+}
+
+// ReplayDelivery re-sends a logged webhook delivery
+// POST /api/webhooks/deliveries/{id}/replay
+func (h *WebhookHandler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/webhooks/deliveries/")
+	deliveryID, action, _ := strings.Cut(path, "/")
+	if r.Method != http.MethodPost || action != "replay" || deliveryID == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+			Success: false,
+			Error:   "Use POST /api/webhooks/deliveries/{id}/replay",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	delivery, err := h.webhooks.Replay(ctx, deliveryID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if delivery == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Delivery %q not found", deliveryID),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+		Success:  delivery.Status == services.DeliveryDelivered,
+		Delivery: delivery,
+	})
+}
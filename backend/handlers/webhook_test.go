@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"visioncloud/services"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	var received atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	t.Cleanup(receiver.Close)
+
+	history := newTestHistory(t)
+	notifier := services.NewWebhookNotifier("secret", nil, services.RetryPolicy{MaxAttempts: 1}, history)
+	notifier.SetAllowedHosts([]string{"127.0.0.1"})
+	h := NewWebhookHandler(notifier, history)
+
+	notifier.Notify(&services.ProcessingResult{JobID: "job-1", Status: "success"}, receiver.URL)
+	var resp WebhookDeliveriesResponse
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp = WebhookDeliveriesResponse{}
+		serveJSON(t, h.ListDeliveries, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries?job_id=job-1&status=delivered", nil), &resp)
+		if len(resp.Deliveries) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery was not logged: %+v", resp)
+		}
+	}
+
+	id := resp.Deliveries[0].ID
+	resp = WebhookDeliveriesResponse{}
+	status := serveJSON(t, h.ReplayDelivery, httptest.NewRequest(http.MethodPost, "/api/webhooks/deliveries/"+id+"/replay", nil), &resp)
+	if status != http.StatusOK || !resp.Success || resp.Delivery == nil || received.Load() != 2 {
+		t.Errorf("replay = %d %+v after %d requests", status, resp, received.Load())
+	}
+
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodGet, "/api/webhooks/deliveries/" + id + "/replay"},
+		{http.MethodPost, "/api/webhooks/deliveries/" + id},
+		{http.MethodPost, "/api/webhooks/deliveries/unknown/replay"},
+	} {
+		resp = WebhookDeliveriesResponse{}
+		if status := serveJSON(t, h.ReplayDelivery, httptest.NewRequest(tt.method, tt.path, nil), &resp); status != http.StatusNotFound || resp.Success {
+			t.Errorf("%s %s = %d %+v, want 404", tt.method, tt.path, status, resp)
+		}
+	}
+}
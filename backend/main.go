@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,13 +19,23 @@ import (
 	"visioncloud/services"
 )
 
-func main() {
-	// Load configuration
-	cfg := appconfig.LoadConfig()
-
-	// Initialize AWS configuration
-	ctx := context.Background()
+// application bundles the services shared by the HTTP server and CLI commands
+type application struct {
+	cfg            *appconfig.Config
+	storageService *services.StorageService
+	orchestrator   *services.PipelineOrchestrator
+	sweeper        *services.BucketSweeper
+	retrier        *services.FailedImageRetrier
+	janitor        *services.RetentionJanitor
+	spool          *services.Spool
+	history        *services.HistoryStore
+	webhooks       *services.WebhookNotifier
+	events         *services.EventBroker
+}
 
+// newApplication initializes AWS and the backend services. Without noHistory
+// an unavailable history store is fatal.
+func newApplication(ctx context.Context, cfg *appconfig.Config, noHistory bool) *application {
 	awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
 		awsconfig.WithRegion(cfg.AWSRegion),
 	)
@@ -33,7 +45,14 @@ func main() {
 
 	// Initialize services
 	qualityService := services.NewQualityService(cfg.QualityThreshold)
-	storageService := services.NewStorageService(awsCfg, cfg.S3Bucket)
+	qualityService.MaxPixels = cfg.DecodeMaxPixels
+	storageService := services.NewStorageServiceWithOptions(awsCfg, cfg.S3Bucket, services.S3Options{
+		Endpoint:     cfg.S3Endpoint,
+		UsePathStyle: cfg.S3UsePathStyle,
+		DisableTLS:   cfg.S3DisableTLS,
+	})
+	storageService.SetCacheControl(cfg.S3CacheControl)
+	storageService.SetURLExpiry(cfg.S3URLExpiry, cfg.S3UploadURLExpiry)
 	orchestrator := services.NewPipelineOrchestrator(
 		qualityService,
 		storageService,
@@ -41,17 +60,255 @@ func main() {
 		cfg.UpscaleScale,
 	)
 
+	// A target quality score is converted to pixels; the larger target wins
+	targetPixels := cfg.UpscaleTargetPixels
+	if fromScore := qualityService.PixelsForScore(cfg.UpscaleTargetQuality); fromScore > targetPixels {
+		targetPixels = fromScore
+	}
+	orchestrator.SetScalePolicy(services.ScalePolicy{
+		TargetPixels:    targetPixels,
+		MaxOutputPixels: cfg.UpscaleMaxOutputPixels,
+	})
+
+	layout, err := services.NewFolderLayout(cfg.FolderKeyTemplate, cfg.FolderNames)
+	if err != nil {
+		log.Fatalf("invalid folder layout: %v", err)
+	}
+	orchestrator.SetFolderLayout(layout, cfg.FolderStaging)
+	orchestrator.SetKeepOriginals(cfg.KeepOriginals)
+	if err := orchestrator.SetThumbnails(services.ThumbnailSettings{
+		Sizes:   cfg.ThumbnailSizes,
+		Format:  cfg.ThumbnailFormat,
+		Quality: cfg.ThumbnailQuality,
+	}); err != nil {
+		log.Fatalf("invalid thumbnail settings: %v", err)
+	}
+
+	folderTags, err := services.ParseFolderTags(cfg.S3FolderTags)
+	if err != nil {
+		log.Fatalf("invalid object tags: %v", err)
+	}
+	if err := storageService.SetWriteSettings(services.WriteSettings{
+		Encryption:   cfg.S3Encryption,
+		KMSKeyID:     cfg.S3KMSKeyID,
+		StorageClass: cfg.S3StorageClass,
+		FolderTags:   folderTags,
+		Layout:       layout,
+	}); err != nil {
+		log.Fatalf("invalid object write settings: %v", err)
+	}
+
+	models, err := services.LoadModelRegistry(cfg.ModelRegistryPath, cfg.UpscaleScript)
+	if err != nil {
+		log.Fatalf("unable to load model registry: %v", err)
+	}
+	if err := models.SetDefaultChain(cfg.UpscaleFallbackChain); err != nil {
+		log.Fatalf("invalid upscaling fallback chain: %v", err)
+	}
+	if err := models.SetTenantModels(cfg.TenantModels); err != nil {
+		log.Fatalf("invalid tenant models: %v", err)
+	}
+	if err := models.SetContentModels(cfg.ContentModels); err != nil {
+		log.Fatalf("invalid content models: %v", err)
+	}
+	models.Wrap(func(spec services.ModelSpec, upscaler services.Upscaler) services.Upscaler {
+		// Models with an input limit are tiled early enough that tiles fit within it
+		opts := services.TileOptions{
+			TileSize:        cfg.TileSize,
+			Overlap:         cfg.TileOverlap,
+			ThresholdPixels: cfg.TileThresholdPixels,
+			Concurrency:     cfg.TileConcurrency,
+		}
+		if spec.MaxInputPixels > 0 {
+			opts.ThresholdPixels = min(opts.ThresholdPixels, spec.MaxInputPixels)
+			opts.TileSize = min(opts.TileSize, int(math.Sqrt(float64(spec.MaxInputPixels))))
+		}
+		return services.NewTiledUpscaler(upscaler, opts)
+	})
+	orchestrator.SetModelRegistry(models)
+
+	// Rules are checked against the scales of the models they use
+	rules, err := services.LoadRoutingRules(cfg.RoutingRulesPath)
+	if err != nil {
+		log.Fatalf("unable to load routing rules: %v", err)
+	}
+	router, err := services.NewRouter(rules, qualityService.QualityThreshold, models)
+	if err != nil {
+		log.Fatalf("invalid routing rules: %v", err)
+	}
+	orchestrator.SetRouter(router)
+
+	var modelClassifier services.Classifier
+	if cfg.ClassifierEndpoint != "" {
+		modelClassifier = services.NewHTTPClassifier(cfg.ClassifierEndpoint)
+	}
+	orchestrator.SetClassifier(services.NewContentClassifier(modelClassifier, cfg.ClassifierMinConfidence))
+	if err := orchestrator.SetOutputFormats(cfg.OutputFormat, cfg.ContentOutputFormats, cfg.OutputJPEGQuality); err != nil {
+		log.Fatalf("invalid output format configuration: %v", err)
+	}
+	switch cfg.UploadURLMode {
+	case "presigned":
+	case "local":
+		orchestrator.SetUploadSigner(services.NewUploadSigner(cfg.UploadSigningSecret, cfg.S3UploadURLExpiry))
+	default:
+		log.Fatalf("invalid UPLOAD_URL_MODE %q: use presigned or local", cfg.UploadURLMode)
+	}
+	orchestrator.SetRetryPolicies(
+		services.RetryPolicy{
+			MaxAttempts:    cfg.UpscaleMaxAttempts,
+			InitialBackoff: cfg.RetryInitialBackoff,
+			MaxBackoff:     cfg.RetryMaxBackoff,
+			AttemptTimeout: cfg.UpscaleAttemptTimeout,
+		},
+		services.RetryPolicy{
+			MaxAttempts:    cfg.StorageMaxAttempts,
+			InitialBackoff: cfg.RetryInitialBackoff,
+			MaxBackoff:     cfg.RetryMaxBackoff,
+		},
+	)
+
+	events := services.NewEventBroker(1000)
+	orchestrator.OnEvent(events.Publish)
+
+	spool, err := services.NewSpool(cfg.SpoolDir, storageService)
+	if err != nil {
+		log.Fatalf("unable to initialize spool: %v", err)
+	}
+	orchestrator.SetSpool(spool)
+
+	// BoltDB allows a single writer process, so CLI commands run alongside the
+	// server must opt out of recording history with -no-history
+	var history *services.HistoryStore
+	if !noHistory {
+		history, err = services.NewHistoryStore(cfg.HistoryDBPath)
+		if err != nil {
+			log.Fatalf("history store unavailable (pass -no-history to run a command alongside the server): %v", err)
+		}
+	}
+
+	var webhooks *services.WebhookNotifier
+	if history != nil {
+		orchestrator.SetHistoryStore(history)
+
+		if cfg.WebhookSecret == "" {
+			log.Printf("Warning: WEBHOOK_SECRET is not set, webhook signatures are not secret")
+		}
+		webhooks = services.NewWebhookNotifier(cfg.WebhookSecret, cfg.TenantWebhooks, services.RetryPolicy{
+			MaxAttempts:    cfg.WebhookMaxAttempts,
+			InitialBackoff: cfg.RetryInitialBackoff,
+			MaxBackoff:     cfg.RetryMaxBackoff,
+		}, history)
+		webhooks.SetAllowedHosts(cfg.CallbackHosts)
+		orchestrator.SetWebhookNotifier(webhooks)
+
+		if cfg.ShadowModel != "" {
+			shadow, err := services.NewShadowTester(models, cfg.ShadowModel, cfg.ShadowPercent, cfg.ShadowConcurrency, history)
+			if err != nil {
+				log.Fatalf("invalid shadow testing configuration: %v", err)
+			}
+			orchestrator.SetShadowTester(shadow)
+		}
+	}
+
+	policies, err := services.LoadRetentionPolicies(cfg.RetentionPoliciesPath)
+	if err != nil {
+		log.Fatalf("unable to load retention policies: %v", err)
+	}
+	janitor, err := services.NewRetentionJanitor(orchestrator, storageService, policies)
+	if err != nil {
+		log.Fatalf("invalid retention policies: %v", err)
+	}
+
+	return &application{
+		cfg:            cfg,
+		storageService: storageService,
+		orchestrator:   orchestrator,
+		sweeper:        services.NewBucketSweeper(orchestrator, storageService),
+		retrier:        services.NewFailedImageRetrier(orchestrator, storageService),
+		janitor:        janitor,
+		spool:          spool,
+		history:        history,
+		webhooks:       webhooks,
+		events:         events,
+	}
+}
+
+// close releases resources held by the services
+func (app *application) close() {
+	if app.history != nil {
+		app.history.Close()
+	}
+}
+
+func main() {
+	// Load configuration
+	cfg := appconfig.LoadConfig()
+
+	// Usage: visioncloud [-no-history] [command [flags]]
+	noHistory := flag.Bool("no-history", false, "run a command without recording history, e.g. while the server holds the history database")
+	flag.Parse()
+
+	// Initialize AWS configuration
+	ctx := context.Background()
+	app := newApplication(ctx, cfg, *noHistory)
+
+	// Subcommands run once and exit instead of starting the server
+	if flag.NArg() > 0 {
+		code := runCommand(ctx, app, flag.Arg(0), flag.Args()[1:])
+		app.close()
+		os.Exit(code)
+	}
+
+	if app.history == nil {
+		log.Fatalf("history store is required to run the server")
+	}
+
+	// Retry spooled storage writes in the background
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+	go app.spool.Run(bgCtx, cfg.SpoolRetryInterval)
+	if cfg.RetentionInterval > 0 {
+		go app.janitor.Run(bgCtx, cfg.RetentionInterval)
+	}
+
 	// Initialize handlers
-	imageHandler := handlers.NewImageHandler(orchestrator)
+	imageHandler := handlers.NewImageHandler(app.orchestrator, app.history)
+	uploadsHandler := handlers.NewUploadsHandler(imageHandler)
+	sweepHandler := handlers.NewSweepHandler(app.sweeper, cfg.SweepCheckpointDir)
+	retryHandler := handlers.NewRetryHandler(app.retrier)
+	retentionHandler := handlers.NewRetentionHandler(app.janitor)
+	historyHandler := handlers.NewHistoryHandler(app.history, app.storageService)
+	webhookHandler := handlers.NewWebhookHandler(app.webhooks, app.history)
+	eventsHandler := handlers.NewEventsHandler(app.events)
+	modelsHandler := handlers.NewModelsHandler(app.orchestrator.Models())
+	shadowHandler := handlers.NewShadowHandler(app.history)
 
 	// Set up router with CORS
 	mux := http.NewServeMux()
 
 	// Register routes
 	mux.HandleFunc("/api/images/upload", imageHandler.UploadImage)
+	mux.HandleFunc("/api/images/presign", imageHandler.PresignUpload)
+	mux.HandleFunc("/api/images/process", imageHandler.ProcessByKey)
+	mux.HandleFunc("/api/uploads/init", uploadsHandler.InitUpload)
+	mux.HandleFunc("/api/uploads/", uploadsHandler.Upload)
 	mux.HandleFunc("/api/images/", imageHandler.GetImage)
 	mux.HandleFunc("/api/images/list/", imageHandler.ListProcessed)
+	mux.HandleFunc("/api/images/retry", retryHandler.RetryFailed)
 	mux.HandleFunc("/api/health", imageHandler.HealthCheck)
+	mux.HandleFunc("/api/models", modelsHandler.ListModels)
+	mux.HandleFunc("/api/shadow/report", shadowHandler.Report)
+	mux.HandleFunc("/api/shadow/comparisons", shadowHandler.ListComparisons)
+	mux.HandleFunc("/api/history", historyHandler.ListHistory)
+	mux.HandleFunc("/api/history/", historyHandler.GetHistoryEntry)
+	mux.HandleFunc("/api/jobs/", eventsHandler.JobEvents)
+	mux.HandleFunc("/api/events", eventsHandler.AllEvents)
+	mux.HandleFunc("/api/webhooks/deliveries", webhookHandler.ListDeliveries)
+	mux.HandleFunc("/api/webhooks/deliveries/", webhookHandler.ReplayDelivery)
+	mux.HandleFunc("/api/sweep", sweepHandler.StartSweep)
+	mux.HandleFunc("/api/sweep/status", sweepHandler.SweepStatus)
+	mux.HandleFunc("/api/retention", retentionHandler.Status)
+	mux.HandleFunc("/api/retention/run", retentionHandler.Run)
 
 	// Wrap with CORS middleware
 	handler := withCORS(mux)
@@ -91,6 +348,7 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	app.close()
 	fmt.Println("VisionCloud server exited")
 }
 
@@ -100,7 +358,7 @@ func withCORS(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
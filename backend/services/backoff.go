@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// RetryPolicy configures exponential backoff for retryable operations
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first, minimum 1
+	InitialBackoff time.Duration // wait before the second attempt
+	MaxBackoff     time.Duration // upper bound for a single wait
+	AttemptTimeout time.Duration // per-attempt deadline, 0 means none
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		AttemptTimeout: 2 * time.Minute,
+	}
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that retryWithBackoff gives up immediately
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable reports whether err may succeed on another attempt
+func IsRetryable(err error) bool {
+	var perm *permanentError
+	return err != nil && !errors.As(err, &perm) && !errors.Is(err, context.Canceled)
+}
+
+// permanentStorageCodes are S3 error codes for requests that will be refused
+// again however often they are retried
+var permanentStorageCodes = map[string]bool{
+	"AccessDenied":          true,
+	"AllAccessDisabled":     true,
+	"EntityTooLarge":        true,
+	"InvalidAccessKeyId":    true,
+	"InvalidArgument":       true,
+	"InvalidBucketName":     true,
+	"InvalidRequest":        true,
+	"InvalidStorageClass":   true,
+	"KeyTooLongError":       true,
+	"MetadataTooLarge":      true,
+	"NoSuchBucket":          true,
+	"SignatureDoesNotMatch": true,
+}
+
+// classifyStorageError marks storage errors caused by the request itself, such
+// as missing permissions or a missing bucket, as permanent. Throttling, server
+// errors and network failures are left retryable.
+func classifyStorageError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && permanentStorageCodes[apiErr.ErrorCode()] {
+		return Permanent(err)
+	}
+	return err
+}
+
+// retryWithBackoff calls fn until it succeeds, returns a permanent error, the
+// attempts are exhausted or ctx is done. It returns the number of attempts made.
+func retryWithBackoff(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) (int, error) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	backoff := policy.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runAttempt(ctx, policy.AttemptTimeout, fn)
+		if err == nil || !IsRetryable(err) || attempt >= policy.MaxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func runAttempt(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return fn(attemptCtx)
+}
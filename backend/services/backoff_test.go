@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestRetryWithBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 15 * time.Millisecond}
+	errTransient := errors.New("transient")
+
+	// Waits double up to the cap: 10ms, then 15ms twice
+	var calls []time.Time
+	attempts, err := retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+		calls = append(calls, time.Now())
+		return errTransient
+	})
+	if attempts != 4 || !errors.Is(err, errTransient) {
+		t.Fatalf("retryWithBackoff = %d, %v; want 4 attempts", attempts, err)
+	}
+	for i, minWait := range []time.Duration{10 * time.Millisecond, 15 * time.Millisecond, 15 * time.Millisecond} {
+		if wait := calls[i+1].Sub(calls[i]); wait < minWait || wait > minWait+50*time.Millisecond {
+			t.Errorf("wait before attempt %d = %v, want about %v", i+2, wait, minWait)
+		}
+	}
+
+	attempts, err = retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+		if len(calls) < 6 {
+			calls = append(calls, time.Now())
+			return errTransient
+		}
+		return nil
+	})
+	if attempts != 3 || err != nil {
+		t.Errorf("recovering operation = %d, %v; want success on attempt 3", attempts, err)
+	}
+
+	attempts, err = retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+		return Permanent(errTransient)
+	})
+	if attempts != 1 || IsRetryable(err) || !errors.Is(err, errTransient) {
+		t.Errorf("permanent failure = %d, %v; want one attempt", attempts, err)
+	}
+}
+
+func TestRetryWithBackoffStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}
+	attempts, err := retryWithBackoff(ctx, policy, func(ctx context.Context) error {
+		cancel()
+		return errors.New("transient")
+	})
+	if attempts != 1 || err == nil {
+		t.Errorf("retryWithBackoff = %d, %v; want to stop after the cancelled attempt", attempts, err)
+	}
+
+	// Each attempt gets its own deadline
+	policy = RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, AttemptTimeout: 5 * time.Millisecond}
+	attempts, err = retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if attempts != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timed out attempts = %d, %v", attempts, err)
+	}
+}
+
+func TestClassifyStorageError(t *testing.T) {
+	for code, retryable := range map[string]bool{
+		"AccessDenied":       false,
+		"NoSuchBucket":       false,
+		"InvalidArgument":    false,
+		"SlowDown":           true,
+		"InternalError":      true,
+		"ServiceUnavailable": true,
+	} {
+		err := fmt.Errorf("upload failed: %w", &smithy.GenericAPIError{Code: code, Message: "test"})
+		if got := IsRetryable(classifyStorageError(err)); got != retryable {
+			t.Errorf("%s retryable = %v, want %v", code, got, retryable)
+		}
+	}
+	if err := classifyStorageError(errors.New("connection reset")); !IsRetryable(err) {
+		t.Error("network error is not retryable")
+	}
+}
+
+func TestUploadDoesNotRetryMissingBucket(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+	po := NewPipelineOrchestrator(NewQualityService(0.5), newTestStorage(fake, "missing-bucket"), "upscale.py", 2)
+	po.SetRetryPolicies(DefaultRetryPolicy(), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	_, err := po.uploadWithRetry(context.Background(), "upscaled/a.png", testPNG(t, 4, 4), nil)
+	if err == nil || IsRetryable(err) {
+		t.Fatalf("uploadWithRetry = %v, want a permanent error", err)
+	}
+	puts := 0
+	for _, request := range fake.requests {
+		if strings.HasPrefix(request, "PUT ") {
+			puts++
+		}
+	}
+	if puts != 1 {
+		t.Errorf("upload to a missing bucket was sent %d times, want 1", puts)
+	}
+}
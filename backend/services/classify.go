@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Content classes assigned by the classification stage
+const (
+	ContentPhoto        = "photo"
+	ContentScreenshot   = "screenshot"
+	ContentDocument     = "document"
+	ContentIllustration = "illustration"
+	ContentLineArt      = "line_art"
+	ContentIcon         = "icon"
+)
+
+// ContentClasses lists every content class
+var ContentClasses = []string{ContentPhoto, ContentScreenshot, ContentDocument, ContentIllustration, ContentLineArt, ContentIcon}
+
+// classifyMaxEdge bounds the long edge of the copy the heuristics look at
+const classifyMaxEdge = 256
+
+// Classification is the content class of an image
+type Classification struct {
+	Label      string             `json:"label"`
+	Confidence float64            `json:"confidence"`
+	Source     string             `json:"source"` // classifier that produced the label
+	Features   map[string]float64 `json:"features,omitempty"`
+}
+
+// Classifier labels image content
+type Classifier interface {
+	// Name identifies the classifier in Classification.Source
+	Name() string
+	// Classify returns the content class of an image
+	Classify(ctx context.Context, imageData []byte) (*Classification, error)
+}
+
+// HeuristicClassifier labels images from cheap pixel statistics: palette size,
+// edge density, flat-area share, saturation and the luma histogram
+type HeuristicClassifier struct{}
+
+// NewHeuristicClassifier creates the built-in heuristic classifier
+func NewHeuristicClassifier() *HeuristicClassifier {
+	return &HeuristicClassifier{}
+}
+
+// Name returns the classifier name
+func (hc *HeuristicClassifier) Name() string {
+	return "heuristic"
+}
+
+// Classify decodes the image and labels it from its features
+func (hc *HeuristicClassifier) Classify(ctx context.Context, imageData []byte) (*Classification, error) {
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return hc.classifyImage(img), nil
+}
+
+// classifyImage labels an already decoded image
+func (hc *HeuristicClassifier) classifyImage(img image.Image) *Classification {
+	features := contentFeatures(img)
+	label, confidence := labelFromFeatures(features)
+	return &Classification{
+		Label:      label,
+		Confidence: confidence,
+		Source:     hc.Name(),
+		Features:   features,
+	}
+}
+
+// contentFeatures measures the statistics the heuristics are based on. The
+// image is shrunk with nearest-neighbour sampling so no blended colours are
+// introduced into the palette.
+func contentFeatures(img image.Image) map[string]float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	sw, sh := width, height
+	if edge := max(width, height); edge > classifyMaxEdge {
+		ratio := float64(classifyMaxEdge) / float64(edge)
+		sw, sh = max(1, int(float64(width)*ratio)), max(1, int(float64(height)*ratio))
+	}
+	small := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.NearestNeighbor.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+
+	n := sw * sh
+	palette := make(map[uint32]struct{})
+	var saturationSum, grayCount, flatCount, edgeCount, transparentCount float64
+	var lumaBins [8]float64
+	gray := make([]float64, n)
+
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			i := y*small.Stride + x*4
+			r, g, b, a := small.Pix[i], small.Pix[i+1], small.Pix[i+2], small.Pix[i+3]
+			palette[uint32(r)<<16|uint32(g)<<8|uint32(b)] = struct{}{}
+
+			hi, lo := max(r, g, b), min(r, g, b)
+			saturation := 0.0
+			if hi > 0 {
+				saturation = float64(hi-lo) / float64(hi)
+			}
+			saturationSum += saturation
+			if hi-lo < 16 {
+				grayCount++
+			}
+			if a < 250 {
+				transparentCount++
+			}
+
+			luma := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			gray[y*sw+x] = luma
+			lumaBins[min(int(luma)/32, 7)]++
+
+			if x > 0 && small.Pix[i-4] == r && small.Pix[i-3] == g && small.Pix[i-2] == b {
+				flatCount++
+			}
+		}
+	}
+
+	for y := 1; y < sh-1; y++ {
+		for x := 1; x < sw-1; x++ {
+			i := y*sw + x
+			gx := gray[i+1] - gray[i-1]
+			gy := gray[i+sw] - gray[i-sw]
+			if math.Hypot(gx, gy) > 64 {
+				edgeCount++
+			}
+		}
+	}
+
+	slices.Sort(lumaBins[:])
+	total := float64(n)
+	return map[string]float64{
+		"width":           float64(width),
+		"height":          float64(height),
+		"palette_size":    float64(len(palette)),
+		"palette_ratio":   float64(len(palette)) / total,
+		"mean_saturation": saturationSum / total,
+		"gray_fraction":   grayCount / total,
+		"flat_fraction":   flatCount / total,
+		"edge_density":    edgeCount / total,
+		"transparent":     transparentCount / total,
+		"top_luma_bins":   (lumaBins[7] + lumaBins[6]) / total, // share of the two most common luma bands
+	}
+}
+
+// labelFromFeatures applies the classification rules, most specific first
+func labelFromFeatures(f map[string]float64) (string, float64) {
+	longEdge := max(f["width"], f["height"])
+	aspect := f["width"] / math.Max(f["height"], 1)
+
+	switch {
+	case longEdge <= 256 && aspect >= 0.75 && aspect <= 1.33 &&
+		(f["palette_size"] <= 64 || f["flat_fraction"] > 0.5 || f["transparent"] > 0.05):
+		// Small and square is not enough: thumbnails of photos are too, but
+		// icons are drawn with few colors, flat areas or a transparent background
+		return ContentIcon, 0.8
+	case f["transparent"] > 0.2 && longEdge <= 512:
+		return ContentIcon, 0.6
+	case f["gray_fraction"] > 0.95 && f["top_luma_bins"] > 0.9:
+		// Two-tone, mostly monochrome: text pages have far more edges than drawings
+		if f["edge_density"] > 0.08 {
+			return ContentDocument, 0.7
+		}
+		return ContentLineArt, 0.7
+	case f["flat_fraction"] > 0.6 && f["edge_density"] > 0.04 && f["mean_saturation"] < 0.35:
+		return ContentScreenshot, 0.6
+	case f["flat_fraction"] > 0.4 && f["palette_ratio"] < 0.2:
+		return ContentIllustration, 0.6
+	case f["flat_fraction"] > 0.6:
+		return ContentScreenshot, 0.5
+	default:
+		return ContentPhoto, math.Min(0.5+f["palette_ratio"], 0.9)
+	}
+}
+
+// HTTPClassifier asks a classification service for the label. The service
+// receives the image as the POST body and responds with
+// {"label": "...", "confidence": 0.0-1.0}.
+type HTTPClassifier struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPClassifier creates a classifier backed by an HTTP endpoint
+func NewHTTPClassifier(endpoint string) *HTTPClassifier {
+	return &HTTPClassifier{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name returns the classifier name
+func (hc *HTTPClassifier) Name() string {
+	return "model"
+}
+
+// Classify POSTs the image to the endpoint
+func (hc *HTTPClassifier) Classify(ctx context.Context, imageData []byte) (*Classification, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hc.endpoint, bytes.NewReader(imageData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("classifier request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read classifier response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("classifier responded with %s: %s", resp.Status, truncate(string(body), 200))
+	}
+
+	var classification Classification
+	if err := json.Unmarshal(body, &classification); err != nil {
+		return nil, fmt.Errorf("failed to parse classifier response: %w", err)
+	}
+	if !slices.Contains(ContentClasses, classification.Label) {
+		return nil, fmt.Errorf("classifier returned unknown label %q", classification.Label)
+	}
+	classification.Source = hc.Name()
+	return &classification, nil
+}
+
+// ContentClassifier runs the model-backed classifier when one is configured and
+// falls back to the heuristics when it fails or is not confident enough
+type ContentClassifier struct {
+	model         Classifier
+	heuristic     *HeuristicClassifier
+	minConfidence float64
+}
+
+// NewContentClassifier creates the classification stage; model may be nil
+func NewContentClassifier(model Classifier, minConfidence float64) *ContentClassifier {
+	return &ContentClassifier{
+		model:         model,
+		heuristic:     NewHeuristicClassifier(),
+		minConfidence: minConfidence,
+	}
+}
+
+// Classify labels an image
+func (cc *ContentClassifier) Classify(ctx context.Context, imageData []byte) (*Classification, error) {
+	if classification := cc.classifyWithModel(ctx, imageData); classification != nil {
+		return classification, nil
+	}
+	return cc.heuristic.Classify(ctx, imageData)
+}
+
+// ClassifyImage is Classify for an image the pipeline already decoded. The
+// model still receives the encoded data; the heuristics use img.
+func (cc *ContentClassifier) ClassifyImage(ctx context.Context, imageData []byte, img image.Image) *Classification {
+	if classification := cc.classifyWithModel(ctx, imageData); classification != nil {
+		return classification
+	}
+	return cc.heuristic.classifyImage(img)
+}
+
+// classifyWithModel returns the model's label, or nil if there is no model or
+// it failed or was not confident enough
+func (cc *ContentClassifier) classifyWithModel(ctx context.Context, imageData []byte) *Classification {
+	if cc.model == nil {
+		return nil
+	}
+	classification, err := cc.model.Classify(ctx, imageData)
+	if err != nil {
+		log.Printf("Warning: model classifier failed, using heuristics: %v", err)
+		return nil
+	}
+	if classification.Confidence < cc.minConfidence {
+		return nil
+	}
+	return classification
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testIcon returns a PNG of a flat colored square on a transparent background
+func testIcon(t *testing.T, size int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := size / 4; y < size*3/4; y++ {
+		for x := size / 4; x < size*3/4; x++ {
+			img.Set(x, y, color.NRGBA{R: 30, G: 144, B: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestLabelFromFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		features map[string]float64
+		want     string
+	}{
+		{"small square", map[string]float64{"width": 128, "height": 128, "palette_size": 12}, ContentIcon},
+		{"photo thumbnail", map[string]float64{"width": 128, "height": 128, "palette_size": 9000, "palette_ratio": 0.55, "flat_fraction": 0.05}, ContentPhoto},
+		{"transparent sticker", map[string]float64{"width": 400, "height": 200, "transparent": 0.5}, ContentIcon},
+		{"text page", map[string]float64{"width": 1200, "height": 1600, "gray_fraction": 1, "top_luma_bins": 0.95, "edge_density": 0.15}, ContentDocument},
+		{"ink drawing", map[string]float64{"width": 1200, "height": 1600, "gray_fraction": 1, "top_luma_bins": 0.95, "edge_density": 0.02}, ContentLineArt},
+		{"app window", map[string]float64{"width": 1920, "height": 1080, "flat_fraction": 0.8, "edge_density": 0.06, "mean_saturation": 0.1}, ContentScreenshot},
+		{"cartoon", map[string]float64{"width": 1920, "height": 1080, "flat_fraction": 0.5, "palette_ratio": 0.05, "mean_saturation": 0.7}, ContentIllustration},
+		{"photo", map[string]float64{"width": 4000, "height": 3000, "flat_fraction": 0.1, "palette_ratio": 0.6}, ContentPhoto},
+	}
+	for _, tt := range tests {
+		if got, confidence := labelFromFeatures(tt.features); got != tt.want || confidence <= 0 || confidence > 1 {
+			t.Errorf("%s: label = %q (%v), want %q", tt.name, got, confidence, tt.want)
+		}
+	}
+}
+
+func TestContentClassifierFallsBackToHeuristics(t *testing.T) {
+	var response string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	classifier := NewContentClassifier(NewHTTPClassifier(server.URL), 0.6)
+	ctx := context.Background()
+	icon := testIcon(t, 32)
+
+	tests := []struct {
+		name       string
+		status     int
+		response   string
+		wantLabel  string
+		wantSource string
+	}{
+		{"confident model", http.StatusOK, `{"label": "document", "confidence": 0.9}`, ContentDocument, "model"},
+		{"unsure model", http.StatusOK, `{"label": "document", "confidence": 0.3}`, ContentIcon, "heuristic"},
+		{"unknown label", http.StatusOK, `{"label": "meme", "confidence": 0.9}`, ContentIcon, "heuristic"},
+		{"model error", http.StatusInternalServerError, `overloaded`, ContentIcon, "heuristic"},
+	}
+	for _, tt := range tests {
+		status, response = tt.status, tt.response
+		classification, err := classifier.Classify(ctx, icon)
+		if err != nil {
+			t.Fatalf("%s: Classify: %v", tt.name, err)
+		}
+		if classification.Label != tt.wantLabel || classification.Source != tt.wantSource {
+			t.Errorf("%s: classification = %+v, want %s from %s", tt.name, classification, tt.wantLabel, tt.wantSource)
+		}
+	}
+
+	if _, err := NewHeuristicClassifier().Classify(ctx, []byte("not an image")); err == nil {
+		t.Error("heuristic classifier accepted undecodable data")
+	}
+}
+
+func TestHeuristicClassifierDecodedImages(t *testing.T) {
+	// A small square JPEG with smooth shading and sensor-like noise
+	photo := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for y := range 128 {
+		for x := range 128 {
+			noise := rng.IntN(24)
+			photo.Set(x, y, color.RGBA{uint8(80 + x/2 + noise), uint8(60 + y/2 + noise), uint8(40 + (x+y)/4 + noise), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, photo, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+
+	classifier := NewHeuristicClassifier()
+	for name, tt := range map[string]struct {
+		data []byte
+		want string
+	}{
+		"photo thumbnail": {buf.Bytes(), ContentPhoto},
+		"icon":            {testIcon(t, 64), ContentIcon},
+	} {
+		classification, err := classifier.Classify(context.Background(), tt.data)
+		if err != nil {
+			t.Fatalf("%s: Classify: %v", name, err)
+		}
+		if classification.Label != tt.want {
+			t.Errorf("%s: label = %q, want %q", name, classification.Label, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// Comparison layouts
+const (
+	CompareSideBySide = "side-by-side"
+	CompareSlider     = "slider"
+)
+
+// Comparison rendering limits and spacing
+const (
+	comparisonMaxEdge    = 2048 // panels larger than this are scaled down unless cropped
+	comparisonMaxZoom    = 16
+	comparisonGap        = 8
+	comparisonLabelBar   = 18
+	differenceAmplifying = 4 // small differences are hard to see at their true level
+)
+
+// ErrCannotCompare is returned for invalid comparison options and for jobs
+// without both an original and an upscaled image
+var ErrCannotCompare = errors.New("cannot compare")
+
+// ComparisonOptions controls a before/after comparison image
+type ComparisonOptions struct {
+	Layout     string          // side-by-side (default) or slider
+	Split      float64         // slider: fraction of the width showing the original; 0 means half
+	Crop       image.Rectangle // region of the upscaled image to show; empty shows all of it
+	Zoom       int             // nearest-neighbor magnification of the crop; 0 means none
+	Difference bool            // add a panel mapping where the two differ
+}
+
+// Compare renders a PNG comparing the original of an upscaled job, enlarged
+// with nearest-neighbor to the upscaled size, with the upscaled output
+func (po *PipelineOrchestrator) Compare(ctx context.Context, result *ProcessingResult, opts ComparisonOptions) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCannotCompare, err)
+	}
+	if result.Folder != FolderUpscaled || result.StorageKey == "" {
+		return nil, fmt.Errorf("%w: job %s has no upscaled image", ErrCannotCompare, result.JobID)
+	}
+	if result.OriginalCopy == "" {
+		return nil, fmt.Errorf("%w: the original of job %s was not kept", ErrCannotCompare, result.JobID)
+	}
+
+	after, err := po.loadImage(ctx, result.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	original, err := po.loadImage(ctx, result.OriginalCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	beforePanel, afterPanel, err := opts.panels(original, after)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCannotCompare, err)
+	}
+
+	upscaledLabel := fmt.Sprintf("Upscaled %dx", result.UpscaleScale)
+	if result.UpscaleStrategy != "" {
+		upscaledLabel += " (" + result.UpscaleStrategy + ")"
+	}
+
+	var panels []*image.RGBA
+	var labels []string
+	if opts.Layout == CompareSlider {
+		panels = append(panels, sliderPanel(beforePanel, afterPanel, opts.Split))
+		labels = append(labels, "Original | "+upscaledLabel)
+	} else {
+		panels = append(panels, beforePanel, afterPanel)
+		labels = append(labels, "Original (nearest-neighbor)", upscaledLabel)
+	}
+	if opts.Difference {
+		panels = append(panels, differenceMap(beforePanel, afterPanel))
+		labels = append(labels, "Difference")
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, composePanels(panels, labels)); err != nil {
+		return nil, fmt.Errorf("failed to encode comparison: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// validate checks the options and fills in defaults
+func (opts *ComparisonOptions) validate() error {
+	switch opts.Layout {
+	case "":
+		opts.Layout = CompareSideBySide
+	case CompareSideBySide, CompareSlider:
+	default:
+		return fmt.Errorf("unknown comparison layout %q: use %s or %s", opts.Layout, CompareSideBySide, CompareSlider)
+	}
+	if opts.Split == 0 {
+		opts.Split = 0.5
+	}
+	if opts.Split <= 0 || opts.Split >= 1 {
+		return fmt.Errorf("split must be between 0 and 1")
+	}
+	if opts.Zoom == 0 {
+		opts.Zoom = 1
+	}
+	if opts.Zoom < 1 || opts.Zoom > comparisonMaxZoom {
+		return fmt.Errorf("zoom must be between 1 and %d", comparisonMaxZoom)
+	}
+	if opts.Zoom > 1 && opts.Crop.Empty() {
+		return fmt.Errorf("zoom needs a crop region")
+	}
+	return nil
+}
+
+// panels renders the original and the upscaled image at their final panel
+// size: both cropped and zoomed alike, or scaled down to fit the size limit
+// when there is no crop. The original is enlarged with nearest-neighbor
+// straight into its panel, so no full-size copy of either image is made.
+func (opts ComparisonOptions) panels(original, after image.Image) (*image.RGBA, *image.RGBA, error) {
+	bounds := after.Bounds()
+	region := bounds
+	if opts.Crop.Empty() {
+		edge := max(bounds.Dx(), bounds.Dy())
+		if edge > comparisonMaxEdge {
+			ratio := float64(comparisonMaxEdge) / float64(edge)
+			width, height := max(1, int(float64(bounds.Dx())*ratio)), max(1, int(float64(bounds.Dy())*ratio))
+			return resizeTo(original, width, height), resizeTo(after, width, height), nil
+		}
+	} else {
+		region = opts.Crop.Intersect(bounds)
+		if region.Empty() {
+			return nil, nil, fmt.Errorf("crop region %v is outside the %dx%d upscaled image", opts.Crop, bounds.Dx(), bounds.Dy())
+		}
+		if max(region.Dx(), region.Dy())*opts.Zoom > comparisonMaxEdge {
+			return nil, nil, fmt.Errorf("zoomed crop is larger than %d pixels", comparisonMaxEdge)
+		}
+	}
+
+	panel := image.Rect(0, 0, region.Dx()*opts.Zoom, region.Dy()*opts.Zoom)
+	afterPanel := image.NewRGBA(panel)
+	draw.NearestNeighbor.Scale(afterPanel, panel, after, region, draw.Src, nil)
+
+	// Map the original onto the upscaled image's coordinates, then onto the
+	// panel, in one transform
+	src := original.Bounds()
+	sx := float64(opts.Zoom) * float64(bounds.Dx()) / float64(src.Dx())
+	sy := float64(opts.Zoom) * float64(bounds.Dy()) / float64(src.Dy())
+	offset := region.Min.Sub(bounds.Min).Mul(opts.Zoom)
+	beforePanel := image.NewRGBA(panel)
+	draw.NearestNeighbor.Transform(beforePanel, f64.Aff3{
+		sx, 0, -sx*float64(src.Min.X) - float64(offset.X),
+		0, sy, -sy*float64(src.Min.Y) - float64(offset.Y),
+	}, original, src, draw.Src, nil)
+	return beforePanel, afterPanel, nil
+}
+
+// sliderPanel shows before left of the split and after right of it, with a
+// divider line at the split
+func sliderPanel(before, after *image.RGBA, split float64) *image.RGBA {
+	bounds := after.Bounds()
+	panel := image.NewRGBA(bounds)
+	draw.Draw(panel, bounds, after, bounds.Min, draw.Src)
+
+	splitX := bounds.Min.X + int(float64(bounds.Dx())*split)
+	left := image.Rect(bounds.Min.X, bounds.Min.Y, splitX, bounds.Max.Y)
+	draw.Draw(panel, left, before, left.Min, draw.Src)
+
+	divider := image.Rect(splitX-1, bounds.Min.Y, splitX+1, bounds.Max.Y).Intersect(bounds)
+	draw.Draw(panel, divider, image.White, image.Point{}, draw.Src)
+	return panel
+}
+
+// differenceMap colors each pixel by the mean absolute RGB difference between
+// the two images, from blue (identical) to red
+func differenceMap(before, after *image.RGBA) *image.RGBA {
+	bounds := after.Bounds()
+	diff := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			b, a := before.RGBAAt(x, y), after.RGBAAt(x, y)
+			delta := absDiff(b.R, a.R) + absDiff(b.G, a.G) + absDiff(b.B, a.B)
+			t := min(float64(delta)/3/255*differenceAmplifying, 1)
+			c := heatColor(t)
+			c.A = 255
+			diff.Set(x, y, c)
+		}
+	}
+	return diff
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// composePanels lays panels out left to right on a dark background, each
+// under a label bar
+func composePanels(panels []*image.RGBA, labels []string) *image.RGBA {
+	width, height := comparisonGap, 0
+	for _, panel := range panels {
+		width += panel.Bounds().Dx() + comparisonGap
+		height = max(height, panel.Bounds().Dy())
+	}
+	height += comparisonLabelBar + 2*comparisonGap
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.RGBA{R: 32, G: 32, B: 32, A: 255}), image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	x := comparisonGap
+	for i, panel := range panels {
+		drawer := font.Drawer{
+			Dst:  canvas,
+			Src:  image.White,
+			Face: face,
+			Dot:  fixed.P(x, comparisonGap+face.Ascent),
+		}
+		drawer.DrawString(labels[i])
+
+		top := comparisonGap + comparisonLabelBar
+		target := panel.Bounds().Sub(panel.Bounds().Min).Add(image.Pt(x, top))
+		draw.Draw(canvas, target, panel, panel.Bounds().Min, draw.Src)
+		x += panel.Bounds().Dx() + comparisonGap
+	}
+	return canvas
+}
+
+// loadImage downloads and decodes a stored image
+func (po *PipelineOrchestrator) loadImage(ctx context.Context, key string) (image.Image, error) {
+	data, err := po.storageService.DownloadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := decodeImage(data, po.qualityService.MaxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestCompare(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 64, 48), "compare.png", ProcessOptions{})
+	if result.Status != "success" || result.OriginalCopy == "" {
+		t.Fatalf("result = %+v", result)
+	}
+
+	// Panels are the 128x96 upscaled size, or the zoomed crop, laid out with
+	// gaps under a label bar
+	tests := []struct {
+		name          string
+		opts          ComparisonOptions
+		width, height int
+	}{
+		{"side by side", ComparisonOptions{}, 3*comparisonGap + 2*128, 96},
+		{"with difference", ComparisonOptions{Difference: true}, 4*comparisonGap + 3*128, 96},
+		{"slider", ComparisonOptions{Layout: CompareSlider, Split: 0.3}, 2*comparisonGap + 128, 96},
+		{"zoomed crop", ComparisonOptions{Crop: image.Rect(10, 10, 30, 20), Zoom: 4}, 3*comparisonGap + 2*80, 40},
+		{"crop past the edge", ComparisonOptions{Crop: image.Rect(120, 90, 200, 200)}, 3*comparisonGap + 2*8, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := po.Compare(ctx, result, tt.opts)
+			if err != nil {
+				t.Fatalf("Compare: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("png.Decode: %v", err)
+			}
+			height := tt.height + comparisonLabelBar + 2*comparisonGap
+			if got := img.Bounds().Size(); got.X != tt.width || got.Y != height {
+				t.Errorf("comparison is %v, want %dx%d", got, tt.width, height)
+			}
+		})
+	}
+
+	invalid := []ComparisonOptions{
+		{Layout: "overlay"},
+		{Split: 1.5},
+		{Zoom: 4},
+		{Crop: image.Rect(500, 500, 600, 600)},
+	}
+	for _, opts := range invalid {
+		if _, err := po.Compare(ctx, result, opts); !errors.Is(err, ErrCannotCompare) {
+			t.Errorf("Compare(%+v) err = %v, want ErrCannotCompare", opts, err)
+		}
+	}
+
+	// Jobs without a kept original cannot be compared
+	po.SetKeepOriginals(false)
+	plain := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "plain.png", ProcessOptions{})
+	if _, err := po.Compare(ctx, plain, ComparisonOptions{}); !errors.Is(err, ErrCannotCompare) {
+		t.Errorf("Compare without original: err = %v, want ErrCannotCompare", err)
+	}
+}
+
+func TestComparisonPanelsMatchFullSizeOriginal(t *testing.T) {
+	original, _, err := image.Decode(bytes.NewReader(testPNG(t, 30, 20)))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	after := image.NewRGBA(image.Rect(0, 0, 90, 60))
+
+	// The original enlarged to the upscaled size, as the panels used to be cut from
+	enlarged := image.NewRGBA(after.Bounds())
+	draw.NearestNeighbor.Scale(enlarged, enlarged.Bounds(), original, original.Bounds(), draw.Src, nil)
+
+	for _, opts := range []ComparisonOptions{
+		{Zoom: 1},
+		{Zoom: 1, Crop: image.Rect(10, 5, 50, 41)},
+		{Zoom: 3, Crop: image.Rect(7, 13, 29, 30)},
+	} {
+		before, _, err := opts.panels(original, after)
+		if err != nil {
+			t.Fatalf("panels(%+v): %v", opts, err)
+		}
+		region := after.Bounds()
+		if !opts.Crop.Empty() {
+			region = opts.Crop
+		}
+		for y := range before.Bounds().Dy() {
+			for x := range before.Bounds().Dx() {
+				want := enlarged.RGBAAt(region.Min.X+x/opts.Zoom, region.Min.Y+y/opts.Zoom)
+				if got := before.RGBAAt(x, y); got != want {
+					t.Fatalf("%+v: pixel (%d,%d) = %v, want %v", opts, x, y, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// forEachConcurrently calls fn for each index in [0, n) using at most limit
+// goroutines at a time. It stops scheduling new work once ctx is cancelled and
+// waits for in-flight calls to return.
+func forEachConcurrently(ctx context.Context, limit, n int, fn func(i int)) {
+	if limit <= 0 {
+		limit = 1
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+)
+
+// heatmapTileSize is the edge of the square tiles local sharpness is measured on
+const heatmapTileSize = 16
+
+// heatmapSharpRef is the local Laplacian variance drawn at the sharp end of the
+// heatmap scale
+const heatmapSharpRef = 1000.0
+
+// diagnosticLevel describes a metric and the levels at which it is reported as
+// a problem. Levels are advisory; routing only uses the configured rules.
+type diagnosticLevel struct {
+	description string
+	low, high   float64 // problem below low or above high; zero disables the bound
+	lowIssue    string
+	highIssue   string
+}
+
+// diagnosticLevels holds the reference levels of each routing metric
+var diagnosticLevels = map[string]diagnosticLevel{
+	MetricQualityScore: {description: "pixel count relative to 4K UHD, capped at 1"},
+	MetricSharpness:    {"Laplacian variance of the luma channel; low values mean blur", 100, 0, "looks blurry", ""},
+	MetricNoise:        {"estimated noise standard deviation in 0-255 levels", 0, 8, "", "is noisy"},
+	MetricContrast:     {"standard deviation of luma in 0-255 levels", 25, 0, "has low contrast", ""},
+	MetricBrightness:   {"mean luma in 0-255 levels", 50, 205, "is dark", "is washed out"},
+	MetricBlockiness:   {"luma steps at 8x8 block edges relative to elsewhere; above 1 means compression artifacts", 0, 1.25, "", "shows compression artifacts"},
+}
+
+// ThresholdCheck is a routing rule bound tested against a metric
+type ThresholdCheck struct {
+	Rule   string   `json:"rule"`
+	Action string   `json:"action"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Passed bool     `json:"passed"` // the value is within the bounds
+}
+
+// MetricDiagnostic explains a single quality sub-metric
+type MetricDiagnostic struct {
+	Name          string           `json:"name"`
+	Description   string           `json:"description"`
+	Value         float64          `json:"value"`                    // as assessed when the job ran
+	StoredValue   *float64         `json:"stored_value"`             // measured on the stored image; nil if none was stored
+	OriginalValue *float64         `json:"original_value,omitempty"` // measured on the kept original of an upscaled image
+	Issue         string           `json:"issue,omitempty"`
+	Thresholds    []ThresholdCheck `json:"thresholds,omitempty"`
+}
+
+// ImageDiagnostics explains how a processed image was assessed and routed
+type ImageDiagnostics struct {
+	JobID       string             `json:"job_id"`
+	StorageKey  string             `json:"storage_key"`
+	Upscaled    bool               `json:"upscaled"` // the stored image is the upscaled output, not the assessed original
+	Width       int                `json:"width"`    // of the assessed original
+	Height      int                `json:"height"`
+	Format      string             `json:"format"` // of the stored image
+	Status      string             `json:"status"`
+	Folder      string             `json:"folder"`
+	MatchedRule string             `json:"matched_rule,omitempty"`
+	RuleAction  string             `json:"rule_action,omitempty"`
+	Metrics     []MetricDiagnostic `json:"metrics"`
+	Explanation string             `json:"explanation"`
+}
+
+// Diagnose re-measures the stored image of a job and explains its metrics
+// against the routing rules. Metrics recorded at assessment time are what the
+// rules are checked against. Jobs that stored nothing, such as rejected
+// images, are explained from the recorded metrics alone. When the original of
+// an upscaled image was kept, it is measured too, so its metrics can be set
+// against those of the upscaled output.
+func (po *PipelineOrchestrator) Diagnose(ctx context.Context, result *ProcessingResult) (*ImageDiagnostics, error) {
+	if result.StorageKey == "" && result.QualityMetrics == nil {
+		return nil, fmt.Errorf("job %s has no stored image or recorded metrics", result.JobID)
+	}
+
+	upscaled := result.Folder == FolderUpscaled
+	var measured map[string]float64
+	var width, height int
+	var format string
+	if result.StorageKey != "" {
+		img, stored, err := po.loadStored(ctx, result)
+		if err != nil {
+			return nil, err
+		}
+		measured = measureImage(img)
+		format = stored
+
+		bounds := img.Bounds()
+		width, height = bounds.Dx(), bounds.Dy()
+		if upscaled && result.UpscaleScale > 0 {
+			width, height = width/result.UpscaleScale, height/result.UpscaleScale
+		}
+		measured[MetricQualityScore] = math.Min(float64(width*height)/referenceResolution, 1)
+	}
+	var original map[string]float64
+	if upscaled && result.OriginalCopy != "" {
+		img, err := po.loadImage(ctx, result.OriginalCopy)
+		if err != nil {
+			return nil, err
+		}
+		original = measureImage(img)
+
+		// The original gives the assessed size exactly
+		bounds := img.Bounds()
+		width, height = bounds.Dx(), bounds.Dy()
+		original[MetricQualityScore] = math.Min(float64(width*height)/referenceResolution, 1)
+	}
+	recorded := map[string]float64{MetricQualityScore: result.QualityScore}
+	for name, value := range result.QualityMetrics {
+		recorded[name] = value
+	}
+
+	diagnostics := &ImageDiagnostics{
+		JobID:       result.JobID,
+		StorageKey:  result.StorageKey,
+		Upscaled:    upscaled,
+		Width:       width,
+		Height:      height,
+		Format:      format,
+		Status:      result.Status,
+		Folder:      result.Folder,
+		MatchedRule: result.MatchedRule,
+		RuleAction:  result.RuleAction,
+	}
+
+	for _, name := range routingMetrics {
+		value, ok := recorded[name]
+		storedValue, stored := measured[name]
+		if !ok && !stored {
+			continue
+		}
+		if !ok {
+			// Recorded before this metric existed
+			value = storedValue
+		}
+		level := diagnosticLevels[name]
+		metric := MetricDiagnostic{
+			Name:        name,
+			Description: level.description,
+			Value:       value,
+		}
+		if stored {
+			metric.StoredValue = &storedValue
+		}
+		if originalValue, ok := original[name]; ok {
+			metric.OriginalValue = &originalValue
+		}
+		if level.low != 0 && value < level.low {
+			metric.Issue = level.lowIssue
+		} else if level.high != 0 && value > level.high {
+			metric.Issue = level.highIssue
+		}
+
+		for _, rule := range po.router.Rules() {
+			bounds, ok := rule.When.Metrics[name]
+			if !ok {
+				continue
+			}
+			metric.Thresholds = append(metric.Thresholds, ThresholdCheck{
+				Rule:   rule.Name,
+				Action: rule.Action,
+				Min:    bounds.Min,
+				Max:    bounds.Max,
+				Passed: (bounds.Min == nil || value >= *bounds.Min) && (bounds.Max == nil || value <= *bounds.Max),
+			})
+		}
+		diagnostics.Metrics = append(diagnostics.Metrics, metric)
+	}
+
+	diagnostics.Explanation = explainDiagnostics(result, diagnostics)
+	return diagnostics, nil
+}
+
+// SharpnessHeatmap renders local sharpness of a job's stored image as a
+// semi-transparent PNG the size of the analysed copy, for overlaying on the
+// image. Soft areas are blue, sharp areas red.
+func (po *PipelineOrchestrator) SharpnessHeatmap(ctx context.Context, result *ProcessingResult) ([]byte, error) {
+	img, _, err := po.loadStored(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if edge := max(bounds.Dx(), bounds.Dy()); edge > analysisMaxEdge {
+		ratio := float64(analysisMaxEdge) / float64(edge)
+		img = resizeTo(img, max(1, int(float64(bounds.Dx())*ratio)), max(1, int(float64(bounds.Dy())*ratio)))
+	}
+
+	gray, width, height := luminance(img)
+	heatmap := image.NewNRGBA(image.Rect(0, 0, width, height))
+	tile := make([]float64, 0, heatmapTileSize*heatmapTileSize)
+	for ty := 0; ty < height; ty += heatmapTileSize {
+		for tx := 0; tx < width; tx += heatmapTileSize {
+			tw, th := min(heatmapTileSize, width-tx), min(heatmapTileSize, height-ty)
+			tile = tile[:0]
+			for y := ty; y < ty+th; y++ {
+				tile = append(tile, gray[y*width+tx:y*width+tx+tw]...)
+			}
+
+			t := math.Min(math.Log1p(laplacianVariance(tile, tw, th))/math.Log1p(heatmapSharpRef), 1)
+			c := heatColor(t)
+			for y := ty; y < ty+th; y++ {
+				for x := tx; x < tx+tw; x++ {
+					heatmap.SetNRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, heatmap); err != nil {
+		return nil, fmt.Errorf("failed to encode heatmap: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// loadStored downloads and decodes the image a job stored
+func (po *PipelineOrchestrator) loadStored(ctx context.Context, result *ProcessingResult) (image.Image, string, error) {
+	if result.StorageKey == "" {
+		return nil, "", fmt.Errorf("job %s has no stored image", result.JobID)
+	}
+	data, err := po.storageService.DownloadObject(ctx, result.StorageKey)
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := decodeImage(data, po.qualityService.MaxPixels)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode stored image: %w", err)
+	}
+	return img, format, nil
+}
+
+// heatColor maps 0-1 to a blue-green-yellow-red ramp with constant alpha
+func heatColor(t float64) color.NRGBA {
+	var r, g, b float64
+	switch {
+	case t < 1.0/3:
+		g, b = 3*t, 1-3*t
+	case t < 2.0/3:
+		r, g = 3*t-1, 1
+	default:
+		r, g = 1, 3-3*t
+	}
+	return color.NRGBA{R: uint8(255 * r), G: uint8(255 * g), B: uint8(255 * b), A: 140}
+}
+
+// explainDiagnostics writes a plain-language account of the assessment and
+// the routing decision
+func explainDiagnostics(result *ProcessingResult, d *ImageDiagnostics) string {
+	var sb strings.Builder
+	var score float64
+	var issues []string
+	for _, metric := range d.Metrics {
+		if metric.Name == MetricQualityScore {
+			score = metric.Value
+		}
+		if metric.Issue != "" {
+			issues = append(issues, fmt.Sprintf("%s (%s %.2f)", metric.Issue, metric.Name, metric.Value))
+		}
+	}
+
+	if d.Width > 0 {
+		fmt.Fprintf(&sb, "The image is %dx%d pixels, which scores %.2f against the 4K reference resolution. ", d.Width, d.Height, score)
+	} else {
+		fmt.Fprintf(&sb, "The image scores %.2f against the 4K reference resolution. ", score)
+	}
+
+	if failed := failedThreshold(d.Metrics, RuleQualityThreshold); failed != nil && failed.Min != nil {
+		fmt.Fprintf(&sb, "That is below the quality threshold of %.2f. ", *failed.Min)
+	}
+
+	switch result.RuleAction {
+	case RuleActionAccept:
+		fmt.Fprintf(&sb, "Routing rule %q accepted it, so it was stored unchanged. ", result.MatchedRule)
+	case RuleActionFolder:
+		fmt.Fprintf(&sb, "Routing rule %q sent it unchanged to the %s folder. ", result.MatchedRule, result.Folder)
+	case RuleActionReject:
+		fmt.Fprintf(&sb, "Routing rule %q rejected it, so nothing was stored. ", result.MatchedRule)
+	case RuleActionUpscale:
+		if result.Status == "success" {
+			fmt.Fprintf(&sb, "Routing rule %q sent it for upscaling and it was upscaled %dx", result.MatchedRule, result.UpscaleScale)
+			if result.ScaleReason != "" {
+				fmt.Fprintf(&sb, " (%s)", result.ScaleReason)
+			}
+			sb.WriteString(". ")
+		} else {
+			fmt.Fprintf(&sb, "Routing rule %q sent it for upscaling, which failed: %s. ", result.MatchedRule, result.ErrorMessage)
+		}
+	default:
+		if result.ErrorMessage != "" {
+			fmt.Fprintf(&sb, "It was not routed: %s. ", result.ErrorMessage)
+		}
+	}
+
+	if len(issues) == 0 {
+		sb.WriteString("No blur, noise or compression problems were detected.")
+	} else {
+		fmt.Fprintf(&sb, "The image %s.", joinWords(issues))
+		if d.Upscaled {
+			sb.WriteString(" Upscaling adds pixels but does not remove these problems.")
+		}
+	}
+	return sb.String()
+}
+
+// failedThreshold returns the check of the named rule that did not pass, if any
+func failedThreshold(metrics []MetricDiagnostic, rule string) *ThresholdCheck {
+	for _, metric := range metrics {
+		for i, check := range metric.Thresholds {
+			if check.Rule == rule && !check.Passed {
+				return &metric.Thresholds[i]
+			}
+		}
+	}
+	return nil
+}
+
+// joinWords joins phrases as "a, b and c"
+func joinWords(words []string) string {
+	if len(words) <= 1 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseStoredImage(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 64, 48), "diag.png", ProcessOptions{})
+	if result.Status != "success" || result.Folder != FolderUpscaled {
+		t.Fatalf("result = %+v", result)
+	}
+
+	diagnostics, err := po.Diagnose(ctx, result)
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	if !diagnostics.Upscaled || diagnostics.Width != 64 || diagnostics.Height != 48 || diagnostics.Format != "png" {
+		t.Errorf("diagnostics = %+v", diagnostics)
+	}
+	for _, metric := range diagnostics.Metrics {
+		if metric.StoredValue == nil {
+			t.Errorf("%s was not measured on the stored image", metric.Name)
+		}
+		// The kept original is the image that was assessed
+		if metric.OriginalValue == nil || math.Abs(*metric.OriginalValue-metric.Value) > 1e-9 {
+			t.Errorf("%s measured on the original = %v, want %v", metric.Name, metric.OriginalValue, metric.Value)
+		}
+	}
+	if !strings.Contains(diagnostics.Explanation, "64x48 pixels") || !strings.Contains(diagnostics.Explanation, "upscaled 2x") {
+		t.Errorf("explanation = %q", diagnostics.Explanation)
+	}
+
+	heatmap, err := po.SharpnessHeatmap(ctx, result)
+	if err != nil || imageFormat(heatmap) != "png" {
+		t.Errorf("SharpnessHeatmap = %d bytes, %v", len(heatmap), err)
+	}
+
+	// Without a kept original only the upscaled image is measured
+	po.SetKeepOriginals(false)
+	plain := po.ProcessImageWithOptions(ctx, testPNG(t, 30, 20), "plain.png", ProcessOptions{})
+	diagnostics, err = po.Diagnose(ctx, plain)
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	if diagnostics.Width != 30 || diagnostics.Height != 20 || diagnostics.Metrics[0].OriginalValue != nil {
+		t.Errorf("diagnostics = %+v", diagnostics)
+	}
+}
+
+func TestDiagnoseRejectedImage(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	router, err := NewRouter([]RoutingRule{{Name: "no-thumbnails", When: RuleConditions{MaxWidth: 100}, Action: RuleActionReject}}, 0.5, po.Models())
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	po.SetRouter(router)
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 64, 48), "tiny.png", ProcessOptions{})
+	if result.Status != "skipped" || result.StorageKey != "" || len(fake.keys()) != 0 {
+		t.Fatalf("result = %+v; bucket holds %v", result, fake.keys())
+	}
+
+	// Nothing was stored, so the recorded metrics are explained on their own
+	diagnostics, err := po.Diagnose(ctx, result)
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	if len(diagnostics.Metrics) != len(routingMetrics) {
+		t.Errorf("got %d metrics, want %d", len(diagnostics.Metrics), len(routingMetrics))
+	}
+	for _, metric := range diagnostics.Metrics {
+		if metric.StoredValue != nil || metric.Value != result.QualityMetrics[metric.Name] && metric.Name != MetricQualityScore {
+			t.Errorf("metric %+v does not match the recorded value", metric)
+		}
+	}
+	if !strings.Contains(diagnostics.Explanation, `Routing rule "no-thumbnails" rejected it`) {
+		t.Errorf("explanation = %q", diagnostics.Explanation)
+	}
+
+	if _, err := po.Diagnose(ctx, &ProcessingResult{JobID: "empty"}); err == nil {
+		t.Error("Diagnose succeeded without a stored image or metrics")
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// Output formats the pipeline can encode
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp" // lossless
+)
+
+// FormatOriginal selects the format the image was uploaded in
+const FormatOriginal = "original"
+
+// formatExtensions is the file extension written for each output format
+var formatExtensions = map[string]string{
+	FormatPNG:  ".png",
+	FormatJPEG: ".jpg",
+	FormatWebP: ".webp",
+}
+
+// ValidOutputFormat reports whether the pipeline can encode format
+func ValidOutputFormat(format string) bool {
+	_, ok := formatExtensions[format]
+	return ok
+}
+
+// imageFormat returns the decoder name of encoded image data, or "" if unknown
+func imageFormat(data []byte) string {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	return format
+}
+
+// DetectContentType returns the MIME type of data from its leading bytes,
+// falling back to the key's extension and then to application/octet-stream
+func DetectContentType(data []byte, key string) string {
+	if detected := http.DetectContentType(data); detected != "application/octet-stream" && !strings.HasPrefix(detected, "text/plain") {
+		return detected
+	}
+	if byExtension := mime.TypeByExtension(strings.ToLower(path.Ext(key))); byExtension != "" {
+		return byExtension
+	}
+	return "application/octet-stream"
+}
+
+// encodeImage re-encodes image data in format. JPEG has no alpha channel, so
+// transparent areas are flattened onto white. WebP is always lossless, so
+// jpegQuality only applies to JPEG.
+func encodeImage(data []byte, format string, jpegQuality int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return encodeDecoded(img, format, jpegQuality)
+}
+
+// encodeDecoded encodes an already decoded image in format, as encodeImage does
+func encodeDecoded(img image.Image, format string, jpegQuality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	case FormatJPEG:
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&buf, flat, &jpeg.Options{Quality: jpegQuality})
+	case FormatWebP:
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("unsupported output format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// withExtension replaces the extension of an object key with the one for format
+func withExtension(objectKey, format string) string {
+	ext := formatExtensions[format]
+	if current := path.Ext(objectKey); current != "" {
+		if strings.EqualFold(current, ext) || (format == FormatJPEG && strings.EqualFold(current, ".jpeg")) {
+			return objectKey
+		}
+		objectKey = strings.TrimSuffix(objectKey, current)
+	}
+	return objectKey + ext
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+func TestEncodeImage(t *testing.T) {
+	// Half opaque red, half fully transparent
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 8 {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FormatPNG, FormatJPEG, FormatWebP} {
+		out, err := encodeImage(buf.Bytes(), format, 90)
+		if err != nil {
+			t.Fatalf("encodeImage(%s): %v", format, err)
+		}
+		decoded, got, err := image.Decode(bytes.NewReader(out))
+		if err != nil || got != format {
+			t.Fatalf("%s output decodes as %q: %v", format, got, err)
+		}
+		if bounds := decoded.Bounds(); bounds.Dx() != 16 || bounds.Dy() != 16 {
+			t.Errorf("%s output is %v", format, bounds)
+		}
+		if format == FormatJPEG {
+			// Transparent areas are flattened onto white
+			if r, g, b, _ := decoded.At(12, 8).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+				t.Errorf("transparent pixel in JPEG = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+			}
+		}
+	}
+
+	if _, err := encodeImage(buf.Bytes(), "bmp", 90); err == nil {
+		t.Error("encodeImage accepted an unsupported format")
+	}
+	if _, err := encodeImage([]byte("not an image"), FormatPNG, 90); err == nil {
+		t.Error("encodeImage accepted undecodable data")
+	}
+}
+
+func TestWithExtension(t *testing.T) {
+	tests := []struct {
+		key, format, want string
+	}{
+		{"photos/cat.png", FormatJPEG, "photos/cat.jpg"},
+		{"photos/cat.JPEG", FormatJPEG, "photos/cat.JPEG"},
+		{"photos/cat.PNG", FormatPNG, "photos/cat.PNG"},
+		{"photos/cat", FormatWebP, "photos/cat.webp"},
+		{"photos.v2/cat.gif", FormatWebP, "photos.v2/cat.webp"},
+	}
+	for _, tt := range tests {
+		if got := withExtension(tt.key, tt.format); got != tt.want {
+			t.Errorf("withExtension(%q, %s) = %q, want %q", tt.key, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineOutputFormat(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+
+	var jpegData, gifData bytes.Buffer
+	img, _, _ := image.Decode(bytes.NewReader(testPNG(t, 32, 32)))
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the uploaded format is kept, unless it cannot be encoded
+	tests := []struct {
+		name     string
+		data     []byte
+		opts     ProcessOptions
+		wantKey  string
+		wantType string
+	}{
+		{"photo.jpg", jpegData.Bytes(), ProcessOptions{}, "upscaled/photo.jpg", "image/jpeg"},
+		{"anim.gif", gifData.Bytes(), ProcessOptions{}, "upscaled/anim.png", "image/png"},
+		{"chosen.png", testPNG(t, 32, 32), ProcessOptions{OutputFormat: FormatJPEG, OutputQuality: 70}, "upscaled/chosen.jpg", "image/jpeg"},
+	}
+	for _, tt := range tests {
+		result := po.ProcessImageWithOptions(ctx, tt.data, tt.name, tt.opts)
+		if result.Status != "success" || result.StorageKey != tt.wantKey {
+			t.Errorf("%s: result = %+v, want %s", tt.name, result, tt.wantKey)
+			continue
+		}
+		if obj := fake.object(tt.wantKey); obj == nil || obj.contentType != tt.wantType || http.DetectContentType(obj.data) != tt.wantType {
+			t.Errorf("%s: stored object is not %s", tt.name, tt.wantType)
+		}
+		if tt.opts.OutputQuality != 0 && result.OutputQuality != tt.opts.OutputQuality {
+			t.Errorf("%s: quality = %d, want %d", tt.name, result.OutputQuality, tt.opts.OutputQuality)
+		}
+	}
+
+	// A content class may have its own format
+	if err := po.SetOutputFormats(FormatOriginal, map[string]string{ContentIcon: FormatWebP}, 85); err != nil {
+		t.Fatalf("SetOutputFormats: %v", err)
+	}
+	result := po.ProcessImageWithOptions(ctx, testIcon(t, 32), "icon.png", ProcessOptions{})
+	if result.ContentClass != ContentIcon || result.StorageKey != "upscaled/icon.webp" || result.OutputFormat != FormatWebP {
+		t.Errorf("icon result = %+v", result)
+	}
+
+	for _, bad := range []struct {
+		defaultFormat string
+		classes       map[string]string
+		quality       int
+	}{
+		{"tiff", nil, 90},
+		{FormatPNG, map[string]string{"painting": FormatPNG}, 90},
+		{FormatPNG, map[string]string{ContentPhoto: "heic"}, 90},
+		{FormatPNG, nil, 0},
+	} {
+		if err := po.SetOutputFormats(bad.defaultFormat, bad.classes, bad.quality); err == nil {
+			t.Errorf("SetOutputFormats(%q, %v, %d) succeeded", bad.defaultFormat, bad.classes, bad.quality)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	if got := DetectContentType(testPNG(t, 4, 4), "image.jpg"); got != "image/png" {
+		t.Errorf("content type of PNG data = %q, want image/png from the data", got)
+	}
+	if got := DetectContentType([]byte("plain"), "notes.webp"); got != "image/webp" {
+		t.Errorf("content type by extension = %q, want image/webp", got)
+	}
+	if got := DetectContentType([]byte{0, 1, 2}, "blob"); got != "application/octet-stream" {
+		t.Errorf("content type of unknown data = %q", got)
+	}
+}
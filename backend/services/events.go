@@ -0,0 +1,123 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Pipeline event stages, published in this order for a successful upscale
+const (
+	EventAssessing  = "assessing"
+	EventAssessed   = "assessed"
+	EventClassified = "classified"
+	EventUpscaling  = "upscaling"
+	EventUploading  = "uploading"
+	EventDone       = "done"
+)
+
+// PipelineEvent is a stage transition of a single pipeline run
+type PipelineEvent struct {
+	Seq          uint64    `json:"seq"`
+	JobID        string    `json:"job_id"`
+	Stage        string    `json:"stage"`
+	Timestamp    time.Time `json:"timestamp"`
+	ElapsedMs    int64     `json:"elapsed_ms"` // since the job started
+	StageMs      int64     `json:"stage_ms"`   // since the job's previous event
+	OriginalKey  string    `json:"original_key,omitempty"`
+	QualityScore *float64  `json:"quality_score,omitempty"`
+	ContentClass string    `json:"content_class,omitempty"`
+	Folder       string    `json:"folder,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// EventHook receives pipeline events as they happen. Hooks run synchronously on
+// the pipeline goroutine and must not block.
+type EventHook func(PipelineEvent)
+
+// eventBufferSize is how many undelivered events a slow subscriber may queue
+// before further events to it are dropped
+const eventBufferSize = 64
+
+type eventSubscriber struct {
+	jobID string // empty subscribes to every job
+	ch    chan PipelineEvent
+}
+
+// EventBroker fans pipeline events out to subscribers and keeps the events of
+// recent jobs so a client that subscribes late still sees the whole run
+type EventBroker struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[*eventSubscriber]struct{}
+	recent      map[string][]PipelineEvent
+	recentOrder []string
+	maxJobs     int
+}
+
+// NewEventBroker creates a broker retaining the events of the last maxJobs jobs
+func NewEventBroker(maxJobs int) *EventBroker {
+	if maxJobs <= 0 {
+		maxJobs = 1000
+	}
+	return &EventBroker{
+		subscribers: make(map[*eventSubscriber]struct{}),
+		recent:      make(map[string][]PipelineEvent),
+		maxJobs:     maxJobs,
+	}
+}
+
+// Publish records an event and delivers it to matching subscribers. It is an EventHook.
+func (eb *EventBroker) Publish(event PipelineEvent) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	eb.seq++
+	event.Seq = eb.seq
+
+	if _, seen := eb.recent[event.JobID]; !seen {
+		eb.recentOrder = append(eb.recentOrder, event.JobID)
+		if len(eb.recentOrder) > eb.maxJobs {
+			delete(eb.recent, eb.recentOrder[0])
+			eb.recentOrder = eb.recentOrder[1:]
+		}
+	}
+	eb.recent[event.JobID] = append(eb.recent[event.JobID], event)
+
+	for sub := range eb.subscribers {
+		if sub.jobID != "" && sub.jobID != event.JobID {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// Subscriber is not keeping up; drop rather than stall the pipeline
+		}
+	}
+}
+
+// Subscribe returns a channel of future events for jobID (or all jobs if empty),
+// the events already published for jobID, and a function that ends the subscription
+func (eb *EventBroker) Subscribe(jobID string) (<-chan PipelineEvent, []PipelineEvent, func()) {
+	sub := &eventSubscriber{
+		jobID: jobID,
+		ch:    make(chan PipelineEvent, eventBufferSize),
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	var backlog []PipelineEvent
+	if jobID != "" {
+		backlog = append(backlog, eb.recent[jobID]...)
+	}
+	eb.subscribers[sub] = struct{}{}
+
+	cancel := func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+		delete(eb.subscribers, sub)
+	}
+
+	return sub.ch, backlog, cancel
+}
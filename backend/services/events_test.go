@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestPipelineEvents(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	broker := NewEventBroker(1)
+	po.OnEvent(broker.Publish)
+	ctx := context.Background()
+
+	// A client may subscribe before the upload arrives
+	events, backlog, cancel := broker.Subscribe("job-events")
+	defer cancel()
+	if len(backlog) != 0 {
+		t.Fatalf("backlog before the job ran = %+v", backlog)
+	}
+	po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "other.png", ProcessOptions{JobID: "job-other"})
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "events.png", ProcessOptions{JobID: "job-events"})
+
+	var stages []string
+	var last PipelineEvent
+	for len(events) > 0 {
+		event := <-events
+		if event.JobID != "job-events" {
+			t.Errorf("subscriber got an event of %s", event.JobID)
+		}
+		if event.Seq <= last.Seq {
+			t.Errorf("event %s has seq %d after %d", event.Stage, event.Seq, last.Seq)
+		}
+		stages = append(stages, event.Stage)
+		last = event
+	}
+	want := []string{EventAssessing, EventAssessed, EventClassified, EventUpscaling, EventUploading, EventDone}
+	if !slices.Equal(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+	if last.Status != result.Status || last.Folder != result.Folder {
+		t.Errorf("done event = %+v, result = %+v", last, result)
+	}
+
+	// A late subscriber gets the run so far; older jobs have been evicted
+	_, backlog, cancelLate := broker.Subscribe("job-events")
+	cancelLate()
+	if len(backlog) != len(want) {
+		t.Errorf("late subscriber backlog has %d events, want %d", len(backlog), len(want))
+	}
+	_, backlog, cancelOld := broker.Subscribe("job-other")
+	cancelOld()
+	if len(backlog) != 0 {
+		t.Errorf("evicted job still has %d events", len(backlog))
+	}
+}
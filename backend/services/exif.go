@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// exifTags maps the IFD0 tags worth routing on to the names used in rules
+var exifTags = map[uint16]string{
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x8298: "Copyright",
+}
+
+// ReadEXIF extracts the common IFD0 tags from a JPEG APP1 segment or a PNG eXIf
+// chunk. Images without EXIF, or with EXIF it cannot parse, yield an empty map.
+func ReadEXIF(imageData []byte) map[string]string {
+	tags := make(map[string]string)
+	if tiff := findEXIF(imageData); tiff != nil {
+		parseTIFF(tiff, tags)
+	}
+	return tags
+}
+
+// findEXIF returns the raw TIFF structure holding the EXIF data, if any
+func findEXIF(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		// JPEG: walk the marker segments up to the start of scan
+		for i := 2; i+4 <= len(data); {
+			if data[i] != 0xFF {
+				return nil
+			}
+			marker := data[i+1]
+			if marker == 0xDA || marker == 0xD9 {
+				return nil
+			}
+			length := int(binary.BigEndian.Uint16(data[i+2:]))
+			end := i + 2 + length
+			if length < 2 || end > len(data) {
+				return nil
+			}
+			segment := data[i+4 : end]
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment[6:]
+			}
+			i = end
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			end := i + 12 + length
+			if end > len(data) {
+				return nil
+			}
+			chunkType := string(data[i+4 : i+8])
+			if chunkType == "eXIf" {
+				return data[i+8 : i+8+length]
+			}
+			if chunkType == "IDAT" || chunkType == "IEND" {
+				return nil
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// parseTIFF reads the known tags of the first IFD into tags
+func parseTIFF(tiff []byte, tags map[string]string) {
+	if len(tiff) < 8 {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		name, ok := exifTags[order.Uint16(tiff[entry:])]
+		if !ok {
+			continue
+		}
+
+		valueType := order.Uint16(tiff[entry+2:])
+		n := int(order.Uint32(tiff[entry+4:]))
+		switch valueType {
+		case 2: // ASCII, stored inline if it fits in four bytes
+			start := entry + 8
+			if n > 4 {
+				start = int(order.Uint32(tiff[entry+8:]))
+			}
+			if n <= 0 || start < 0 || start+n > len(tiff) {
+				continue
+			}
+			tags[name] = strings.TrimSpace(strings.TrimRight(string(tiff[start:start+n]), "\x00"))
+		case 3: // SHORT
+			tags[name] = strconv.Itoa(int(order.Uint16(tiff[entry+8:])))
+		case 4: // LONG
+			tags[name] = strconv.Itoa(int(order.Uint32(tiff[entry+8:])))
+		}
+	}
+}
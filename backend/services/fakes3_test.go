@@ -0,0 +1,359 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory, path-style S3 endpoint implementing the subset of
+// the API the storage service uses. Signatures are not checked.
+type fakeS3 struct {
+	*httptest.Server
+	bucket string
+
+	mu       sync.Mutex
+	objects  map[string]*fakeObject
+	requests []string // "METHOD /path" of every request, for assertions
+}
+
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	cacheControl string
+	metadata     map[string]string // lower-cased names without x-amz-meta-
+	lastModified time.Time
+	encryption   string
+	kmsKeyID     string
+	storageClass string
+	tags         url.Values
+}
+
+// applyHeaders records the encryption, storage class and tags of a write
+func (o *fakeObject) applyHeaders(header http.Header) error {
+	o.encryption = header.Get("X-Amz-Server-Side-Encryption")
+	o.kmsKeyID = header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+	o.storageClass = header.Get("X-Amz-Storage-Class")
+	if tagging := header.Get("X-Amz-Tagging"); tagging != "" {
+		tags, err := url.ParseQuery(tagging)
+		if err != nil {
+			return err
+		}
+		o.tags = tags
+	}
+	return nil
+}
+
+func (o *fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newFakeS3 starts a fake S3 server with a single bucket, closed with the test
+func newFakeS3(t *testing.T, bucket string) *fakeS3 {
+	t.Helper()
+	fake := &fakeS3{bucket: bucket, objects: make(map[string]*fakeObject)}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// object returns a stored object, or nil
+func (f *fakeS3) object(key string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+// age moves an object's modification time into the past
+func (f *fakeS3) age(key string, by time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key].lastModified = f.objects[key].lastModified.Add(-by)
+}
+
+// keys returns the stored keys in order
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r)
+	case key == "":
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		f.put(w, r, key)
+	case r.Method == http.MethodGet && r.URL.Query().Has("tagging"):
+		f.getTagging(w, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		f.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) put(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := readS3Body(r)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	obj := &fakeObject{
+		data:         body,
+		contentType:  r.Header.Get("Content-Type"),
+		cacheControl: r.Header.Get("Cache-Control"),
+		metadata:     make(map[string]string),
+		lastModified: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := obj.applyHeaders(r.Header); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidTag")
+		return
+	}
+	for name, values := range r.Header {
+		if meta, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			obj.metadata[meta] = values[0]
+		}
+	}
+
+	f.mu.Lock()
+	f.objects[key] = obj
+	f.mu.Unlock()
+	w.Header().Set("ETag", obj.etag())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeS3) copy(w http.ResponseWriter, r *http.Request, key string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	_, srcKey, _ := strings.Cut(source, "/")
+
+	f.mu.Lock()
+	src, ok := f.objects[srcKey]
+	if ok {
+		dst := *src
+		dst.lastModified = time.Now().UTC().Truncate(time.Second)
+		tags := dst.tags
+		dst.applyHeaders(r.Header)
+		if r.Header.Get("X-Amz-Tagging-Directive") != "REPLACE" {
+			dst.tags = tags
+		}
+		f.objects[key] = &dst
+	}
+	f.mu.Unlock()
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>`, src.etag())
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	obj := f.object(key)
+	if obj == nil {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	header := w.Header()
+	header.Set("ETag", obj.etag())
+	header.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+	if obj.contentType != "" {
+		header.Set("Content-Type", obj.contentType)
+	}
+	if obj.cacheControl != "" {
+		header.Set("Cache-Control", obj.cacheControl)
+	}
+	if obj.encryption != "" {
+		header.Set("X-Amz-Server-Side-Encryption", obj.encryption)
+	}
+	if obj.kmsKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", obj.kmsKeyID)
+	}
+	if obj.storageClass != "" && obj.storageClass != "STANDARD" {
+		header.Set("X-Amz-Storage-Class", obj.storageClass)
+	}
+	for name, value := range obj.metadata {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+
+	data, status := obj.data, http.StatusOK
+	if start, end, ok := parseRange(r.Header.Get("Range"), len(obj.data)); ok {
+		data, status = obj.data[start:end+1], http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+	}
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (f *fakeS3) getTagging(w http.ResponseWriter, key string) {
+	type tag struct {
+		Key   string
+		Value string
+	}
+	type tagging struct {
+		XMLName xml.Name `xml:"Tagging"`
+		TagSet  []tag    `xml:"TagSet>Tag"`
+	}
+
+	obj := f.object(key)
+	if obj == nil {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	var result tagging
+	for name := range obj.tags {
+		result.TagSet = append(result.TagSet, tag{Key: name, Value: obj.tags.Get(name)})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+		StorageClass string
+	}
+	type listResult struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	result := listResult{Name: f.bucket, Prefix: prefix}
+	for _, key := range f.keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		obj := f.object(key)
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			Size:         len(obj.data),
+			LastModified: obj.lastModified.Format(time.RFC3339),
+			ETag:         obj.etag(),
+			StorageClass: cmp.Or(obj.storageClass, "STANDARD"),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// readS3Body reads a request body, decoding the aws-chunked encoding the SDK
+// uses to send trailing checksums
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return io.ReadAll(r.Body)
+	}
+
+	var body bytes.Buffer
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body.Bytes(), nil // trailers follow
+		}
+		if _, err := io.CopyN(&body, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseRange parses a single "bytes=start-end" range
+func parseRange(header string, size int) (int, int, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	first, last, _ := strings.Cut(spec, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if n, err := strconv.Atoi(last); err == nil && n < end {
+			end = n
+		}
+	}
+	return start, end, true
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
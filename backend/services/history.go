@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	historyJobsBucket   = []byte("jobs")    // job ID -> ProcessingResult JSON
+	historyTimeBucket   = []byte("by_time") // processed-at nanos + job ID -> job ID
+	historyHashesBucket = []byte("by_hash") // content hash + job ID -> job ID
+)
+
+// ErrJobExists is returned for a job ID that is already in use
+var ErrJobExists = errors.New("job ID already in use")
+
+// HistoryFilter selects processing results from the history store
+type HistoryFilter struct {
+	Folder      string
+	Status      string
+	Tenant      string
+	ContentHash string
+	From        time.Time
+	To          time.Time
+	MinScore    *float64
+	MaxScore    *float64
+	Offset      int
+	Limit       int
+}
+
+// HistoryPage is one page of history results, newest first
+type HistoryPage struct {
+	Total   int                 `json:"total"`
+	Offset  int                 `json:"offset"`
+	Limit   int                 `json:"limit"`
+	Results []*ProcessingResult `json:"results"`
+}
+
+// HistoryStore persists every processing result in an embedded BoltDB database
+type HistoryStore struct {
+	db *bolt.DB
+}
+
+// NewHistoryStore opens (or creates) the history database at path
+func NewHistoryStore(path string) (*HistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{historyJobsBucket, historyTimeBucket, historyHashesBucket, historyDeliveriesBucket, historyComparisonsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history database: %w", err)
+	}
+
+	return &HistoryStore{db: db}, nil
+}
+
+// Close closes the underlying database
+func (hs *HistoryStore) Close() error {
+	return hs.db.Close()
+}
+
+// Record stores a processing result. Job IDs are never reused, so a result
+// whose job ID is already recorded is refused with ErrJobExists.
+func (hs *HistoryStore) Record(result *ProcessingResult) error {
+	if result.JobID == "" {
+		return fmt.Errorf("processing result has no job ID")
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return hs.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(historyJobsBucket)
+		if jobs.Get([]byte(result.JobID)) != nil {
+			return fmt.Errorf("%w: %s", ErrJobExists, result.JobID)
+		}
+
+		if err := jobs.Put([]byte(result.JobID), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(historyTimeBucket).Put(timeIndexKey(result), []byte(result.JobID)); err != nil {
+			return err
+		}
+		return tx.Bucket(historyHashesBucket).Put([]byte(result.ContentHash+"/"+result.JobID), []byte(result.JobID))
+	})
+}
+
+// Update replaces the recorded result of a job, e.g. once its spooled image
+// was uploaded. The job must already be recorded.
+func (hs *HistoryStore) Update(result *ProcessingResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return hs.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(historyJobsBucket)
+		if jobs.Get([]byte(result.JobID)) == nil {
+			return fmt.Errorf("job %s is not recorded", result.JobID)
+		}
+		// The indexes depend on the processing time and content hash, which
+		// do not change
+		return jobs.Put([]byte(result.JobID), data)
+	})
+}
+
+// Get returns the result for a job ID, or nil if it is unknown
+func (hs *HistoryStore) Get(jobID string) (*ProcessingResult, error) {
+	var result *ProcessingResult
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(historyJobsBucket).Get([]byte(jobID))
+		if data == nil {
+			return nil
+		}
+		result = &ProcessingResult{}
+		return json.Unmarshal(data, result)
+	})
+	return result, err
+}
+
+// Query returns results matching filter, newest first
+func (hs *HistoryStore) Query(filter HistoryFilter) (*HistoryPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	page := &HistoryPage{
+		Offset:  filter.Offset,
+		Limit:   filter.Limit,
+		Results: []*ProcessingResult{},
+	}
+
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(historyJobsBucket)
+		cursor := tx.Bucket(historyTimeBucket).Cursor()
+
+		for k, jobID := cursor.Last(); k != nil; k, jobID = cursor.Prev() {
+			processedAt := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+			if !filter.To.IsZero() && processedAt.After(filter.To) {
+				continue
+			}
+			if !filter.From.IsZero() && processedAt.Before(filter.From) {
+				break
+			}
+
+			data := jobs.Get(jobID)
+			if data == nil {
+				continue
+			}
+			var result ProcessingResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				return err
+			}
+			if !filter.matches(&result) {
+				continue
+			}
+
+			if page.Total >= filter.Offset && len(page.Results) < filter.Limit {
+				page.Results = append(page.Results, &result)
+			}
+			page.Total++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+
+	return page, nil
+}
+
+// FindByHash returns every recorded job for the given content hash
+func (hs *HistoryStore) FindByHash(contentHash string) ([]*ProcessingResult, error) {
+	var results []*ProcessingResult
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(historyJobsBucket)
+		prefix := []byte(contentHash + "/")
+		cursor := tx.Bucket(historyHashesBucket).Cursor()
+
+		for k, jobID := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, jobID = cursor.Next() {
+			data := jobs.Get(jobID)
+			if data == nil {
+				continue
+			}
+			var result ProcessingResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				return err
+			}
+			results = append(results, &result)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// matches applies the non-time filters
+func (f HistoryFilter) matches(result *ProcessingResult) bool {
+	if f.Folder != "" && result.Folder != f.Folder {
+		return false
+	}
+	if f.Status != "" && result.Status != f.Status {
+		return false
+	}
+	if f.Tenant != "" && result.Tenant != f.Tenant {
+		return false
+	}
+	if f.ContentHash != "" && result.ContentHash != f.ContentHash {
+		return false
+	}
+	if f.MinScore != nil && result.QualityScore < *f.MinScore {
+		return false
+	}
+	if f.MaxScore != nil && result.QualityScore > *f.MaxScore {
+		return false
+	}
+	return true
+}
+
+// timeIndexKey orders results by processing time, with the job ID breaking ties
+func timeIndexKey(result *ProcessingResult) []byte {
+	key := make([]byte, 8, 8+len(result.JobID))
+	binary.BigEndian.PutUint64(key, uint64(result.ProcessedAt.UnixNano()))
+	return append(key, result.JobID...)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestHistory opens a history store in a temporary directory
+func newTestHistory(t *testing.T) *HistoryStore {
+	t.Helper()
+	history, err := NewHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("NewHistoryStore: %v", err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+func TestHistoryQuery(t *testing.T) {
+	history := newTestHistory(t)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 6 {
+		result := &ProcessingResult{
+			JobID:        fmt.Sprintf("job-%d", i),
+			ContentHash:  fmt.Sprintf("hash-%d", i%2),
+			Tenant:       []string{"acme", "globex"}[i%2],
+			Status:       "success",
+			Folder:       FolderUpscaled,
+			QualityScore: float64(i) / 10,
+			ProcessedAt:  start.Add(time.Duration(i) * time.Hour),
+		}
+		if i == 5 {
+			result.Status, result.Folder = "error", FolderCouldntUpscale
+		}
+		if err := history.Record(result); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	minScore := 0.2
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		total  int
+		want   []string
+	}{
+		{"newest first", HistoryFilter{Limit: 2}, 6, []string{"job-5", "job-4"}},
+		{"second page", HistoryFilter{Limit: 2, Offset: 2}, 6, []string{"job-3", "job-2"}},
+		{"tenant", HistoryFilter{Tenant: "acme"}, 3, []string{"job-4", "job-2", "job-0"}},
+		{"status and folder", HistoryFilter{Status: "error", Folder: FolderCouldntUpscale}, 1, []string{"job-5"}},
+		{"time range", HistoryFilter{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, 3, []string{"job-3", "job-2", "job-1"}},
+		{"score and hash", HistoryFilter{MinScore: &minScore, ContentHash: "hash-1"}, 2, []string{"job-5", "job-3"}},
+	}
+	for _, tt := range tests {
+		page, err := history.Query(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: Query: %v", tt.name, err)
+		}
+		var got []string
+		for _, result := range page.Results {
+			got = append(got, result.JobID)
+		}
+		if page.Total != tt.total || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %v of %d, want %v of %d", tt.name, got, page.Total, tt.want, tt.total)
+		}
+	}
+
+	found, err := history.FindByHash("hash-0")
+	if err != nil || len(found) != 3 {
+		t.Errorf("FindByHash = %d results, %v; want 3", len(found), err)
+	}
+}
+
+func TestHistoryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	history, err := NewHistoryStore(path)
+	if err != nil {
+		t.Fatalf("NewHistoryStore: %v", err)
+	}
+	if err := history.Record(&ProcessingResult{JobID: "kept", Status: "success", ProcessedAt: time.Now()}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	history.Close()
+
+	reopened, err := NewHistoryStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer reopened.Close()
+	if result, err := reopened.Get("kept"); err != nil || result == nil || result.Status != "success" {
+		t.Errorf("Get after reopening = %+v, %v", result, err)
+	}
+	if result, err := reopened.Get("unknown"); err != nil || result != nil {
+		t.Errorf("Get of an unknown job = %+v, %v", result, err)
+	}
+}
+
+func TestHistoryRecordRefusesExistingJobID(t *testing.T) {
+	history := newTestHistory(t)
+	first := &ProcessingResult{JobID: "client-1", ContentHash: "aaa", Status: "success"}
+	if err := history.Record(first); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	// Another image under the same ID must not replace the first job
+	second := &ProcessingResult{JobID: "client-1", ContentHash: "bbb", Status: "error"}
+	if err := history.Record(second); !errors.Is(err, ErrJobExists) {
+		t.Fatalf("Record of a used job ID = %v, want ErrJobExists", err)
+	}
+	if stored, err := history.Get("client-1"); err != nil || stored.ContentHash != "aaa" {
+		t.Errorf("Get = %+v, %v; want the first job", stored, err)
+	}
+	if found, err := history.FindByHash("bbb"); err != nil || len(found) != 0 {
+		t.Errorf("FindByHash of the refused image = %d results, %v", len(found), err)
+	}
+}
+
+func TestClaimJobID(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	po.SetHistoryStore(newTestHistory(t))
+
+	release, err := po.ClaimJobID("client-2")
+	if err != nil {
+		t.Fatalf("ClaimJobID: %v", err)
+	}
+	if _, err := po.ClaimJobID("client-2"); !errors.Is(err, ErrJobExists) {
+		t.Errorf("second claim of a running job = %v, want ErrJobExists", err)
+	}
+	result := po.ProcessImageWithOptions(context.Background(), testPNG(t, 32, 32), "claimed.png", ProcessOptions{JobID: "client-2"})
+	release()
+	if result.JobID != "client-2" {
+		t.Fatalf("result = %+v", result)
+	}
+
+	// Once recorded the ID stays taken
+	if _, err := po.ClaimJobID("client-2"); !errors.Is(err, ErrJobExists) {
+		t.Errorf("claim of a recorded job = %v, want ErrJobExists", err)
+	}
+	if release, err := po.ClaimJobID(""); err != nil {
+		t.Errorf("claim of a generated ID: %v", err)
+	} else {
+		release()
+	}
+}
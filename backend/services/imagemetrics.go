@@ -0,0 +1,173 @@
+package services
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// luminance returns the image as a row-major slice of 0-255 luma values
+func luminance(img image.Image) ([]float64, int, int) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	gray := make([]float64, width*height)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			// ITU-R BT.601 weights on 16-bit channels, scaled back to 0-255
+			gray[y*width+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+	return gray, width, height
+}
+
+// laplacianVariance measures sharpness as the variance of the 4-neighbour
+// Laplacian of the luma channel; blurry images score low
+func laplacianVariance(gray []float64, width, height int) float64 {
+	if width < 3 || height < 3 {
+		return 0
+	}
+
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			lap := gray[i-width] + gray[i+width] + gray[i-1] + gray[i+1] - 4*gray[i]
+			sum += lap
+			sumSq += lap * lap
+			n++
+		}
+	}
+
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+// noiseSigma estimates the standard deviation of additive noise in the luma
+// channel (Immerkær's method), in 0-255 levels
+func noiseSigma(gray []float64, width, height int) float64 {
+	if width < 3 || height < 3 {
+		return 0
+	}
+
+	var sum float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			v := gray[i-width-1] - 2*gray[i-width] + gray[i-width+1] -
+				2*gray[i-1] + 4*gray[i] - 2*gray[i+1] +
+				gray[i+width-1] - 2*gray[i+width] + gray[i+width+1]
+			sum += math.Abs(v)
+		}
+	}
+	return sum * math.Sqrt(math.Pi/2) / (6 * float64(width-2) * float64(height-2))
+}
+
+// blockiness compares the mean luma step across 8-pixel block boundaries with
+// the mean step everywhere else. JPEG blocking makes boundary steps stand out,
+// pushing the ratio above 1; images without blocking score about 1.
+func blockiness(gray []float64, width, height int) float64 {
+	var boundary, inner float64
+	var nBoundary, nInner int
+	step := func(a, b float64, onBoundary bool) {
+		if onBoundary {
+			boundary += math.Abs(a - b)
+			nBoundary++
+		} else {
+			inner += math.Abs(a - b)
+			nInner++
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 1; x < width; x++ {
+			i := y*width + x
+			step(gray[i], gray[i-1], x%8 == 0)
+		}
+	}
+	for y := 1; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			step(gray[i], gray[i-width], y%8 == 0)
+		}
+	}
+
+	if nBoundary == 0 || nInner == 0 || inner == 0 {
+		return 1
+	}
+	return (boundary / float64(nBoundary)) / (inner / float64(nInner))
+}
+
+// blockAlignedCrop returns a centred crop of at most size pixels on each edge
+// whose origin stays on the 8-pixel block grid of the image
+func blockAlignedCrop(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= size && bounds.Dy() <= size {
+		return img
+	}
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return img
+	}
+
+	x := max(0, (bounds.Dx()-size)/2) &^ 7
+	y := max(0, (bounds.Dy()-size)/2) &^ 7
+	rect := image.Rect(x, y, x+size, y+size).Add(bounds.Min).Intersect(bounds)
+	return sub.SubImage(rect)
+}
+
+// meanStdDev returns the mean and standard deviation of the values
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum, sumSq float64
+	for _, v := range values {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(values))
+	mean := sum / n
+	return mean, math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+}
+
+// psnr returns the peak signal-to-noise ratio in dB between two images of the
+// same size, over the RGB channels. Identical images return +Inf.
+func psnr(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	width, height := ab.Dx(), ab.Dy()
+
+	var sumSq float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sumSq += d * d
+			}
+		}
+	}
+
+	mse := sumSq / float64(width*height*3)
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// resizeTo scales img to the given size with bilinear interpolation
+func resizeTo(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func fillGray(width, height int, value func(x, y int) uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetGray(x, y, color.Gray{Y: value(x, y)})
+		}
+	}
+	return img
+}
+
+func TestImageMetrics(t *testing.T) {
+	flat := fillGray(32, 32, func(x, y int) uint8 { return 128 })
+	checker := fillGray(32, 32, func(x, y int) uint8 { return uint8((x+y)%2) * 255 })
+	blocky := fillGray(32, 32, func(x, y int) uint8 { return uint8((x/8+y/8)%2)*40 + uint8(x%2)*4 })
+
+	gray, w, h := luminance(flat)
+	if v := laplacianVariance(gray, w, h); v != 0 {
+		t.Errorf("sharpness of a flat image = %v, want 0", v)
+	}
+	if sigma := noiseSigma(gray, w, h); sigma != 0 {
+		t.Errorf("noise of a flat image = %v, want 0", sigma)
+	}
+	if b := blockiness(gray, w, h); b != 1 {
+		t.Errorf("blockiness of a flat image = %v, want 1", b)
+	}
+
+	gray, w, h = luminance(checker)
+	if v := laplacianVariance(gray, w, h); v < 1000 {
+		t.Errorf("sharpness of a checkerboard = %v, want it high", v)
+	}
+	gray, w, h = luminance(blocky)
+	if b := blockiness(gray, w, h); b <= 2 {
+		t.Errorf("blockiness of 8x8 blocks = %v, want well above 1", b)
+	}
+
+	if p := psnr(flat, flat); !math.IsInf(p, 1) {
+		t.Errorf("psnr of identical images = %v, want +Inf", p)
+	}
+	if p := psnr(flat, checker); p > 10 {
+		t.Errorf("psnr of unrelated images = %v dB, want it low", p)
+	}
+}
+
+func TestBlockAlignedCrop(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	crop := blockAlignedCrop(img, 32).Bounds()
+	if crop.Min.X%8 != 0 || crop.Min.Y%8 != 0 || crop.Dx() != 32 || crop.Dy() != 32 {
+		t.Errorf("crop = %v, want a 32px square on the block grid", crop)
+	}
+	if small := image.NewRGBA(image.Rect(0, 0, 20, 20)); blockAlignedCrop(small, 32) != image.Image(small) {
+		t.Error("an image within the crop size was cropped")
+	}
+}
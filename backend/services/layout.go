@@ -0,0 +1,259 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholders allowed in a key template. Each must fill a whole path segment,
+// and {key} must be the last one.
+const (
+	PlaceholderFolder = "{folder}"
+	PlaceholderTenant = "{tenant}"
+	PlaceholderYear   = "{yyyy}"
+	PlaceholderMonth  = "{mm}"
+	PlaceholderDay    = "{dd}"
+	PlaceholderKey    = "{key}"
+)
+
+// DefaultKeyTemplate is the original layout: <folder>/<object key>
+const DefaultKeyTemplate = PlaceholderFolder + "/" + PlaceholderKey
+
+// DefaultTenantSegment stands in for {tenant} when an image has no tenant
+const DefaultTenantSegment = "default"
+
+// ErrInvalidTenant is returned for tenant IDs that cannot name a key segment
+var ErrInvalidTenant = errors.New("tenant may only contain letters, digits, '-' and '_' (max 64 characters) and must not be \"" + DefaultTenantSegment + "\"")
+
+var validTenant = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateTenant checks a tenant ID before it is used in object keys; empty
+// means no tenant and is valid
+func ValidateTenant(tenant string) error {
+	if tenant == "" {
+		return nil
+	}
+	if !validTenant.MatchString(tenant) || tenant == DefaultTenantSegment {
+		return fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	return nil
+}
+
+// FolderLayout maps the pipeline's logical folders to object keys in the
+// bucket. Folder names can be renamed, and a key template adds tenant and date
+// partitions, e.g. "{tenant}/{folder}/{yyyy}/{mm}/{dd}/{key}".
+type FolderLayout struct {
+	template []string
+	names    map[string]string // logical folder -> name in the bucket
+	folders  map[string]string // name in the bucket -> logical folder
+}
+
+// LayoutKey is an object key broken down into its layout components
+type LayoutKey struct {
+	Folder    string    `json:"folder"` // logical folder
+	Tenant    string    `json:"tenant,omitempty"`
+	Date      time.Time `json:"date,omitempty"` // zero if the template has no date
+	ObjectKey string    `json:"object_key"`
+}
+
+// DefaultFolderLayout returns the original layout with the default folder names
+func DefaultFolderLayout() *FolderLayout {
+	layout, _ := NewFolderLayout(DefaultKeyTemplate, nil)
+	return layout
+}
+
+// NewFolderLayout parses a key template and folder renames (logical -> bucket name)
+func NewFolderLayout(template string, names map[string]string) (*FolderLayout, error) {
+	segments := strings.Split(strings.Trim(template, "/"), "/")
+	seen := make(map[string]bool)
+	for i, segment := range segments {
+		switch segment {
+		case PlaceholderFolder, PlaceholderTenant, PlaceholderYear, PlaceholderMonth, PlaceholderDay, PlaceholderKey:
+			if seen[segment] {
+				return nil, fmt.Errorf("key template %q repeats %s", template, segment)
+			}
+			seen[segment] = true
+		default:
+			if segment == "" || strings.ContainsAny(segment, "{}") {
+				return nil, fmt.Errorf("key template %q has invalid segment %q", template, segment)
+			}
+		}
+		if segment == PlaceholderKey && i != len(segments)-1 {
+			return nil, fmt.Errorf("key template %q must end with %s", template, PlaceholderKey)
+		}
+	}
+	if !seen[PlaceholderFolder] || !seen[PlaceholderKey] {
+		return nil, fmt.Errorf("key template %q must contain %s and end with %s", template, PlaceholderFolder, PlaceholderKey)
+	}
+	if seen[PlaceholderDay] && !seen[PlaceholderMonth] || seen[PlaceholderMonth] && !seen[PlaceholderYear] {
+		return nil, fmt.Errorf("key template %q: {dd} needs {mm} and {mm} needs {yyyy}", template)
+	}
+
+	layout := &FolderLayout{
+		template: segments,
+		names:    make(map[string]string),
+		folders:  make(map[string]string),
+	}
+	for _, folder := range []string{FolderGoodQuality, FolderUpscaled, FolderCouldntUpscale, FolderProcessing, FolderOriginals, FolderThumbnails} {
+		layout.names[folder] = folder
+	}
+	for folder, name := range names {
+		if _, ok := layout.names[folder]; !ok {
+			return nil, fmt.Errorf("unknown folder %q", folder)
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid name %q for folder %s", name, folder)
+		}
+		layout.names[folder] = name
+	}
+	for folder, name := range layout.names {
+		if other, taken := layout.folders[name]; taken {
+			return nil, fmt.Errorf("folders %s and %s are both named %q", other, folder, name)
+		}
+		layout.folders[name] = folder
+	}
+
+	return layout, nil
+}
+
+// FolderName returns the bucket name of a logical folder. Folders the layout
+// does not know, such as routing rule destinations, keep their own name.
+func (fl *FolderLayout) FolderName(folder string) string {
+	if name, ok := fl.names[folder]; ok {
+		return name
+	}
+	return folder
+}
+
+// Key returns the bucket key of an image stored in folder at the given time
+func (fl *FolderLayout) Key(folder, tenant string, at time.Time, objectKey string) string {
+	at = at.UTC()
+	parts := make([]string, len(fl.template))
+	for i, segment := range fl.template {
+		switch segment {
+		case PlaceholderFolder:
+			parts[i] = fl.FolderName(folder)
+		case PlaceholderTenant:
+			parts[i] = tenantSegment(tenant)
+		case PlaceholderYear:
+			parts[i] = fmt.Sprintf("%04d", at.Year())
+		case PlaceholderMonth:
+			parts[i] = fmt.Sprintf("%02d", int(at.Month()))
+		case PlaceholderDay:
+			parts[i] = fmt.Sprintf("%02d", at.Day())
+		case PlaceholderKey:
+			parts[i] = strings.TrimLeft(objectKey, "/")
+		default:
+			parts[i] = segment
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Prefix returns the longest key prefix shared by every object in folder, for
+// listing it. With the tenant before the folder this is the tenant-independent
+// part only, so callers must still Parse each listed key.
+func (fl *FolderLayout) Prefix(folder string) string {
+	var parts []string
+	for _, segment := range fl.template {
+		switch segment {
+		case PlaceholderFolder:
+			parts = append(parts, fl.FolderName(folder))
+		case PlaceholderTenant, PlaceholderYear, PlaceholderMonth, PlaceholderDay, PlaceholderKey:
+			if len(parts) == 0 {
+				return ""
+			}
+			return strings.Join(parts, "/") + "/"
+		default:
+			parts = append(parts, segment)
+		}
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+// Parse breaks a bucket key down into its layout components. It returns false
+// for keys that do not fit the template.
+func (fl *FolderLayout) Parse(key string) (LayoutKey, bool) {
+	var parsed LayoutKey
+	parts := strings.SplitN(key, "/", len(fl.template))
+	if len(parts) != len(fl.template) {
+		return parsed, false
+	}
+
+	year, month, day := 0, 1, 1
+	for i, segment := range fl.template {
+		part := parts[i]
+		if part == "" {
+			return parsed, false
+		}
+
+		var err error
+		switch segment {
+		case PlaceholderFolder:
+			folder, ok := fl.folders[part]
+			if !ok {
+				if _, renamed := fl.names[part]; renamed {
+					// The old name of a renamed folder is not part of this layout
+					return parsed, false
+				}
+				// A custom folder, e.g. a routing rule destination
+				folder = part
+			}
+			parsed.Folder = folder
+		case PlaceholderTenant:
+			if part != DefaultTenantSegment {
+				parsed.Tenant = part
+			}
+		case PlaceholderYear:
+			year, err = parseDatePart(part, 4, 1, 9999)
+		case PlaceholderMonth:
+			month, err = parseDatePart(part, 2, 1, 12)
+		case PlaceholderDay:
+			day, err = parseDatePart(part, 2, 1, 31)
+		case PlaceholderKey:
+			parsed.ObjectKey = part
+		default:
+			if part != segment {
+				return parsed, false
+			}
+		}
+		if err != nil {
+			return parsed, false
+		}
+	}
+
+	if year > 0 {
+		parsed.Date = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	}
+	return parsed, true
+}
+
+// String returns the key template
+func (fl *FolderLayout) String() string {
+	return strings.Join(fl.template, "/")
+}
+
+// tenantSegment returns the path segment used for a tenant, which callers
+// have checked with ValidateTenant
+func tenantSegment(tenant string) string {
+	if tenant == "" {
+		return DefaultTenantSegment
+	}
+	return tenant
+}
+
+// parseDatePart parses a fixed-width numeric date segment within bounds
+func parseDatePart(part string, width, lo, hi int) (int, error) {
+	if len(part) != width {
+		return 0, fmt.Errorf("expected %d digits", width)
+	}
+	n, err := strconv.Atoi(part)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("out of range")
+	}
+	return n, nil
+}
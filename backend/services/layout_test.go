@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFolderLayoutRoundTrip(t *testing.T) {
+	at := time.Date(2026, 2, 7, 23, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	day := time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC) // keys are partitioned by UTC date
+
+	tests := []struct {
+		template string
+		names    map[string]string
+		folder   string
+		tenant   string
+		wantKey  string
+		wantDate time.Time
+	}{
+		{DefaultKeyTemplate, nil, FolderUpscaled, "", "upscaled/photos/cat.png", time.Time{}},
+		{"{tenant}/{folder}/{yyyy}/{mm}/{dd}/{key}", nil, FolderGoodQuality, "acme", "acme/good_quality/2026/02/08/photos/cat.png", day},
+		{"{tenant}/{folder}/{yyyy}/{key}", nil, FolderOriginals, "", "default/originals/2026/photos/cat.png", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"images/{folder}/{key}", map[string]string{FolderCouldntUpscale: "failed"}, FolderCouldntUpscale, "", "images/failed/photos/cat.png", time.Time{}},
+		{DefaultKeyTemplate, nil, "scans", "", "scans/photos/cat.png", time.Time{}},
+	}
+	for _, tt := range tests {
+		layout, err := NewFolderLayout(tt.template, tt.names)
+		if err != nil {
+			t.Fatalf("NewFolderLayout(%q): %v", tt.template, err)
+		}
+		key := layout.Key(tt.folder, tt.tenant, at, "/photos/cat.png")
+		if key != tt.wantKey {
+			t.Errorf("%s: Key = %q, want %q", tt.template, key, tt.wantKey)
+			continue
+		}
+
+		parsed, ok := layout.Parse(key)
+		want := LayoutKey{Folder: tt.folder, Tenant: tt.tenant, Date: tt.wantDate, ObjectKey: "photos/cat.png"}
+		if !ok || parsed != want {
+			t.Errorf("%s: Parse(%q) = %+v, %v; want %+v", tt.template, key, parsed, ok, want)
+		}
+	}
+}
+
+func TestFolderLayoutParseRejectsForeignKeys(t *testing.T) {
+	layout, err := NewFolderLayout("{tenant}/{folder}/{yyyy}/{mm}/{key}", map[string]string{FolderUpscaled: "big"})
+	if err != nil {
+		t.Fatalf("NewFolderLayout: %v", err)
+	}
+	for _, key := range []string{
+		"acme/upscaled/2026/02/cat.png", // old name of a renamed folder
+		"acme/big/2026/13/cat.png",      // month out of range
+		"acme/big/26/02/cat.png",        // short year
+		"acme/big/2026/02",              // no object key
+		"acme//2026/02/cat.png",         // empty folder
+	} {
+		if parsed, ok := layout.Parse(key); ok {
+			t.Errorf("Parse(%q) = %+v, want no match", key, parsed)
+		}
+	}
+
+	if got := layout.Prefix(FolderUpscaled); got != "" {
+		t.Errorf("Prefix with the tenant first = %q, want empty", got)
+	}
+	if got := DefaultFolderLayout().Prefix(FolderUpscaled); got != "upscaled/" {
+		t.Errorf("default Prefix = %q, want upscaled/", got)
+	}
+}
+
+func TestNewFolderLayoutValidation(t *testing.T) {
+	for template, names := range map[string]map[string]string{
+		"{key}/{folder}":          nil,
+		"{folder}":                nil,
+		"{folder}/{folder}/{key}": nil,
+		"{folder}/{dd}/{key}":     nil,
+		"{folder}/x{yyyy}/{key}":  nil,
+		"{folder}//{key}":         nil,
+		"{folder}/{tenant}/{key}": {"thumbs": "t"},
+		"{tenant}/{folder}/{key}": {FolderUpscaled: "a/b"},
+		"{folder}/{yyyy}/{key}":   {FolderUpscaled: FolderGoodQuality},
+	} {
+		if _, err := NewFolderLayout(template, names); err == nil {
+			t.Errorf("NewFolderLayout(%q, %v) succeeded", template, names)
+		}
+	}
+}
+
+func TestValidateTenant(t *testing.T) {
+	for _, tenant := range []string{"", "acme", "team_2", "eu-west", strings.Repeat("a", 64)} {
+		if err := ValidateTenant(tenant); err != nil {
+			t.Errorf("ValidateTenant(%q) = %v", tenant, err)
+		}
+	}
+	for _, tenant := range []string{"a/b", "..", ".", DefaultTenantSegment, "acme corp", "café", strings.Repeat("a", 65)} {
+		if err := ValidateTenant(tenant); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("ValidateTenant(%q) = %v, want ErrInvalidTenant", tenant, err)
+		}
+	}
+
+	// The pipeline refuses them too rather than filing the image elsewhere
+	po, fake := newTestPipeline(t, 0.5)
+	result := po.ProcessImageWithOptions(context.Background(), testPNG(t, 16, 16), "cat.png", ProcessOptions{Tenant: "../acme"})
+	if result.Status != "error" || len(fake.keys()) != 0 {
+		t.Errorf("result = %+v, bucket holds %v", result, fake.keys())
+	}
+}
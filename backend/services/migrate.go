@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// LayoutMigrationOptions controls a move of stored images between folder layouts
+type LayoutMigrationOptions struct {
+	From        *FolderLayout
+	To          *FolderLayout
+	Folders     []string // logical folders to move; empty means the built-in ones
+	DryRun      bool
+	Concurrency int
+}
+
+// LayoutMove is a single object moved, or to be moved, to its new key
+type LayoutMove struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error,omitempty"`
+}
+
+// LayoutMigrationReport summarizes a layout migration
+type LayoutMigrationReport struct {
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	DryRun    bool         `json:"dry_run"`
+	Listed    int          `json:"listed"`
+	Unmatched int          `json:"unmatched"` // not in a migrated folder of the source layout
+	Unchanged int          `json:"unchanged"` // already fit the target layout
+	Moved     int          `json:"moved"`
+	Failed    int          `json:"failed"`
+	Moves     []LayoutMove `json:"moves,omitempty"`
+}
+
+// MigrateLayout moves every object in the selected folders of the source layout
+// to its key in the target layout, copying before deleting so an interrupted
+// run never loses an image. Keys already moved are recognized, so a run can
+// simply be repeated. Source layouts without a date
+// partition use each object's modification time.
+func MigrateLayout(ctx context.Context, storage *StorageService, opts LayoutMigrationOptions) (*LayoutMigrationReport, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	folders := opts.Folders
+	if len(folders) == 0 {
+		folders = []string{FolderGoodQuality, FolderUpscaled, FolderCouldntUpscale, FolderProcessing, FolderOriginals, FolderThumbnails}
+	}
+
+	report := &LayoutMigrationReport{
+		From:   opts.From.String(),
+		To:     opts.To.String(),
+		DryRun: opts.DryRun,
+	}
+
+	objects, err := listFolders(ctx, storage, opts.From, folders)
+	if err != nil {
+		return nil, err
+	}
+	report.Listed = len(objects)
+
+	// When the target adds partitions, already moved keys also fit the shorter
+	// source template and must be recognized by the target one instead
+	deeper := len(opts.To.template) > len(opts.From.template)
+
+	var moves []LayoutMove
+	for _, obj := range objects {
+		if migrated, ok := opts.To.Parse(obj.Key); deeper && ok && slices.Contains(folders, migrated.Folder) {
+			report.Unchanged++
+			continue
+		}
+
+		parsed, ok := opts.From.Parse(obj.Key)
+		if !ok || !slices.Contains(folders, parsed.Folder) {
+			report.Unmatched++
+			continue
+		}
+
+		date := parsed.Date
+		if date.IsZero() {
+			date = obj.LastModified
+		}
+		target := opts.To.Key(parsed.Folder, parsed.Tenant, date, parsed.ObjectKey)
+		if target == obj.Key {
+			// E.g. a folder the target layout does not rename
+			report.Unchanged++
+			continue
+		}
+		moves = append(moves, LayoutMove{From: obj.Key, To: target})
+	}
+
+	if opts.DryRun {
+		report.Moves = moves
+		return report, nil
+	}
+
+	var mu sync.Mutex
+	forEachConcurrently(ctx, opts.Concurrency, len(moves), func(i int) {
+		move := moves[i]
+		err := storage.CopyObject(ctx, move.From, move.To)
+		if err == nil && move.From != move.To {
+			err = storage.DeleteObject(ctx, move.From)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			move.Error = err.Error()
+			report.Failed++
+		} else {
+			report.Moved++
+		}
+		report.Moves = append(report.Moves, move)
+	})
+
+	return report, ctx.Err()
+}
+
+// listFolders lists the objects under each folder's prefix in layout, listing
+// shared prefixes only once
+func listFolders(ctx context.Context, storage *StorageService, layout *FolderLayout, folders []string) ([]ObjectInfo, error) {
+	var prefixes []string
+	for _, folder := range folders {
+		prefix := layout.Prefix(folder)
+		if prefix == "" {
+			// The folder is not the leading segment, so the whole bucket is needed
+			prefixes = []string{""}
+			break
+		}
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	var objects []ObjectInfo
+	for _, prefix := range prefixes {
+		listed, err := storage.ListPrefix(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+		}
+		objects = append(objects, listed...)
+	}
+	return objects, nil
+}
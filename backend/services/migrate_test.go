@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMigrateLayoutPartialRename(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+	storage := newTestStorage(fake, testBucket)
+	ctx := context.Background()
+	for _, key := range []string{"good_quality/a.png", "upscaled/b.png", "couldn't_upscale/c.png"} {
+		if _, err := storage.UploadObject(ctx, key, testPNG(t, 8, 8), nil); err != nil {
+			t.Fatalf("UploadObject: %v", err)
+		}
+	}
+
+	// Only couldn't_upscale is renamed, so the other folders keep their keys
+	to, err := NewFolderLayout(DefaultKeyTemplate, map[string]string{FolderCouldntUpscale: "failed"})
+	if err != nil {
+		t.Fatalf("NewFolderLayout: %v", err)
+	}
+	opts := LayoutMigrationOptions{From: DefaultFolderLayout(), To: to}
+	uploads := len(fake.requests)
+
+	report, err := MigrateLayout(ctx, storage, opts)
+	if err != nil {
+		t.Fatalf("MigrateLayout: %v", err)
+	}
+	if report.Moved != 1 || report.Unchanged != 2 || report.Failed != 0 {
+		t.Errorf("report = %+v, want 1 moved and 2 unchanged", report)
+	}
+	want := []string{"failed/c.png", "good_quality/a.png", "upscaled/b.png"}
+	if got := fake.keys(); !slices.Equal(got, want) {
+		t.Fatalf("bucket holds %v, want %v", got, want)
+	}
+	for _, request := range fake.requests[uploads:] {
+		if request == "PUT /"+testBucket+"/good_quality/a.png" || request == "DELETE /"+testBucket+"/good_quality/a.png" {
+			t.Errorf("unchanged key was touched: %s", request)
+		}
+	}
+
+	// A repeated run finds nothing left to move
+	report, err = MigrateLayout(ctx, storage, opts)
+	if err != nil || report.Moved != 0 || len(fake.keys()) != 3 {
+		t.Errorf("second run report = %+v, %v; bucket holds %v", report, err, fake.keys())
+	}
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Model types understood by the registry
+const (
+	ModelTypeScript  = "script"  // Python script invoked with --input/--output/--scale
+	ModelTypeHTTP    = "http"    // HTTP service receiving the image as the POST body
+	ModelTypeBicubic = "bicubic" // in-process bicubic resize
+)
+
+// ModelSpec describes an upscaling model available to the pipeline
+type ModelSpec struct {
+	ID             string   `json:"id"`
+	Description    string   `json:"description"`
+	Type           string   `json:"type"`
+	Script         string   `json:"script,omitempty"`
+	Args           []string `json:"args,omitempty"` // extra script arguments, e.g. weights path
+	Endpoint       string   `json:"endpoint,omitempty"`
+	Scales         []int    `json:"scales"`
+	MaxInputPixels int      `json:"max_input_pixels,omitempty"` // 0 means unlimited
+}
+
+// ModelRegistry holds the configured models, the default fallback chain and
+// per-tenant model choices
+type ModelRegistry struct {
+	specs         []ModelSpec
+	upscalers     map[string]Upscaler
+	defaultChain  []string
+	tenantModels  map[string]string
+	contentModels map[string]string
+}
+
+// registryFile is the JSON layout of a model registry file
+type registryFile struct {
+	Models []ModelSpec `json:"models"`
+}
+
+// DefaultModelSpecs returns the built-in models: the Python script and in-process bicubic
+func DefaultModelSpecs(upscaleScript string) []ModelSpec {
+	return []ModelSpec{
+		{
+			ID:          StrategyScript,
+			Description: "Python upscaler script",
+			Type:        ModelTypeScript,
+			Script:      upscaleScript,
+			Scales:      []int{2, 3, 4},
+		},
+		{
+			ID:          StrategyBicubic,
+			Description: "In-process bicubic (Catmull-Rom) resize",
+			Type:        ModelTypeBicubic,
+			Scales:      []int{2, 3, 4},
+		},
+	}
+}
+
+// NewModelRegistry builds a registry from model specs. The default chain is
+// every model in the order given until SetDefaultChain is called.
+func NewModelRegistry(specs []ModelSpec) (*ModelRegistry, error) {
+	registry := &ModelRegistry{
+		upscalers:     make(map[string]Upscaler),
+		tenantModels:  make(map[string]string),
+		contentModels: make(map[string]string),
+	}
+
+	for _, spec := range specs {
+		if spec.ID == "" {
+			return nil, fmt.Errorf("model without an id")
+		}
+		if _, exists := registry.upscalers[spec.ID]; exists {
+			return nil, fmt.Errorf("duplicate model id %q", spec.ID)
+		}
+
+		inner, err := newModelUpscaler(spec)
+		if err != nil {
+			return nil, fmt.Errorf("model %q: %w", spec.ID, err)
+		}
+		registry.specs = append(registry.specs, spec)
+		registry.upscalers[spec.ID] = &modelUpscaler{spec: spec, inner: inner}
+		registry.defaultChain = append(registry.defaultChain, spec.ID)
+	}
+
+	return registry, nil
+}
+
+// LoadModelRegistry reads model specs from a JSON file ({"models": [...]}), or
+// uses the built-in models if path is empty
+func LoadModelRegistry(path, upscaleScript string) (*ModelRegistry, error) {
+	if path == "" {
+		return NewModelRegistry(DefaultModelSpecs(upscaleScript))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model registry: %w", err)
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse model registry: %w", err)
+	}
+	if len(file.Models) == 0 {
+		return nil, fmt.Errorf("model registry %s lists no models", path)
+	}
+
+	return NewModelRegistry(file.Models)
+}
+
+// SetDefaultChain sets the models tried, in order, when a request names no model
+func (mr *ModelRegistry) SetDefaultChain(ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("upscaling fallback chain is empty")
+	}
+	for _, id := range ids {
+		if _, ok := mr.upscalers[id]; !ok {
+			return fmt.Errorf("unknown model %q in fallback chain", id)
+		}
+	}
+	mr.defaultChain = ids
+	return nil
+}
+
+// SetTenantModels sets the preferred model of each tenant
+func (mr *ModelRegistry) SetTenantModels(tenantModels map[string]string) error {
+	for tenant, id := range tenantModels {
+		if _, ok := mr.upscalers[id]; !ok {
+			return fmt.Errorf("unknown model %q for tenant %q", id, tenant)
+		}
+	}
+	mr.tenantModels = tenantModels
+	return nil
+}
+
+// SetContentModels sets the model used for each content class
+func (mr *ModelRegistry) SetContentModels(contentModels map[string]string) error {
+	for class, id := range contentModels {
+		if !slices.Contains(ContentClasses, class) {
+			return fmt.Errorf("unknown content class %q, expected one of %v", class, ContentClasses)
+		}
+		if _, ok := mr.upscalers[id]; !ok {
+			return fmt.Errorf("unknown model %q for content class %q", id, class)
+		}
+	}
+	mr.contentModels = contentModels
+	return nil
+}
+
+// Wrap replaces every model's upscaler with wrap(spec, upscaler), e.g. to add tiling
+func (mr *ModelRegistry) Wrap(wrap func(spec ModelSpec, upscaler Upscaler) Upscaler) {
+	for _, spec := range mr.specs {
+		mr.upscalers[spec.ID] = wrap(spec, mr.upscalers[spec.ID])
+	}
+}
+
+// Models returns the registered model specs
+func (mr *ModelRegistry) Models() []ModelSpec {
+	return mr.specs
+}
+
+// DefaultChain returns the model IDs tried when a request names no model
+func (mr *ModelRegistry) DefaultChain() []string {
+	return mr.defaultChain
+}
+
+// Has reports whether a model ID is registered
+func (mr *ModelRegistry) Has(id string) bool {
+	_, ok := mr.upscalers[id]
+	return ok
+}
+
+// Get returns the upscaler for a model ID
+func (mr *ModelRegistry) Get(id string) (Upscaler, bool) {
+	upscaler, ok := mr.upscalers[id]
+	return upscaler, ok
+}
+
+// ResolveModel returns the model a request should use: the requested one, else
+// the tenant's preferred model, else empty for the default chain
+func (mr *ModelRegistry) ResolveModel(requested, tenant string) string {
+	if requested != "" {
+		return requested
+	}
+	return mr.tenantModels[tenant]
+}
+
+// PrimaryModel returns the model a chain starts with: the preferred model if
+// registered, else the first of the default chain
+func (mr *ModelRegistry) PrimaryModel(preferred string) string {
+	if mr.Has(preferred) || len(mr.defaultChain) == 0 {
+		return preferred
+	}
+	return mr.defaultChain[0]
+}
+
+// Scales returns the pass factors supported by the model a chain starts with.
+// Nil means the model does not restrict them.
+func (mr *ModelRegistry) Scales(preferred string) []int {
+	id := mr.PrimaryModel(preferred)
+	for _, spec := range mr.specs {
+		if spec.ID == id {
+			return spec.Scales
+		}
+	}
+	return nil
+}
+
+// ContentModel returns the model configured for a content class, or empty
+func (mr *ModelRegistry) ContentModel(class string) string {
+	return mr.contentModels[class]
+}
+
+// Chain returns the upscalers to try for a request: the preferred model first,
+// then the default chain as fallback
+func (mr *ModelRegistry) Chain(preferred string) []Upscaler {
+	var chain []Upscaler
+	if upscaler, ok := mr.upscalers[preferred]; ok {
+		chain = append(chain, upscaler)
+	}
+	for _, id := range mr.defaultChain {
+		if id != preferred {
+			chain = append(chain, mr.upscalers[id])
+		}
+	}
+	return chain
+}
+
+// newModelUpscaler creates the upscaler backing a model spec
+func newModelUpscaler(spec ModelSpec) (Upscaler, error) {
+	switch spec.Type {
+	case ModelTypeScript:
+		if spec.Script == "" {
+			return nil, fmt.Errorf("script model needs a script")
+		}
+		return NewScriptUpscaler(spec.Script, spec.Args...), nil
+	case ModelTypeHTTP:
+		if spec.Endpoint == "" {
+			return nil, fmt.Errorf("http model needs an endpoint")
+		}
+		return NewHTTPUpscaler(spec.Endpoint), nil
+	case ModelTypeBicubic:
+		return NewBicubicUpscaler(), nil
+	default:
+		return nil, fmt.Errorf("unknown model type %q", spec.Type)
+	}
+}
+
+// modelUpscaler enforces a model's supported scales and input size limit
+type modelUpscaler struct {
+	spec  ModelSpec
+	inner Upscaler
+}
+
+// Name returns the model ID
+func (mu *modelUpscaler) Name() string {
+	return mu.spec.ID
+}
+
+// Upscale rejects requests the model cannot handle, then delegates
+func (mu *modelUpscaler) Upscale(ctx context.Context, imageData []byte, scale int) ([]byte, error) {
+	if mu.spec.MaxInputPixels > 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+		if err != nil {
+			return nil, Permanent(fmt.Errorf("failed to decode image: %w", err))
+		}
+		if err := mu.check(cfg.Width*cfg.Height, scale); err != nil {
+			return nil, err
+		}
+	} else if err := mu.check(0, scale); err != nil {
+		return nil, err
+	}
+	return mu.inner.Upscale(ctx, imageData, scale)
+}
+
+// upscaleDecoded applies the same checks to an already decoded image
+func (mu *modelUpscaler) upscaleDecoded(ctx context.Context, imageData []byte, img image.Image, scale int) ([]byte, error) {
+	bounds := img.Bounds()
+	if err := mu.check(bounds.Dx()*bounds.Dy(), scale); err != nil {
+		return nil, err
+	}
+	return upscaleImage(ctx, mu.inner, imageData, img, scale)
+}
+
+// check rejects a scale the model does not support or an input over its size limit
+func (mu *modelUpscaler) check(pixels, scale int) error {
+	if len(mu.spec.Scales) > 0 && !slices.Contains(mu.spec.Scales, scale) {
+		return Permanent(fmt.Errorf("model %s does not support %dx", mu.spec.ID, scale))
+	}
+	if mu.spec.MaxInputPixels > 0 && pixels > mu.spec.MaxInputPixels {
+		return Permanent(fmt.Errorf("model %s accepts at most %d pixels, got %d", mu.spec.ID, mu.spec.MaxInputPixels, pixels))
+	}
+	return nil
+}
+
+// HTTPUpscaler sends images to an upscaling service over HTTP. The service
+// receives the image as the POST body with the factor in the "scale" query
+// parameter and responds with the upscaled image.
+type HTTPUpscaler struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPUpscaler creates an upscaler backed by an HTTP endpoint
+func NewHTTPUpscaler(endpoint string) *HTTPUpscaler {
+	return &HTTPUpscaler{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Name returns the strategy name
+func (hu *HTTPUpscaler) Name() string {
+	return ModelTypeHTTP
+}
+
+// Upscale POSTs the image to the endpoint
+func (hu *HTTPUpscaler) Upscale(ctx context.Context, imageData []byte, scale int) ([]byte, error) {
+	u, err := url.Parse(hu.endpoint)
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("invalid model endpoint: %w", err))
+	}
+	query := u.Query()
+	query.Set("scale", strconv.Itoa(scale))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(imageData))
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := hu.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("model endpoint request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model endpoint response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("model endpoint responded with %s: %s", resp.Status, truncate(string(body), 200))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, Permanent(err)
+		}
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// truncate shortens s to at most n bytes for error messages
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func testModelRegistry(t *testing.T) *ModelRegistry {
+	t.Helper()
+	registry, err := NewModelRegistry([]ModelSpec{
+		{ID: "fast", Type: ModelTypeBicubic, Scales: []int{2, 4}},
+		{ID: "x4", Type: ModelTypeBicubic, Scales: []int{4}, MaxInputPixels: 64 * 64},
+		{ID: "fallback", Type: ModelTypeBicubic},
+	})
+	if err != nil {
+		t.Fatalf("NewModelRegistry: %v", err)
+	}
+	return registry
+}
+
+func chainNames(chain []Upscaler) []string {
+	var names []string
+	for _, upscaler := range chain {
+		names = append(names, upscaler.Name())
+	}
+	return names
+}
+
+func TestModelRegistryChain(t *testing.T) {
+	registry := testModelRegistry(t)
+	if err := registry.SetDefaultChain([]string{"fast", "fallback"}); err != nil {
+		t.Fatalf("SetDefaultChain: %v", err)
+	}
+
+	tests := []struct {
+		preferred string
+		want      []string
+	}{
+		{"", []string{"fast", "fallback"}},
+		{"x4", []string{"x4", "fast", "fallback"}},
+		{"fallback", []string{"fallback", "fast"}},
+		{"unknown", []string{"fast", "fallback"}},
+	}
+	for _, tt := range tests {
+		if got := chainNames(registry.Chain(tt.preferred)); !slices.Equal(got, tt.want) {
+			t.Errorf("Chain(%q) = %v, want %v", tt.preferred, got, tt.want)
+		}
+	}
+
+	if got := registry.Scales("x4"); !slices.Equal(got, []int{4}) {
+		t.Errorf("Scales(x4) = %v", got)
+	}
+	if got := registry.Scales(""); !slices.Equal(got, []int{2, 4}) {
+		t.Errorf("Scales of the default chain = %v", got)
+	}
+	if got := registry.Scales("fallback"); got != nil {
+		t.Errorf("Scales of an unrestricted model = %v, want nil", got)
+	}
+}
+
+func TestModelRegistryResolvesTenantAndContentModels(t *testing.T) {
+	registry := testModelRegistry(t)
+	if err := registry.SetTenantModels(map[string]string{"acme": "x4"}); err != nil {
+		t.Fatalf("SetTenantModels: %v", err)
+	}
+	if err := registry.SetContentModels(map[string]string{ContentClasses[0]: "fast"}); err != nil {
+		t.Fatalf("SetContentModels: %v", err)
+	}
+
+	if got := registry.ResolveModel("fast", "acme"); got != "fast" {
+		t.Errorf("requested model = %q, want fast", got)
+	}
+	if got := registry.ResolveModel("", "acme"); got != "x4" {
+		t.Errorf("tenant model = %q, want x4", got)
+	}
+	if got := registry.ResolveModel("", "globex"); got != "" {
+		t.Errorf("model of a tenant without one = %q, want the default chain", got)
+	}
+	if got := registry.ContentModel(ContentClasses[0]); got != "fast" {
+		t.Errorf("ContentModel = %q, want fast", got)
+	}
+
+	if err := registry.SetDefaultChain([]string{"missing"}); err == nil {
+		t.Error("SetDefaultChain accepted an unknown model")
+	}
+	if err := registry.SetTenantModels(map[string]string{"acme": "missing"}); err == nil {
+		t.Error("SetTenantModels accepted an unknown model")
+	}
+	if err := registry.SetContentModels(map[string]string{"not-a-class": "fast"}); err == nil {
+		t.Error("SetContentModels accepted an unknown class")
+	}
+}
+
+func TestModelUpscalerEnforcesSpec(t *testing.T) {
+	registry := testModelRegistry(t)
+	x4, _ := registry.Get("x4")
+	ctx := context.Background()
+
+	if _, err := x4.Upscale(ctx, testPNG(t, 16, 16), 2); err == nil || IsRetryable(err) {
+		t.Errorf("unsupported scale = %v, want a permanent error", err)
+	}
+	if _, err := x4.Upscale(ctx, testPNG(t, 128, 128), 4); err == nil || IsRetryable(err) {
+		t.Errorf("oversized input = %v, want a permanent error", err)
+	}
+	if out, err := x4.Upscale(ctx, testPNG(t, 16, 16), 4); err != nil || len(out) == 0 {
+		t.Errorf("supported request = %d bytes, %v", len(out), err)
+	}
+}
+
+func TestLoadModelRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	if err := os.WriteFile(path, []byte(`{"models": [{"id": "resize", "type": "bicubic", "scales": [2]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := LoadModelRegistry(path, "upscale.py")
+	if err != nil {
+		t.Fatalf("LoadModelRegistry: %v", err)
+	}
+	if !registry.Has("resize") || !slices.Equal(registry.DefaultChain(), []string{"resize"}) {
+		t.Errorf("loaded models = %+v", registry.Models())
+	}
+
+	for name, content := range map[string]string{
+		"duplicate id": `{"models": [{"id": "a", "type": "bicubic"}, {"id": "a", "type": "bicubic"}]}`,
+		"unknown type": `{"models": [{"id": "a", "type": "onnx"}]}`,
+		"no script":    `{"models": [{"id": "a", "type": "script"}]}`,
+		"empty":        `{"models": []}`,
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadModelRegistry(path, "upscale.py"); err == nil {
+			t.Errorf("%s: LoadModelRegistry succeeded", name)
+		}
+	}
+}
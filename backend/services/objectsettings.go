@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Server-side encryption modes
+const (
+	EncryptionNone   = "none"
+	EncryptionSSES3  = "sse-s3"
+	EncryptionSSEKMS = "sse-kms"
+)
+
+// AllFolders is the FolderTags key whose tags apply to objects in any folder
+const AllFolders = "*"
+
+// S3 limits on object tags
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// WriteSettings are applied to every object the storage service writes,
+// whether uploaded, copied or uploaded by a client with a pre-signed URL
+type WriteSettings struct {
+	Encryption   string                       // none, sse-s3 or sse-kms; empty uses the bucket default
+	KMSKeyID     string                       // sse-kms only; empty uses the AWS managed key
+	StorageClass string                       // e.g. STANDARD_IA; empty uses the bucket default
+	FolderTags   map[string]map[string]string // logical folder (or AllFolders) -> tags
+	Layout       *FolderLayout                // maps keys to logical folders for FolderTags
+}
+
+// ParseFolderTags parses per-folder tags given as URL query strings, e.g.
+// {"good_quality": "quality=good", "*": "classification=internal&owner=ml"}
+func ParseFolderTags(raw map[string]string) (map[string]map[string]string, error) {
+	folderTags := make(map[string]map[string]string, len(raw))
+	for folder, query := range raw {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("tags for folder %q: %w", folder, err)
+		}
+		tags := make(map[string]string, len(values))
+		for key := range values {
+			tags[key] = values.Get(key)
+		}
+		folderTags[folder] = tags
+	}
+	return folderTags, nil
+}
+
+// SetWriteSettings validates and sets the encryption, storage class and tags
+// of written objects
+func (ss *StorageService) SetWriteSettings(settings WriteSettings) error {
+	switch strings.ToLower(settings.Encryption) {
+	case "", EncryptionNone:
+		settings.Encryption = ""
+	case EncryptionSSES3, strings.ToLower(string(types.ServerSideEncryptionAes256)):
+		settings.Encryption = EncryptionSSES3
+	case EncryptionSSEKMS, string(types.ServerSideEncryptionAwsKms):
+		settings.Encryption = EncryptionSSEKMS
+	default:
+		return fmt.Errorf("unknown encryption mode %q: use %s, %s or %s", settings.Encryption, EncryptionNone, EncryptionSSES3, EncryptionSSEKMS)
+	}
+	if settings.KMSKeyID != "" && settings.Encryption != EncryptionSSEKMS {
+		return fmt.Errorf("a KMS key ID requires %s encryption", EncryptionSSEKMS)
+	}
+
+	settings.StorageClass = strings.ToUpper(settings.StorageClass)
+	if settings.StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(settings.StorageClass)) {
+		return fmt.Errorf("unknown storage class %q", settings.StorageClass)
+	}
+
+	for folder := range settings.FolderTags {
+		if err := validateTags(mergedTags(settings.FolderTags, folder)); err != nil {
+			return fmt.Errorf("tags for folder %q: %w", folder, err)
+		}
+	}
+	if settings.Layout == nil {
+		settings.Layout = DefaultFolderLayout()
+	}
+
+	ss.writeSettings = settings
+	return nil
+}
+
+// WriteSettings returns the settings applied to written objects
+func (ss *StorageService) WriteSettings() WriteSettings {
+	return ss.writeSettings
+}
+
+// validateTags checks a tag set against the S3 limits
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("%d tags, S3 allows at most %d per object", len(tags), maxObjectTags)
+	}
+	for key, value := range tags {
+		if key == "" || len(key) > maxTagKeyLength {
+			return fmt.Errorf("tag key %q must be 1-%d characters", key, maxTagKeyLength)
+		}
+		if len(value) > maxTagValueLength {
+			return fmt.Errorf("value of tag %q is longer than %d characters", key, maxTagValueLength)
+		}
+	}
+	return nil
+}
+
+// mergedTags returns the tags for a folder on top of the AllFolders tags
+func mergedTags(folderTags map[string]map[string]string, folder string) map[string]string {
+	tags := maps.Clone(folderTags[AllFolders])
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	if folder != AllFolders {
+		maps.Copy(tags, folderTags[folder])
+	}
+	return tags
+}
+
+// tagging returns the URL-encoded tags for the object at a full bucket key, or
+// "" if it gets none
+func (ss *StorageService) tagging(fullKey string) string {
+	settings := ss.writeSettings
+	if len(settings.FolderTags) == 0 {
+		return ""
+	}
+
+	folder := AllFolders
+	if parsed, ok := settings.Layout.Parse(fullKey); ok {
+		folder = parsed.Folder
+	}
+	tags := mergedTags(settings.FolderTags, folder)
+
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// encryption returns the SSE header value for the configured mode
+func (ss *StorageService) encryption() types.ServerSideEncryption {
+	switch ss.writeSettings.Encryption {
+	case EncryptionSSES3:
+		return types.ServerSideEncryptionAes256
+	case EncryptionSSEKMS:
+		return types.ServerSideEncryptionAwsKms
+	}
+	return ""
+}
+
+// applyWriteSettings sets encryption, storage class and tags on an upload
+func (ss *StorageService) applyWriteSettings(input *s3.PutObjectInput) {
+	input.ServerSideEncryption = ss.encryption()
+	if ss.writeSettings.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(ss.writeSettings.KMSKeyID)
+	}
+	input.StorageClass = types.StorageClass(ss.writeSettings.StorageClass)
+	if tagging := ss.tagging(aws.ToString(input.Key)); tagging != "" {
+		input.Tagging = aws.String(tagging)
+	}
+}
+
+// applyCopySettings sets encryption, storage class and tags on a copy. S3
+// does not carry encryption over, and the copy is tagged for its new folder.
+func (ss *StorageService) applyCopySettings(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption = ss.encryption()
+	if ss.writeSettings.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(ss.writeSettings.KMSKeyID)
+	}
+	input.StorageClass = types.StorageClass(ss.writeSettings.StorageClass)
+	if tagging := ss.tagging(aws.ToString(input.Key)); tagging != "" {
+		input.TaggingDirective = types.TaggingDirectiveReplace
+		input.Tagging = aws.String(tagging)
+	}
+}
+
+// ObjectTags reads the tags of the object at a full bucket key
+func (ss *StorageService) ObjectTags(ctx context.Context, fullKey string) (map[string]string, error) {
+	result, err := ss.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(fullKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object tags from S3: %w", err)
+	}
+
+	tags := make(map[string]string, len(result.TagSet))
+	for _, tag := range result.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
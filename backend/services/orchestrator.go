@@ -1,35 +1,158 @@
 package services
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"image"
+	"log"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// PipelineVersion is recorded on every stored object. Release builds set it
+// with -ldflags "-X visioncloud/services.PipelineVersion=<version>".
+var PipelineVersion = "dev"
+
 // ProcessingResult contains the result of image processing
 type ProcessingResult struct {
-	OriginalKey   string    `json:"original_key"`
-	Status        string    `json:"status"` // success, skipped, error
-	Folder        string    `json:"folder"`
-	S3URL         string    `json:"s3_url,omitempty"`
-	ErrorMessage  string    `json:"error_message,omitempty"`
-	ProcessedAt   time.Time `json:"processed_at"`
-	QualityScore  float64   `json:"quality_score"`
-	UpscaleScale  int       `json:"upscale_scale,omitempty"`
+	JobID           string             `json:"job_id"`
+	ContentHash     string             `json:"content_hash"` // hex SHA-256 of the uploaded bytes
+	Tenant          string             `json:"tenant,omitempty"`
+	OriginalKey     string             `json:"original_key"`
+	Status          string             `json:"status"`                   // success, skipped, error
+	Folder          string             `json:"folder"`                   // logical folder; see FolderLayout
+	StorageKey      string             `json:"storage_key,omitempty"`    // full bucket key of the stored image
+	OriginalCopy    string             `json:"original_copy,omitempty"`  // key of the source kept next to an upscaled image
+	S3URL           string             `json:"s3_url,omitempty"`         // pre-signed download URL of the stored image
+	URLExpiresAt    time.Time          `json:"url_expires_at,omitempty"` // when S3URL stops working
+	ErrorMessage    string             `json:"error_message,omitempty"`
+	ProcessedAt     time.Time          `json:"processed_at"`
+	QualityScore    float64            `json:"quality_score"`
+	QualityMetrics  map[string]float64 `json:"quality_metrics,omitempty"`
+	ContentClass    string             `json:"content_class,omitempty"` // photo, screenshot, document, ...
+	ClassConfidence float64            `json:"class_confidence,omitempty"`
+	ClassifiedBy    string             `json:"classified_by,omitempty"`
+	OutputFormat    string             `json:"output_format,omitempty"`  // format the upscaled image was stored in
+	OutputQuality   int                `json:"output_quality,omitempty"` // JPEG quality of the upscaled image
+	MatchedRule     string             `json:"matched_rule,omitempty"`   // routing rule that decided the outcome
+	RuleAction      string             `json:"rule_action,omitempty"`
+	UpscaleScale    int                `json:"upscale_scale,omitempty"`
+	UpscalePasses   []int              `json:"upscale_passes,omitempty"`   // factor of each chained pass
+	ScaleReason     string             `json:"scale_reason,omitempty"`     // why this scale was chosen
+	ModelID         string             `json:"model_id,omitempty"`         // model requested for this job
+	UpscaleStrategy string             `json:"upscale_strategy,omitempty"` // model that produced each pass
+	UpscaleAttempts int                `json:"upscale_attempts,omitempty"`
+	FailureReason   string             `json:"failure_reason,omitempty"`
+	RetryCount      int                `json:"retry_count,omitempty"`
+	StorageError    string             `json:"storage_error,omitempty"` // storage write failure, alongside any processing error
+	Spooled         bool               `json:"spooled,omitempty"`       // image kept in the local spool for a later upload
+	SpooledKey      string             `json:"spooled_key,omitempty"`   // bucket key the spooled image will be uploaded to
+	DeletedAt       time.Time          `json:"deleted_at,omitempty"`    // when retention deleted the stored image
+	Timings         Timings            `json:"timings_ms,omitempty"`
+
+	requestedFormat  string // output format asked for by the request, if any
+	requestedQuality int
+	stagingKey       string // staged copy of the input, kept until the image is stored
+	lastEventAt      time.Time
+}
+
+// Timings records how long each pipeline stage took, in milliseconds
+type Timings map[string]int64
+
+// Pipeline stage names used in Timings
+const (
+	StageAssess   = "assess"
+	StageClassify = "classify"
+	StageUpscale  = "upscale"
+	StageUpload   = "upload"
+	StageTotal    = "total"
+)
+
+// track adds the time elapsed since start to the named stage
+func (t Timings) track(stage string, start time.Time) {
+	t[stage] += time.Since(start).Milliseconds()
+}
+
+// Failure reasons recorded on images routed to the couldn't_upscale folder
+const (
+	FailureReasonAssessment = "assessment_failed"
+	FailureReasonUpscale    = "upscale_failed"
+	FailureReasonTooLarge   = "output_too_large"
+	FailureReasonInput      = "input_too_large" // refused before decoding
+)
+
+// ProcessOptions carries per-request settings for a pipeline run
+type ProcessOptions struct {
+	// JobID lets the caller pick the job ID up front, e.g. to subscribe to the
+	// job's events before the upload finishes. A random ID is used if empty.
+	JobID string
+
+	// Tenant identifies the client the image belongs to, if any
+	Tenant string
+
+	// ModelID selects the upscaling model, tried before the default fallback
+	// chain. If empty the tenant's configured model is used, if any.
+	ModelID string
+
+	// CallbackURL receives the result as a signed webhook when the job completes,
+	// overriding the tenant's configured webhook
+	CallbackURL string
+
+	// OutputFormat selects the format the upscaled image is stored in: png,
+	// jpeg, webp or original. If empty the content class's format is used,
+	// then the configured default.
+	OutputFormat string
+
+	// OutputQuality is the JPEG quality (1-100); 0 uses the configured quality
+	OutputQuality int
+
+	// StagedKey is the bucket key of an input already in the processing folder,
+	// e.g. uploaded with a pre-signed URL. It takes the place of the staged
+	// copy and is removed the same way.
+	StagedKey string
+
+	// RetryCount is the number of times this image has previously been retried
+	// out of the couldn't_upscale folder
+	RetryCount int
 }
 
 // PipelineOrchestrator orchestrates the image upscaling pipeline
 type PipelineOrchestrator struct {
 	qualityService *QualityService
 	storageService *StorageService
-	upscaleScript  string
-	tempDir        string
+	models         *ModelRegistry
+	router         *Router
+	classifier     *ContentClassifier
+	defaultFormat  string            // output format when neither the request nor the content class sets one
+	contentFormats map[string]string // content class -> output format
+	jpegQuality    int
+	layout         *FolderLayout
+	staging        bool
+	keepOriginals  bool
+	thumbnails     ThumbnailSettings
 	upscaleScale   int
+	scalePolicy    ScalePolicy
+	upscaleRetry   RetryPolicy
+	storageRetry   RetryPolicy
+	spool          *Spool
+	history        *HistoryStore
+	webhooks       *WebhookNotifier
+	shadow         *ShadowTester
+	uploadSigner   *UploadSigner
+	eventHooks     []EventHook
+
+	jobsMu     sync.Mutex
+	activeJobs map[string]bool // client-chosen job IDs being processed
 }
 
 // NewPipelineOrchestrator creates a new pipeline orchestrator
@@ -43,125 +166,690 @@ func NewPipelineOrchestrator(
 		upscaleScale = 2 // default 2x upscaling
 	}
 
-	tempDir := os.TempDir()
+	// The built-in models are valid specs, so this cannot fail
+	models, _ := NewModelRegistry(DefaultModelSpecs(upscaleScript))
+	models.SetDefaultChain([]string{StrategyScript})
+
+	// Without configured rules the router only applies the quality threshold
+	router, _ := NewRouter(nil, qualityService.QualityThreshold, models)
 
 	return &PipelineOrchestrator{
 		qualityService: qualityService,
 		storageService: storageService,
-		upscaleScript:  upscaleScript,
-		tempDir:        filepath.Join(tempDir, "visioncloud"),
+		models:         models,
+		router:         router,
+		classifier:     NewContentClassifier(nil, 0),
+		defaultFormat:  FormatOriginal,
+		jpegQuality:    90,
+		layout:         DefaultFolderLayout(),
+		keepOriginals:  true,
+		thumbnails:     ThumbnailSettings{Format: FormatWebP, Quality: 80},
 		upscaleScale:   upscaleScale,
+		upscaleRetry:   DefaultRetryPolicy(),
+		storageRetry:   DefaultRetryPolicy(),
+		activeJobs:     make(map[string]bool),
+	}
+}
+
+// SetModelRegistry replaces the available models and their fallback chain
+func (po *PipelineOrchestrator) SetModelRegistry(models *ModelRegistry) {
+	po.models = models
+}
+
+// Models returns the model registry used by the pipeline
+func (po *PipelineOrchestrator) Models() *ModelRegistry {
+	return po.models
+}
+
+// Storage returns the storage service images are written to
+func (po *PipelineOrchestrator) Storage() *StorageService {
+	return po.storageService
+}
+
+// SetRouter replaces the routing rules deciding where each image goes. The
+// router must have been built with the pipeline's model registry.
+func (po *PipelineOrchestrator) SetRouter(router *Router) {
+	po.router = router
+}
+
+// SetClassifier replaces the content classification stage
+func (po *PipelineOrchestrator) SetClassifier(classifier *ContentClassifier) {
+	po.classifier = classifier
+}
+
+// SetOutputFormats sets the format upscaled images are stored in: per content
+// class, with defaultFormat for the other classes. FormatOriginal keeps the
+// uploaded format. Requests may still choose their own format.
+func (po *PipelineOrchestrator) SetOutputFormats(defaultFormat string, contentFormats map[string]string, jpegQuality int) error {
+	if !validOutputChoice(defaultFormat) {
+		return fmt.Errorf("unsupported output format %q", defaultFormat)
+	}
+	for class, format := range contentFormats {
+		if !slices.Contains(ContentClasses, class) {
+			return fmt.Errorf("unknown content class %q, expected one of %v", class, ContentClasses)
+		}
+		if !validOutputChoice(format) {
+			return fmt.Errorf("unsupported output format %q for content class %q", format, class)
+		}
 	}
+	if jpegQuality < 1 || jpegQuality > 100 {
+		return fmt.Errorf("JPEG quality %d is outside 1-100", jpegQuality)
+	}
+	po.defaultFormat = defaultFormat
+	po.contentFormats = contentFormats
+	po.jpegQuality = jpegQuality
+	return nil
+}
+
+// SetFolderLayout configures how folders map to bucket keys, and whether
+// incoming images are staged in the processing folder while the pipeline runs
+func (po *PipelineOrchestrator) SetFolderLayout(layout *FolderLayout, staging bool) {
+	po.layout = layout
+	po.staging = staging
+}
+
+// Layout returns the folder layout used for stored images
+func (po *PipelineOrchestrator) Layout() *FolderLayout {
+	return po.layout
+}
+
+// SetScalePolicy configures adaptive scale selection
+func (po *PipelineOrchestrator) SetScalePolicy(policy ScalePolicy) {
+	po.scalePolicy = policy
+}
+
+// SetSpool configures where images go when a storage write fails. Jobs whose
+// image is flushed from the spool later are completed by completeSpooled.
+func (po *PipelineOrchestrator) SetSpool(spool *Spool) {
+	po.spool = spool
+	spool.OnFlushed(po.completeSpooled)
+}
+
+// SetHistoryStore configures where processing results are persisted
+func (po *PipelineOrchestrator) SetHistoryStore(history *HistoryStore) {
+	po.history = history
+}
+
+// SetWebhookNotifier configures completion webhooks
+func (po *PipelineOrchestrator) SetWebhookNotifier(webhooks *WebhookNotifier) {
+	po.webhooks = webhooks
+}
+
+// ValidateCallback checks a client-supplied callback URL against the hosts
+// the webhook notifier may deliver to
+func (po *PipelineOrchestrator) ValidateCallback(ctx context.Context, callbackURL string) error {
+	if po.webhooks == nil {
+		return ValidateCallbackURL(callbackURL)
+	}
+	return po.webhooks.ValidateCallback(ctx, callbackURL)
+}
+
+// SetKeepOriginals sets whether the source of each upscaled image is kept in
+// the originals folder
+func (po *PipelineOrchestrator) SetKeepOriginals(keep bool) {
+	po.keepOriginals = keep
+}
+
+// SetShadowTester configures shadow comparisons of a candidate model
+func (po *PipelineOrchestrator) SetShadowTester(shadow *ShadowTester) {
+	po.shadow = shadow
+}
+
+// SetUploadSigner makes direct uploads go through this server with URLs signed
+// by signer instead of pre-signed S3 URLs
+func (po *PipelineOrchestrator) SetUploadSigner(signer *UploadSigner) {
+	po.uploadSigner = signer
+}
+
+// UploadSigner returns the signer for direct uploads, or nil if clients upload
+// to S3 directly
+func (po *PipelineOrchestrator) UploadSigner() *UploadSigner {
+	return po.uploadSigner
+}
+
+// OnEvent registers a hook that receives every pipeline stage transition
+func (po *PipelineOrchestrator) OnEvent(hook EventHook) {
+	po.eventHooks = append(po.eventHooks, hook)
+}
+
+// SetRetryPolicies configures backoff for upscaler and storage failures
+func (po *PipelineOrchestrator) SetRetryPolicies(upscale, storage RetryPolicy) {
+	po.upscaleRetry = upscale
+	po.storageRetry = storage
+}
+
+// ClaimJobID reserves a client-chosen job ID until release is called,
+// returning ErrJobExists if a job with that ID is running or recorded in the
+// history. An empty ID is generated later and needs no claim.
+func (po *PipelineOrchestrator) ClaimJobID(jobID string) (release func(), err error) {
+	if jobID == "" {
+		return func() {}, nil
+	}
+
+	po.jobsMu.Lock()
+	defer po.jobsMu.Unlock()
+	if po.activeJobs[jobID] {
+		return nil, fmt.Errorf("%w: %s", ErrJobExists, jobID)
+	}
+	if po.history != nil {
+		recorded, err := po.history.Get(jobID)
+		if err != nil {
+			return nil, err
+		}
+		if recorded != nil {
+			return nil, fmt.Errorf("%w: %s", ErrJobExists, jobID)
+		}
+	}
+
+	po.activeJobs[jobID] = true
+	return func() {
+		po.jobsMu.Lock()
+		delete(po.activeJobs, jobID)
+		po.jobsMu.Unlock()
+	}, nil
 }
 
 // ProcessImage processes a single image through the pipeline
 func (po *PipelineOrchestrator) ProcessImage(ctx context.Context, imageData []byte, objectKey string) *ProcessingResult {
+	return po.ProcessImageWithOptions(ctx, imageData, objectKey, ProcessOptions{})
+}
+
+// ProcessImageWithOptions processes a single image through the pipeline using per-request options
+func (po *PipelineOrchestrator) ProcessImageWithOptions(ctx context.Context, imageData []byte, objectKey string, opts ProcessOptions) *ProcessingResult {
+	jobID := opts.JobID
+	if jobID == "" {
+		jobID = newJobID()
+	}
+
+	hash := sha256.Sum256(imageData)
 	result := &ProcessingResult{
+		JobID:       jobID,
+		ContentHash: hex.EncodeToString(hash[:]),
+		Tenant:      opts.Tenant,
 		OriginalKey: objectKey,
 		ProcessedAt: time.Now(),
 		Status:      "error",
+		RetryCount:  opts.RetryCount,
+		Timings:     Timings{},
+		ModelID:     po.models.ResolveModel(opts.ModelID, opts.Tenant),
+
+		requestedFormat:  opts.OutputFormat,
+		requestedQuality: opts.OutputQuality,
+	}
+
+	// Handlers reject bad tenants up front; this guards the other callers
+	if err := ValidateTenant(opts.Tenant); err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+
+	result.stagingKey = opts.StagedKey
+	if result.stagingKey == "" {
+		result.stagingKey = po.stage(ctx, imageData, objectKey, result)
 	}
+	po.process(ctx, imageData, objectKey, result)
+	po.unstage(ctx, result.stagingKey, result)
+	result.Timings.track(StageTotal, result.ProcessedAt)
+	po.emit(result, EventDone)
 
+	if po.history != nil {
+		if err := po.history.Record(result); err != nil {
+			log.Printf("Warning: failed to record history for job %s: %v", result.JobID, err)
+		}
+	}
+	if po.webhooks != nil {
+		po.webhooks.Notify(result, opts.CallbackURL)
+	}
+
+	return result
+}
+
+// process runs the pipeline stages, filling in result as it goes
+func (po *PipelineOrchestrator) process(ctx context.Context, imageData []byte, objectKey string, result *ProcessingResult) {
 	// Step 1: Assess image quality
-	assessment, err := po.qualityService.AssessQuality(imageData)
+	po.emit(result, EventAssessing)
+	// The image is decoded once here; assessment, classification and the
+	// first upscaling pass all work from the decoded copy
+	assessStart := time.Now()
+	img, imgFormat, err := decodeImage(imageData, po.qualityService.MaxPixels)
+	var assessment *QualityAssessment
+	if err == nil {
+		assessment = po.qualityService.AssessImage(img, imgFormat)
+	} else {
+		err = fmt.Errorf("failed to decode image: %w", err)
+	}
+	result.Timings.track(StageAssess, assessStart)
 	if err != nil {
 		result.Status = "error"
 		result.Folder = FolderCouldntUpscale
 		result.ErrorMessage = fmt.Sprintf("Quality assessment failed: %v", err)
-		po.storageService.UploadImage(ctx, result.Folder, objectKey, imageData)
-		return result
+		result.FailureReason = FailureReasonAssessment
+		if errors.Is(err, ErrImageTooLarge) {
+			result.FailureReason = FailureReasonInput
+		}
+		po.store(ctx, result, objectKey, imageData, failureMetadata(result))
+		return
 	}
 
 	result.QualityScore = assessment.QualityScore
+	result.QualityMetrics = assessment.Metrics
+	po.emit(result, EventAssessed)
+
+	// Classification is advisory: a failure only means no class-specific choices
+	classifyStart := time.Now()
+	classification := po.classifier.ClassifyImage(ctx, imageData, img)
+	result.ContentClass = classification.Label
+	result.ClassConfidence = classification.Confidence
+	result.ClassifiedBy = classification.Source
+	if result.ModelID == "" {
+		result.ModelID = po.models.ContentModel(classification.Label)
+	}
+	result.Timings.track(StageClassify, classifyStart)
+	po.emit(result, EventClassified)
+
+	// Step 2: Route the image by the first matching rule
+	rule := po.router.Route(RouteInput{
+		Assessment: assessment,
+		FileSize:   int64(len(imageData)),
+		Tenant:     result.Tenant,
+		ObjectKey:  objectKey,
+		Class:      result.ContentClass,
+		EXIF:       ReadEXIF(imageData),
+	})
+	result.MatchedRule = rule.Name
+	result.RuleAction = rule.Action
 
-	// Step 2: Check if image is already good quality
-	if po.qualityService.IsGoodQuality(assessment) {
+	switch rule.Action {
+	case RuleActionReject:
+		result.Status = "skipped"
+		result.ErrorMessage = fmt.Sprintf("Rejected by routing rule %q", rule.Name)
+		return
+	case RuleActionAccept, RuleActionFolder:
 		result.Status = "success"
 		result.Folder = FolderGoodQuality
-		url, err := po.storageService.UploadImage(ctx, result.Folder, objectKey, imageData)
-		if err != nil {
+		if rule.Action == RuleActionFolder {
+			result.Folder = rule.Folder
+		}
+		if err := po.store(ctx, result, objectKey, imageData, nil); err != nil {
 			result.Status = "error"
-			result.ErrorMessage = fmt.Sprintf("Failed to upload good quality image: %v", err)
-			return result
+			result.ErrorMessage = fmt.Sprintf("Failed to upload image to %s: %v", result.Folder, err)
 		}
-		result.S3URL = url
-		return result
+		return
+	}
+
+	// Step 3: Image needs upscaling - choose the scale, then attempt upscale
+	// Passes are planned from the factors the first model of the chain supports
+	if rule.Model != "" {
+		result.ModelID = rule.Model
+	}
+	var plan *ScalePlan
+	scales := po.models.Scales(result.ModelID)
+	if rule.Scale > 0 {
+		plan, err = po.scalePolicy.PlanFixedScale(assessment.Width, assessment.Height, rule.Scale, scales)
+	} else {
+		plan, err = po.scalePolicy.PlanScale(assessment.Width, assessment.Height, po.upscaleScale, scales)
+	}
+	if err != nil {
+		result.Status = "error"
+		result.Folder = FolderCouldntUpscale
+		result.ErrorMessage = fmt.Sprintf("Upscaling skipped: %v", err)
+		result.FailureReason = FailureReasonUpscale
+		if errors.Is(err, errOutputTooLarge) {
+			result.FailureReason = FailureReasonTooLarge
+		}
+		po.store(ctx, result, objectKey, imageData, failureMetadata(result))
+		return
 	}
+	result.UpscaleScale = plan.Scale
+	result.UpscalePasses = plan.Passes
+	result.ScaleReason = plan.Reason
 
-	// Step 3: Image needs upscaling - attempt upscale
-	result.UpscaleScale = po.upscaleScale
-	upscaledData, err := po.upscaleImage(ctx, imageData)
+	po.emit(result, EventUpscaling)
+	upscaleStart := time.Now()
+	upscaledData, err := po.upscalePasses(ctx, imageData, img, plan.Passes, result)
+	upscaleLatency := time.Since(upscaleStart)
+	result.Timings.track(StageUpscale, upscaleStart)
 	if err != nil {
 		result.Status = "error"
 		result.Folder = FolderCouldntUpscale
 		result.ErrorMessage = fmt.Sprintf("Upscaling failed: %v", err)
+		result.FailureReason = FailureReasonUpscale
 
 		// Still upload the original image to couldn't_upscale folder
-		po.storageService.UploadImage(ctx, result.Folder, objectKey, imageData)
-		return result
+		po.store(ctx, result, objectKey, imageData, failureMetadata(result))
+		return
+	}
+
+	// The candidate model, if any, only runs in the background for comparison
+	if po.shadow != nil {
+		po.shadow.Observe(result, imageData, upscaledData, upscaleLatency)
+	}
+
+	// Store in the requested format, re-encoding unless the upscaler already
+	// produced it. JPEG is always re-encoded to apply the quality.
+	format, quality := po.outputFormat(result, assessment.Format)
+	if format == FormatJPEG || imageFormat(upscaledData) != format {
+		encoded, err := encodeImage(upscaledData, format, quality)
+		if err != nil {
+			result.Status = "error"
+			result.Folder = FolderCouldntUpscale
+			result.ErrorMessage = fmt.Sprintf("Encoding upscaled image failed: %v", err)
+			result.FailureReason = FailureReasonUpscale
+			po.store(ctx, result, objectKey, imageData, failureMetadata(result))
+			return
+		}
+		upscaledData = encoded
 	}
+	result.OutputFormat = format
+	if format == FormatJPEG {
+		result.OutputQuality = quality
+	}
+	sourceKey := objectKey
+	objectKey = withExtension(objectKey, format)
 
-	// Step 4: Upload upscaled image
+	// Step 4: Upload upscaled image, keeping its source so it can be compared or restored
 	result.Status = "success"
 	result.Folder = FolderUpscaled
-	url, err := po.storageService.UploadImage(ctx, result.Folder, objectKey, upscaledData)
-	if err != nil {
+	var link map[string]string
+	if po.keepOriginals {
+		link = po.storeOriginal(ctx, result, sourceKey, imageData, objectKey)
+	}
+	if err := po.store(ctx, result, objectKey, upscaledData, link); err != nil {
 		result.Status = "error"
 		result.ErrorMessage = fmt.Sprintf("Failed to upload upscaled image: %v", err)
-		return result
 	}
+}
 
-	result.S3URL = url
-	return result
+// outputFormat picks the format and JPEG quality of the upscaled image: the
+// request's choice, then the content class's, then the configured default.
+// FormatOriginal resolves to the uploaded format, or PNG if it cannot be encoded.
+func (po *PipelineOrchestrator) outputFormat(result *ProcessingResult, inputFormat string) (string, int) {
+	format := result.requestedFormat
+	if format == "" {
+		format = po.contentFormats[result.ContentClass]
+	}
+	if format == "" {
+		format = po.defaultFormat
+	}
+	if format == FormatOriginal {
+		format = inputFormat
+		if !ValidOutputFormat(format) {
+			format = FormatPNG
+		}
+	}
+
+	quality := result.requestedQuality
+	if quality <= 0 {
+		quality = po.jpegQuality
+	}
+	return format, quality
 }
 
-// upscaleImage calls the Python upscaling script via subprocess
-func (po *PipelineOrchestrator) upscaleImage(ctx context.Context, imageData []byte) ([]byte, error) {
-	// Create temporary directory if it doesn't exist
-	if err := os.MkdirAll(po.tempDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+// validOutputChoice reports whether format is an encodable format or FormatOriginal
+func validOutputChoice(format string) bool {
+	return format == FormatOriginal || ValidOutputFormat(format)
+}
+
+// stage keeps a copy of the incoming image in the processing folder while the
+// pipeline runs, so an interrupted job leaves its input in the bucket. Staging
+// is best effort: it returns an empty key if the copy could not be written.
+func (po *PipelineOrchestrator) stage(ctx context.Context, imageData []byte, objectKey string, result *ProcessingResult) string {
+	if !po.staging {
+		return ""
+	}
+
+	key := po.layout.Key(FolderProcessing, result.Tenant, result.ProcessedAt, result.JobID+"/"+objectKey)
+	if _, err := po.uploadWithRetry(ctx, key, imageData, pipelineMetadata(result)); err != nil {
+		log.Printf("Warning: failed to stage job %s: %v", result.JobID, err)
+		return ""
 	}
+	return key
+}
 
-	// Generate unique filenames for input/output
-	timestamp := time.Now().UnixNano()
-	inputPath := filepath.Join(po.tempDir, fmt.Sprintf("input_%d.png", timestamp))
-	outputPath := filepath.Join(po.tempDir, fmt.Sprintf("output_%d.png", timestamp))
+// unstage removes the staged copy once the image is stored in its final folder
+// or was rejected. Images that could not be stored stay staged for recovery.
+func (po *PipelineOrchestrator) unstage(ctx context.Context, stagingKey string, result *ProcessingResult) {
+	if stagingKey == "" || (result.S3URL == "" && result.Status != "skipped") {
+		return
+	}
+	if err := po.storageService.DeleteObject(ctx, stagingKey); err != nil {
+		log.Printf("Warning: failed to remove staged copy of job %s: %v", result.JobID, err)
+	}
+}
 
-	// Save image to temp file
-	if err := os.WriteFile(inputPath, imageData, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write temp image: %w", err)
+// emit publishes a stage transition to the registered event hooks
+func (po *PipelineOrchestrator) emit(result *ProcessingResult, stage string) {
+	if len(po.eventHooks) == 0 {
+		return
 	}
-	defer os.Remove(inputPath)
-	defer os.Remove(outputPath)
 
-	// Call Python upscaling script directly
-	cmd := exec.CommandContext(ctx, "python", po.upscaleScript,
-		"--input", inputPath,
-		"--output", outputPath,
-		"--scale", strconv.Itoa(po.upscaleScale),
-	)
+	now := time.Now()
+	previous := result.lastEventAt
+	if previous.IsZero() {
+		previous = result.ProcessedAt
+	}
+	result.lastEventAt = now
 
-	// Capture stderr for debugging
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	event := PipelineEvent{
+		JobID:       result.JobID,
+		Stage:       stage,
+		Timestamp:   now,
+		ElapsedMs:   now.Sub(result.ProcessedAt).Milliseconds(),
+		StageMs:     now.Sub(previous).Milliseconds(),
+		OriginalKey: result.OriginalKey,
+	}
+	switch stage {
+	case EventAssessed:
+		score := result.QualityScore
+		event.QualityScore = &score
+	case EventClassified:
+		event.ContentClass = result.ContentClass
+	case EventDone:
+		score := result.QualityScore
+		event.QualityScore = &score
+		event.Folder = result.Folder
+		event.Status = result.Status
+		event.Error = result.ErrorMessage
+	}
 
-	// Run the command with timeout
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("upscaling script failed: %w, stderr: %s", err, stderr.String())
+	for _, hook := range po.eventHooks {
+		hook(event)
 	}
+}
 
-	// Check if output file was created
-	if _, err := os.Stat(outputPath); err != nil {
-		return nil, fmt.Errorf("upscaled image not created: %w", err)
+// failureMetadata builds the object metadata stored with images in the couldn't_upscale folder
+func failureMetadata(result *ProcessingResult) map[string]string {
+	return map[string]string{
+		MetadataFailureReason:  result.FailureReason,
+		MetadataFailureMessage: result.ErrorMessage,
+		MetadataRetryCount:     strconv.Itoa(result.RetryCount),
 	}
+}
 
-	// Read the upscaled image
-	upscaledData, err := os.ReadFile(outputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read upscaled image: %w", err)
+// pipelineMetadata describes the job that wrote an object, for every object
+// the pipeline stores
+func pipelineMetadata(result *ProcessingResult) map[string]string {
+	metadata := map[string]string{
+		MetadataJobID:            result.JobID,
+		MetadataOriginalFilename: url.PathEscape(path.Base(result.OriginalKey)), // metadata values must be ASCII
+		MetadataPipelineVersion:  PipelineVersion,
+	}
+	if result.QualityMetrics != nil {
+		metadata[MetadataQualityScore] = strconv.FormatFloat(result.QualityScore, 'f', 4, 64)
 	}
+	if result.ContentClass != "" {
+		metadata[MetadataContentClass] = result.ContentClass
+	}
+	if result.Folder == FolderUpscaled {
+		metadata[MetadataScale] = strconv.Itoa(result.UpscaleScale)
+		metadata[MetadataModel] = result.UpscaleStrategy
+	}
+	return metadata
+}
+
+// upscalePasses applies each pass of a scale plan in turn, recording the
+// strategies that produced them in result
+func (po *PipelineOrchestrator) upscalePasses(ctx context.Context, imageData []byte, img image.Image, passes []int, result *ProcessingResult) ([]byte, error) {
+	var strategies []string
+	for i, scale := range passes {
+		var err error
+		var strategy string
+		imageData, strategy, err = po.upscaleWithFallback(ctx, imageData, img, scale, result)
+		img = nil // later passes start from the previous pass's output
+		if err != nil {
+			if len(passes) > 1 {
+				return nil, fmt.Errorf("pass %d of %d (%dx): %w", i+1, len(passes), scale, err)
+			}
+			return nil, err
+		}
+		strategies = append(strategies, strategy)
+	}
+
+	result.UpscaleStrategy = strings.Join(strategies, ",")
+	return imageData, nil
+}
+
+// upscaleWithFallback tries each strategy in the fallback chain, retrying transient
+// failures with backoff, and returns the name of the strategy that succeeded
+func (po *PipelineOrchestrator) upscaleWithFallback(ctx context.Context, imageData []byte, img image.Image, scale int, result *ProcessingResult) ([]byte, string, error) {
+	var failures []string
+	for _, upscaler := range po.models.Chain(result.ModelID) {
+		var upscaledData []byte
+		attempts, err := retryWithBackoff(ctx, po.upscaleRetry, func(ctx context.Context) error {
+			var err error
+			upscaledData, err = upscaleImage(ctx, upscaler, imageData, img, scale)
+			return err
+		})
+		result.UpscaleAttempts += attempts
+		if err == nil {
+			return upscaledData, upscaler.Name(), nil
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %v", upscaler.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, "", fmt.Errorf("all upscaling strategies failed: %s", strings.Join(failures, "; "))
+}
+
+// store writes data to result.Folder and records the outcome in result;
+// StorageKey is only set once the image is in the bucket. If the write fails
+// even after retries the image is kept in the local spool under SpooledKey so
+// it is not lost, and the failure is reported in result.StorageError.
+func (po *PipelineOrchestrator) store(ctx context.Context, result *ProcessingResult, objectKey string, data []byte, metadata map[string]string) error {
+	po.emit(result, EventUploading)
+	defer result.Timings.track(StageUpload, time.Now())
+
+	key := po.layout.Key(result.Folder, result.Tenant, result.ProcessedAt, objectKey)
+
+	metadata = maps.Clone(metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	maps.Copy(metadata, pipelineMetadata(result))
+
+	location, err := po.uploadWithRetry(ctx, key, data, metadata)
+	if err == nil {
+		result.StorageKey = key
+		result.S3URL = location
+		po.storageService.SignResultURL(ctx, result)
+		po.storeThumbnails(ctx, result, data)
+		return nil
+	}
+
+	result.StorageError = err.Error()
+	if po.spool == nil {
+		return err
+	}
+
+	if _, spoolErr := po.spool.Add(result, key, data, metadata, err); spoolErr != nil {
+		result.StorageError = fmt.Sprintf("%v; spooling also failed: %v", err, spoolErr)
+		return err
+	}
+	result.Spooled = true
+	result.SpooledKey = key
+
+	return err
+}
+
+// completeSpooled finishes the upload stage of a job whose image was spooled
+// and has now been uploaded to location: the recorded result is updated, the
+// thumbnails are generated and the staged input is removed.
+func (po *PipelineOrchestrator) completeSpooled(ctx context.Context, entry *SpoolEntry, data []byte, location string) {
+	result := &ProcessingResult{JobID: entry.JobID, Folder: entry.Folder}
+	var recorded *ProcessingResult
+	if po.history != nil && entry.JobID != "" {
+		var err error
+		if recorded, err = po.history.Get(entry.JobID); err != nil {
+			log.Printf("Warning: failed to read history of spooled job %s: %v", entry.JobID, err)
+		}
+		if recorded != nil {
+			result = recorded
+		}
+	}
+
+	result.StorageKey = entry.Key
+	result.S3URL = location
+	result.Spooled = false
+	result.SpooledKey = ""
+	result.StorageError = ""
+	if result.Folder != FolderCouldntUpscale {
+		// Only the storage write had failed
+		result.Status = "success"
+		result.ErrorMessage = ""
+	}
+	po.storageService.SignResultURL(ctx, result)
+	po.storeThumbnails(ctx, result, data)
+
+	if recorded != nil {
+		if err := po.history.Update(result); err != nil {
+			log.Printf("Warning: failed to update history of spooled job %s: %v", entry.JobID, err)
+		}
+	}
+	po.unstage(ctx, entry.StagingKey, result)
+}
+
+// storeOriginal writes the source of an upscaled image to the originals folder,
+// linked to the upscaled image that will be stored at objectKey. It returns the
+// metadata linking the upscaled image back, or nil if the original could not
+// be stored; losing the original does not fail the job.
+func (po *PipelineOrchestrator) storeOriginal(ctx context.Context, result *ProcessingResult, sourceKey string, data []byte, objectKey string) map[string]string {
+	defer result.Timings.track(StageUpload, time.Now())
+
+	originalKey := po.layout.Key(FolderOriginals, result.Tenant, result.ProcessedAt, sourceKey)
+	derivativeKey := po.layout.Key(FolderUpscaled, result.Tenant, result.ProcessedAt, objectKey)
+
+	metadata := pipelineMetadata(result)
+	delete(metadata, MetadataScale)
+	delete(metadata, MetadataModel)
+	metadata[MetadataDerivativeKey] = url.PathEscape(derivativeKey)
+
+	if _, err := po.uploadWithRetry(ctx, originalKey, data, metadata); err != nil {
+		log.Printf("Warning: failed to keep original of job %s: %v", result.JobID, err)
+		return nil
+	}
+	result.OriginalCopy = originalKey
+	return map[string]string{MetadataOriginalKey: url.PathEscape(originalKey)}
+}
+
+// uploadWithRetry uploads to a full bucket key, retrying transient failures with backoff
+func (po *PipelineOrchestrator) uploadWithRetry(ctx context.Context, key string, data []byte, metadata map[string]string) (string, error) {
+	var location string
+	_, err := retryWithBackoff(ctx, po.storageRetry, func(ctx context.Context) error {
+		var err error
+		location, err = po.storageService.UploadObject(ctx, key, data, metadata)
+		return classifyStorageError(err)
+	})
+	return location, err
+}
 
-	return upscaledData, nil
+// newJobID returns a random identifier for a pipeline run
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // ProcessImageBatch processes multiple images (useful for batch operations)
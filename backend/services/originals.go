@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ImageVersion is one stored version of a job's image
+type ImageVersion struct {
+	Key          string          `json:"key"`
+	URL          string          `json:"url"`
+	URLExpiresAt time.Time       `json:"url_expires_at"`
+	Metadata     *ObjectMetadata `json:"metadata"`
+}
+
+// ImageVersions pairs the original a job received with the image it derived
+type ImageVersions struct {
+	JobID      string        `json:"job_id"`
+	Folder     string        `json:"folder"`
+	Original   *ImageVersion `json:"original,omitempty"`   // nil if the source of an upscaled image was not kept
+	Derivative *ImageVersion `json:"derivative,omitempty"` // nil if the image was stored as received
+}
+
+// Versions returns the original and upscaled images of a job with pre-signed
+// download URLs. Images that were not upscaled are stored as received, so
+// they only have an original.
+func (po *PipelineOrchestrator) Versions(ctx context.Context, result *ProcessingResult) (*ImageVersions, error) {
+	if result.StorageKey == "" {
+		return nil, fmt.Errorf("job %q has no stored image", result.JobID)
+	}
+
+	versions := &ImageVersions{JobID: result.JobID, Folder: result.Folder}
+	originalKey := result.StorageKey
+	if result.Folder == FolderUpscaled {
+		derivative, err := po.imageVersion(ctx, result.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		versions.Derivative = derivative
+		originalKey = result.OriginalCopy
+	}
+
+	if originalKey != "" {
+		original, err := po.imageVersion(ctx, originalKey)
+		if err != nil {
+			return nil, err
+		}
+		versions.Original = original
+	}
+	return versions, nil
+}
+
+// imageVersion reads the metadata of a stored image and signs a download URL
+func (po *PipelineOrchestrator) imageVersion(ctx context.Context, key string) (*ImageVersion, error) {
+	metadata, err := po.storageService.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	presigned, err := po.storageService.PresignGet(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageVersion{
+		Key:          key,
+		URL:          presigned.URL,
+		URLExpiresAt: presigned.ExpiresAt,
+		Metadata:     metadata,
+	}, nil
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata keys recording what a direct upload is expected to contain
+const (
+	MetadataExpectedSize   = "expected-size"
+	MetadataExpectedSHA256 = "expected-sha256"
+)
+
+// ErrUploadMismatch is returned when an uploaded object does not match the
+// size or checksum announced for it
+var ErrUploadMismatch = errors.New("uploaded object does not match")
+
+// MaxDirectUploadSize bounds originals uploaded directly to storage, matching
+// the multipart upload limit
+const MaxDirectUploadSize = 1024 * 1024 * 1024
+
+// DirectUploadRequest describes an original a client is about to upload itself
+type DirectUploadRequest struct {
+	Filename string `json:"filename"`
+	Tenant   string `json:"tenant,omitempty"`
+	Size     int64  `json:"size"`             // expected bytes, at most MaxDirectUploadSize
+	SHA256   string `json:"sha256,omitempty"` // expected hex SHA-256; empty skips the check
+}
+
+// DirectUpload is a signed upload of an original straight to the processing
+// folder, bypassing the server
+type DirectUpload struct {
+	JobID  string        `json:"job_id"`
+	Key    string        `json:"key"` // bucket key to upload to, then to process
+	Upload *PresignedURL `json:"upload"`
+}
+
+// StoredImage is an image listed from a folder, with a pre-signed download URL
+type StoredImage struct {
+	Key          string    `json:"key"`
+	ObjectKey    string    `json:"object_key"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	URL          string    `json:"url,omitempty"`
+	URLExpiresAt time.Time `json:"url_expires_at,omitempty"`
+}
+
+// PresignUpload reserves a job ID and a key in the processing folder for an
+// original the client uploads itself with the returned URL. The URL is signed
+// by S3, or by the upload signer when one is set. The expected size and
+// checksum are signed into the upload and checked by ProcessStagedObject.
+func (po *PipelineOrchestrator) PresignUpload(ctx context.Context, req DirectUploadRequest) (*DirectUpload, error) {
+	filename := path.Base(req.Filename)
+	if filename == "." || filename == "/" {
+		return nil, fmt.Errorf("invalid filename %q", req.Filename)
+	}
+	if err := ValidateTenant(req.Tenant); err != nil {
+		return nil, err
+	}
+	if req.Size <= 0 || req.Size > MaxDirectUploadSize {
+		return nil, fmt.Errorf("size must be between 1 and %d bytes", MaxDirectUploadSize)
+	}
+	if req.SHA256 != "" {
+		if sum, err := hex.DecodeString(req.SHA256); err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("sha256 must be %d hex characters", 2*sha256.Size)
+		}
+	}
+
+	jobID := newJobID()
+	key := po.layout.Key(FolderProcessing, req.Tenant, time.Now(), jobID+"/"+filename)
+	metadata := map[string]string{
+		MetadataJobID:        jobID,
+		MetadataExpectedSize: strconv.FormatInt(req.Size, 10),
+	}
+	if req.SHA256 != "" {
+		metadata[MetadataExpectedSHA256] = strings.ToLower(req.SHA256)
+	}
+
+	var upload *PresignedURL
+	if po.uploadSigner != nil {
+		upload = po.uploadSigner.Sign(key, metadata)
+	} else {
+		contentType := mime.TypeByExtension(strings.ToLower(path.Ext(filename)))
+		var err error
+		if upload, err = po.storageService.PresignPut(ctx, key, contentType, metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DirectUpload{
+		JobID:  jobID,
+		Key:    key,
+		Upload: upload,
+	}, nil
+}
+
+// ProcessStagedObject runs the pipeline on an original already uploaded to the
+// processing folder. The job ID, tenant and filename come from the key unless
+// opts sets them; the uploaded object is removed like a staged copy once the
+// image is stored. Uploads that do not match the size or checksum announced
+// when they were signed fail with ErrUploadMismatch and are not processed.
+func (po *PipelineOrchestrator) ProcessStagedObject(ctx context.Context, key string, opts ProcessOptions) (*ProcessingResult, error) {
+	if err := ValidateTenant(opts.Tenant); err != nil {
+		return nil, err
+	}
+	parsed, ok := po.layout.Parse(key)
+	if !ok || parsed.Folder != FolderProcessing {
+		return nil, fmt.Errorf("key %q is not in the %s folder", key, FolderProcessing)
+	}
+	jobID, filename, ok := strings.Cut(parsed.ObjectKey, "/")
+	if !ok || filename == "" {
+		return nil, fmt.Errorf("key %q does not name a job upload", key)
+	}
+
+	// Check the size before fetching the whole object
+	head, err := po.storageService.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyUploadSize(head.ContentLength, head.Metadata); err != nil {
+		return nil, err
+	}
+	if head.ContentLength > MaxDirectUploadSize {
+		// Objects put in the folder without a signed size are still bounded
+		return nil, fmt.Errorf("%w: size is %d bytes, the limit is %d", ErrUploadMismatch, head.ContentLength, MaxDirectUploadSize)
+	}
+
+	data, err := po.storageService.DownloadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyUpload(data, head.Metadata); err != nil {
+		return nil, err
+	}
+
+	if opts.JobID == "" {
+		opts.JobID = jobID
+	}
+	if opts.Tenant == "" {
+		opts.Tenant = parsed.Tenant
+	}
+	opts.StagedKey = key
+	return po.ProcessImageWithOptions(ctx, data, filename, opts), nil
+}
+
+// verifyUploadSize compares an object's size with the expected size, if any
+func verifyUploadSize(size int64, metadata map[string]string) error {
+	expected, ok := metadata[MetadataExpectedSize]
+	if !ok {
+		return nil
+	}
+	if want, err := strconv.ParseInt(expected, 10, 64); err != nil || size != want {
+		return fmt.Errorf("%w: size is %d bytes, expected %s", ErrUploadMismatch, size, expected)
+	}
+	return nil
+}
+
+// verifyUpload checks uploaded data against the expected size and checksum
+func verifyUpload(data []byte, metadata map[string]string) error {
+	if err := verifyUploadSize(int64(len(data)), metadata); err != nil {
+		return err
+	}
+	if expected, ok := metadata[MetadataExpectedSHA256]; ok {
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); actual != expected {
+			return fmt.Errorf("%w: SHA-256 is %s, expected %s", ErrUploadMismatch, actual, expected)
+		}
+	}
+	return nil
+}
+
+// ListStored lists the images stored in a logical folder, optionally for a
+// single tenant, with pre-signed download URLs
+func (po *PipelineOrchestrator) ListStored(ctx context.Context, folder, tenant string) ([]StoredImage, error) {
+	objects, err := listFolders(ctx, po.storageService, po.layout, []string{folder})
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]StoredImage, 0, len(objects))
+	for _, obj := range objects {
+		parsed, ok := po.layout.Parse(obj.Key)
+		if !ok || parsed.Folder != folder || (tenant != "" && parsed.Tenant != tenant) {
+			continue
+		}
+
+		item := StoredImage{
+			Key:          obj.Key,
+			ObjectKey:    parsed.ObjectKey,
+			Tenant:       parsed.Tenant,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		}
+		if presigned, err := po.storageService.PresignGet(ctx, obj.Key); err == nil {
+			item.URL = presigned.URL
+			item.URLExpiresAt = presigned.ExpiresAt
+		}
+		images = append(images, item)
+	}
+	return images, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListStoredByTenant(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	layout, err := NewFolderLayout("{tenant}/{folder}/{key}", nil)
+	if err != nil {
+		t.Fatalf("NewFolderLayout: %v", err)
+	}
+	po.SetFolderLayout(layout, true)
+	ctx := context.Background()
+	for _, tenant := range []string{"acme", "globex", ""} {
+		if result := po.ProcessImageWithOptions(ctx, testPNG(t, 16, 16), "logo.png", ProcessOptions{Tenant: tenant}); result.Status != "success" {
+			t.Fatalf("result = %+v", result)
+		}
+	}
+
+	all, err := po.ListStored(ctx, FolderUpscaled, "")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListStored = %+v, %v; want 3 images", all, err)
+	}
+	acme, err := po.ListStored(ctx, FolderUpscaled, "acme")
+	if err != nil || len(acme) != 1 {
+		t.Fatalf("ListStored for acme = %+v, %v", acme, err)
+	}
+	image := acme[0]
+	if image.Key != "acme/upscaled/logo.png" || image.ObjectKey != "logo.png" || image.Tenant != "acme" || image.Size == 0 {
+		t.Errorf("listed image = %+v", image)
+	}
+
+	// Each image comes with a working download URL
+	if !strings.HasPrefix(image.URL, fake.URL+"/") || image.URLExpiresAt.IsZero() {
+		t.Fatalf("URL = %q expiring %v", image.URL, image.URLExpiresAt)
+	}
+	resp, err := http.Get(image.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("pre-signed GET returned %d", resp.StatusCode)
+	}
+}
+
+func TestPresignUploadValidation(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	for _, req := range []DirectUploadRequest{
+		{Filename: "", Size: 10},
+		{Filename: "/", Size: 10},
+		{Filename: "a.png"},
+		{Filename: "a.png", Size: MaxDirectUploadSize + 1},
+		{Filename: "a.png", Size: 10, SHA256: "abc"},
+		{Filename: "a.png", Size: 10, SHA256: strings.Repeat("zz", 32)},
+	} {
+		if _, err := po.PresignUpload(ctx, req); err == nil {
+			t.Errorf("PresignUpload(%+v) succeeded", req)
+		}
+	}
+
+	upload, err := po.PresignUpload(ctx, DirectUploadRequest{Filename: "../photos/cat.jpg", Tenant: "acme", Size: 10})
+	if err != nil {
+		t.Fatalf("PresignUpload: %v", err)
+	}
+	if upload.Key != FolderProcessing+"/"+upload.JobID+"/cat.jpg" || upload.Upload.Method != http.MethodPut {
+		t.Errorf("upload = %+v", upload)
+	}
+	if _, err := po.ProcessStagedObject(ctx, "upscaled/"+upload.JobID+"/cat.jpg", ProcessOptions{}); err == nil {
+		t.Error("ProcessStagedObject accepted a key outside the processing folder")
+	}
+}
@@ -2,10 +2,31 @@ package services
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"math"
+
+	_ "golang.org/x/image/webp"
+)
+
+// referenceResolution is the pixel count that scores 1.0 (4K UHD)
+const referenceResolution = 3840 * 2160
+
+// analysisMaxEdge bounds the long edge of the copy the sub-metrics are computed
+// on, keeping assessment fast for large images
+const analysisMaxEdge = 1024
+
+// Quality sub-metric names, as used in QualityAssessment.Metrics and routing rules
+const (
+	MetricQualityScore = "quality_score" // 0-1 resolution score
+	MetricSharpness    = "sharpness"     // Laplacian variance; low means blurry
+	MetricNoise        = "noise"         // estimated noise standard deviation, 0-255 levels
+	MetricContrast     = "contrast"      // luma standard deviation, 0-255 levels
+	MetricBrightness   = "brightness"    // mean luma, 0-255
+	MetricBlockiness   = "blockiness"    // luma steps at 8x8 block edges relative to elsewhere; above 1 means compression artifacts
 )
 
 // QualityAssessment holds the assessment result
@@ -14,11 +35,16 @@ type QualityAssessment struct {
 	Width        int
 	Height       int
 	Format       string
+	Metrics      map[string]float64 // sub-metrics by name, including the quality score
 }
 
+// ErrImageTooLarge is returned for images with more pixels than the decode limit
+var ErrImageTooLarge = errors.New("image exceeds the pixel limit")
+
 // QualityService handles image quality assessment
 type QualityService struct {
 	QualityThreshold float64 // Below this threshold, image needs upscaling
+	MaxPixels        int     // images with more pixels are refused before decoding, 0 for no limit
 }
 
 // NewQualityService creates a new quality assessment service
@@ -36,23 +62,45 @@ func NewQualityService(threshold float64) *QualityService {
 
 // AssessQuality evaluates image quality based on dimensions
 // Uses resolution as a proxy for quality (lower resolution = lower quality)
-// You can enhance this with more sophisticated metrics like sharpness, blur detection, etc.
+// Sharpness, noise, contrast and brightness are measured as sub-metrics
 func (qs *QualityService) AssessQuality(imageData []byte) (*QualityAssessment, error) {
-	img, format, err := image.DecodeConfig(bytes.NewReader(imageData))
+	img, format, err := decodeImage(imageData, qs.MaxPixels)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %w", err)
 	}
+	return qs.AssessImage(img, format), nil
+}
+
+// decodeImage decodes data once its header shows at most maxPixels pixels, so
+// a small file declaring huge dimensions is refused before the pixels are
+// allocated. Zero means no limit.
+func decodeImage(data []byte, maxPixels int) (image.Image, string, error) {
+	if maxPixels > 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+		if pixels := cfg.Width * cfg.Height; pixels > maxPixels {
+			return nil, "", fmt.Errorf("%w: %dx%d is over %d pixels", ErrImageTooLarge, cfg.Width, cfg.Height, maxPixels)
+		}
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// AssessImage is AssessQuality for an already decoded image
+func (qs *QualityService) AssessImage(img image.Image, format string) *QualityAssessment {
+	bounds := img.Bounds()
 
 	assessment := &QualityAssessment{
-		Width:  img.Width,
-		Height: img.Height,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
 		Format: format,
 	}
 
 	// Calculate quality score based on resolution
 	// Normalized to 0-1: assumes max good quality at 4K resolution
-	maxResolution := float64(3840 * 2160)
-	currentResolution := float64(img.Width * img.Height)
+	maxResolution := float64(referenceResolution)
+	currentResolution := float64(assessment.Width * assessment.Height)
 	assessment.QualityScore = currentResolution / maxResolution
 
 	// Cap at 1.0
@@ -60,7 +108,34 @@ func (qs *QualityService) AssessQuality(imageData []byte) (*QualityAssessment, e
 		assessment.QualityScore = 1.0
 	}
 
-	return assessment, nil
+	assessment.Metrics = measureImage(img)
+	assessment.Metrics[MetricQualityScore] = assessment.QualityScore
+
+	return assessment
+}
+
+// measureImage computes the pixel-based sub-metrics on a copy no larger than
+// analysisMaxEdge on its long edge. Blockiness needs the original block grid,
+// so it is measured on a full-resolution crop instead.
+func measureImage(img image.Image) map[string]float64 {
+	crop, cropWidth, cropHeight := luminance(blockAlignedCrop(img, analysisMaxEdge))
+	blocky := blockiness(crop, cropWidth, cropHeight)
+
+	bounds := img.Bounds()
+	if edge := max(bounds.Dx(), bounds.Dy()); edge > analysisMaxEdge {
+		ratio := float64(analysisMaxEdge) / float64(edge)
+		img = resizeTo(img, max(1, int(float64(bounds.Dx())*ratio)), max(1, int(float64(bounds.Dy())*ratio)))
+	}
+
+	gray, width, height := luminance(img)
+	brightness, contrast := meanStdDev(gray)
+	return map[string]float64{
+		MetricSharpness:  laplacianVariance(gray, width, height),
+		MetricNoise:      noiseSigma(gray, width, height),
+		MetricContrast:   contrast,
+		MetricBrightness: brightness,
+		MetricBlockiness: blocky,
+	}
 }
 
 // NeedsUpscaling returns true if image quality is below threshold
@@ -72,3 +147,8 @@ func (qs *QualityService) NeedsUpscaling(assessment *QualityAssessment) bool {
 func (qs *QualityService) IsGoodQuality(assessment *QualityAssessment) bool {
 	return assessment.QualityScore >= qs.QualityThreshold
 }
+
+// PixelsForScore returns the pixel count an image needs to reach the given quality score
+func (qs *QualityService) PixelsForScore(score float64) int {
+	return int(math.Ceil(score * referenceResolution))
+}
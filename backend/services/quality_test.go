@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"sync/atomic"
+	"testing"
+)
+
+// countedMagic prefixes PNG data to make the "counted" format, whose decoder
+// counts how often it runs
+const countedMagic = "COUNTED:"
+
+var countedDecodes atomic.Int64
+
+func init() {
+	image.RegisterFormat("counted", countedMagic, func(r io.Reader) (image.Image, error) {
+		countedDecodes.Add(1)
+		if _, err := io.CopyN(io.Discard, r, int64(len(countedMagic))); err != nil {
+			return nil, err
+		}
+		return png.Decode(r)
+	}, func(r io.Reader) (image.Config, error) {
+		if _, err := io.CopyN(io.Discard, r, int64(len(countedMagic))); err != nil {
+			return image.Config{}, err
+		}
+		return png.DecodeConfig(r)
+	})
+}
+
+func TestAssessImageMatchesAssessQuality(t *testing.T) {
+	qs := NewQualityService(0.5)
+	data := testPNG(t, 64, 48)
+	fromData, err := qs.AssessQuality(data)
+	if err != nil {
+		t.Fatalf("AssessQuality: %v", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	fromImage := qs.AssessImage(img, format)
+	if fromImage.Width != 64 || fromImage.Height != 48 || fromImage.Format != "png" || !maps.Equal(fromImage.Metrics, fromData.Metrics) {
+		t.Errorf("AssessImage = %+v, AssessQuality = %+v", fromImage, fromData)
+	}
+}
+
+func TestProcessDecodesOnce(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	data := append([]byte(countedMagic), testPNG(t, 64, 48)...)
+
+	before := countedDecodes.Load()
+	result := po.ProcessImageWithOptions(context.Background(), data, "counted.png", ProcessOptions{})
+	if result.Status != "success" || result.UpscaleScale != 2 || result.ContentClass == "" {
+		t.Fatalf("result = %+v", result)
+	}
+	// Assessment, classification and upscaling share one decoded copy
+	if n := countedDecodes.Load() - before; n != 1 {
+		t.Errorf("image was decoded %d times, want 1", n)
+	}
+}
+
+func TestOversizedImagesAreNotDecoded(t *testing.T) {
+	data := append([]byte(countedMagic), testPNG(t, 64, 48)...)
+	before := countedDecodes.Load()
+
+	qs := NewQualityService(0.5)
+	qs.MaxPixels = 64*48 - 1
+	if _, err := qs.AssessQuality(data); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("AssessQuality = %v, want ErrImageTooLarge", err)
+	}
+
+	po, _ := newTestPipeline(t, 0.5)
+	po.qualityService.MaxPixels = qs.MaxPixels
+	result := po.ProcessImageWithOptions(context.Background(), data, "bomb.png", ProcessOptions{})
+	if result.Folder != FolderCouldntUpscale || result.FailureReason != FailureReasonInput {
+		t.Errorf("result = %+v, want the image refused", result)
+	}
+	if n := countedDecodes.Load() - before; n != 0 {
+		t.Errorf("oversized image was decoded %d times", n)
+	}
+
+	// The limit is inclusive
+	qs.MaxPixels = 64 * 48
+	if _, err := qs.AssessQuality(data); err != nil {
+		t.Errorf("AssessQuality at the limit: %v", err)
+	}
+}
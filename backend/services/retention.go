@@ -0,0 +1,560 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Retention actions
+const (
+	RetentionDelete     = "delete"
+	RetentionTransition = "transition"
+)
+
+// RetentionPolicy limits how long objects stay in a logical folder. Versions
+// of the same object key (per tenant) are told apart by the date partitions of
+// the layout, or by job ID in the processing folder.
+type RetentionPolicy struct {
+	Folder              string `json:"folder"`
+	DeleteAfterDays     int    `json:"delete_after_days,omitempty"`     // 0 keeps objects forever
+	KeepLatest          int    `json:"keep_latest,omitempty"`           // newest versions kept per key; 0 keeps all
+	TransitionAfterDays int    `json:"transition_after_days,omitempty"` // move to StorageClass after this many days
+	StorageClass        string `json:"storage_class,omitempty"`         // e.g. STANDARD_IA, GLACIER_IR
+}
+
+// RetentionAction is a delete or storage class change decided by a policy.
+// Deleting an image also deletes the objects linked to it, and removes the
+// deleted keys from the history of the job that stored it.
+type RetentionAction struct {
+	Key          string            `json:"key"`
+	Folder       string            `json:"folder"`
+	Action       string            `json:"action"`
+	Reason       string            `json:"reason"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"last_modified"`
+	StorageClass string            `json:"storage_class,omitempty"` // target class of a transition
+	JobID        string            `json:"job_id,omitempty"`        // job that stored the object
+	Linked       []RetentionAction `json:"linked,omitempty"`        // kept original and thumbnails deleted along with it
+	Error        string            `json:"error,omitempty"`
+}
+
+// RetentionReport summarizes one enforcement run. In a dry run the actions are
+// only listed and the byte counts are what the run would have reclaimed.
+type RetentionReport struct {
+	DryRun            bool              `json:"dry_run"`
+	StartedAt         time.Time         `json:"started_at"`
+	FinishedAt        time.Time         `json:"finished_at"`
+	Scanned           int               `json:"scanned"`
+	Deleted           int               `json:"deleted"`
+	Transitioned      int               `json:"transitioned"`
+	Failed            int               `json:"failed"`
+	BytesReclaimed    int64             `json:"bytes_reclaimed"`
+	BytesTransitioned int64             `json:"bytes_transitioned"`
+	Actions           []RetentionAction `json:"actions,omitempty"`
+}
+
+// RetentionMetrics are the totals of the non-dry runs since the server started
+type RetentionMetrics struct {
+	Runs                int              `json:"runs"`
+	ObjectsDeleted      int              `json:"objects_deleted"`
+	ObjectsTransitioned int              `json:"objects_transitioned"`
+	BytesReclaimed      int64            `json:"bytes_reclaimed"`
+	BytesTransitioned   int64            `json:"bytes_transitioned"`
+	Failures            int              `json:"failures"`
+	LastRun             *RetentionReport `json:"last_run,omitempty"` // including dry runs, without actions
+}
+
+// retentionFile is the JSON layout of a retention policies file
+type retentionFile struct {
+	Policies []RetentionPolicy `json:"policies"`
+}
+
+// LoadRetentionPolicies reads policies from a JSON file ({"policies": [...]}),
+// or returns none if path is empty
+func LoadRetentionPolicies(path string) ([]RetentionPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policies: %w", err)
+	}
+
+	var file retentionFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse retention policies: %w", err)
+	}
+	return file.Policies, nil
+}
+
+// validate checks a policy for missing or contradictory settings, and that
+// its folder is one of the known folders
+func (p RetentionPolicy) validate(folders []string) error {
+	if p.Folder == "" {
+		return fmt.Errorf("folder is required")
+	}
+	if !slices.Contains(folders, p.Folder) {
+		return fmt.Errorf("unknown folder %q", p.Folder)
+	}
+	if p.DeleteAfterDays < 0 || p.KeepLatest < 0 || p.TransitionAfterDays < 0 {
+		return fmt.Errorf("days and counts must not be negative")
+	}
+	if p.DeleteAfterDays == 0 && p.KeepLatest == 0 && p.StorageClass == "" {
+		return fmt.Errorf("set delete_after_days, keep_latest or storage_class")
+	}
+	if p.StorageClass != "" {
+		if !slices.Contains(types.StorageClass("").Values(), types.StorageClass(p.StorageClass)) {
+			return fmt.Errorf("unknown storage class %q", p.StorageClass)
+		}
+		if p.DeleteAfterDays > 0 && p.TransitionAfterDays >= p.DeleteAfterDays {
+			return fmt.Errorf("transition_after_days must be less than delete_after_days")
+		}
+	}
+	return nil
+}
+
+// RetentionJanitor enforces retention policies on the bucket
+type RetentionJanitor struct {
+	orchestrator   *PipelineOrchestrator
+	storageService *StorageService
+	policies       []RetentionPolicy
+
+	mu      sync.Mutex
+	running bool
+	metrics RetentionMetrics
+}
+
+// NewRetentionJanitor validates the policies and creates a janitor. Each folder
+// may have at most one policy. The orchestrator's routing rules must already be
+// set, as their destination folders are the only custom folders allowed.
+func NewRetentionJanitor(orchestrator *PipelineOrchestrator, storageService *StorageService, policies []RetentionPolicy) (*RetentionJanitor, error) {
+	policies = slices.Clone(policies)
+	folders := retentionFolders(orchestrator)
+	seen := make(map[string]bool)
+	for i, policy := range policies {
+		policy.StorageClass = strings.ToUpper(policy.StorageClass)
+		if err := policy.validate(folders); err != nil {
+			return nil, fmt.Errorf("retention policy %d: %w", i+1, err)
+		}
+		if seen[policy.Folder] {
+			return nil, fmt.Errorf("more than one retention policy for folder %q", policy.Folder)
+		}
+		seen[policy.Folder] = true
+		policies[i] = policy
+	}
+
+	return &RetentionJanitor{
+		orchestrator:   orchestrator,
+		storageService: storageService,
+		policies:       policies,
+	}, nil
+}
+
+// retentionFolders returns the folders a policy may apply to: the built-in
+// folders and the destinations of folder routing rules
+func retentionFolders(orchestrator *PipelineOrchestrator) []string {
+	folders := []string{
+		FolderGoodQuality, FolderUpscaled, FolderCouldntUpscale,
+		FolderProcessing, FolderOriginals, FolderThumbnails,
+	}
+	if orchestrator == nil || orchestrator.router == nil {
+		return folders
+	}
+	for _, rule := range orchestrator.router.Rules() {
+		if rule.Action == RuleActionFolder && !slices.Contains(folders, rule.Folder) {
+			folders = append(folders, rule.Folder)
+		}
+	}
+	return folders
+}
+
+// Policies returns the configured policies
+func (rj *RetentionJanitor) Policies() []RetentionPolicy {
+	return rj.policies
+}
+
+// Metrics returns the running totals and the most recent report
+func (rj *RetentionJanitor) Metrics() RetentionMetrics {
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	return rj.metrics
+}
+
+// Run enforces the policies every interval until ctx is cancelled
+func (rj *RetentionJanitor) Run(ctx context.Context, interval time.Duration) {
+	if len(rj.policies) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := rj.Enforce(ctx, false)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Warning: retention run failed: %v", err)
+			}
+			if report != nil && (report.Deleted > 0 || report.Transitioned > 0) {
+				log.Printf("Retention deleted %d objects (%d bytes) and transitioned %d",
+					report.Deleted, report.BytesReclaimed, report.Transitioned)
+			}
+		}
+	}
+}
+
+// Enforce applies the policies once. With dryRun it only reports the actions
+// it would take.
+func (rj *RetentionJanitor) Enforce(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	rj.mu.Lock()
+	if rj.running {
+		rj.mu.Unlock()
+		return nil, fmt.Errorf("a retention run is already in progress")
+	}
+	rj.running = true
+	rj.mu.Unlock()
+
+	report := &RetentionReport{DryRun: dryRun, StartedAt: time.Now()}
+	err := rj.enforce(ctx, dryRun, report)
+	report.FinishedAt = time.Now()
+
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	rj.running = false
+	summary := *report
+	summary.Actions = nil
+	rj.metrics.LastRun = &summary
+	if !dryRun {
+		rj.metrics.Runs++
+		rj.metrics.ObjectsDeleted += report.Deleted
+		rj.metrics.ObjectsTransitioned += report.Transitioned
+		rj.metrics.BytesReclaimed += report.BytesReclaimed
+		rj.metrics.BytesTransitioned += report.BytesTransitioned
+		rj.metrics.Failures += report.Failed
+	}
+	return report, err
+}
+
+func (rj *RetentionJanitor) enforce(ctx context.Context, dryRun bool, report *RetentionReport) error {
+	if len(rj.policies) == 0 {
+		return nil
+	}
+
+	layout := rj.orchestrator.Layout()
+	policies := make(map[string]RetentionPolicy, len(rj.policies))
+	folders := make([]string, 0, len(rj.policies))
+	for _, policy := range rj.policies {
+		policies[policy.Folder] = policy
+		folders = append(folders, policy.Folder)
+	}
+
+	objects, err := listFolders(ctx, rj.storageService, layout, folders)
+	if err != nil {
+		return err
+	}
+
+	// Group the versions of each key, per folder and tenant
+	versions := make(map[string][]ObjectInfo)
+	var groups []string
+	for _, obj := range objects {
+		parsed, ok := layout.Parse(obj.Key)
+		if !ok || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		if _, ok := policies[parsed.Folder]; !ok {
+			continue
+		}
+		report.Scanned++
+		group := parsed.Folder + "\x00" + parsed.Tenant + "\x00" + retentionKey(parsed)
+		if _, ok := versions[group]; !ok {
+			groups = append(groups, group)
+		}
+		versions[group] = append(versions[group], obj)
+	}
+
+	now := time.Now()
+	for _, group := range groups {
+		folder, _, _ := strings.Cut(group, "\x00")
+		report.Actions = append(report.Actions, policies[folder].plan(folder, versions[group], now)...)
+	}
+	if err := rj.link(ctx, report.Actions); err != nil {
+		return err
+	}
+
+	for i := range report.Actions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		action := &report.Actions[i]
+		if action.Error != "" {
+			// The links of the object are unknown, so deleting it could orphan them
+			report.Failed++
+			continue
+		}
+		if !dryRun {
+			var err error
+			if action.Action == RetentionDelete {
+				err = rj.storageService.DeleteObject(ctx, action.Key)
+			} else {
+				err = rj.storageService.TransitionObject(ctx, action.Key, action.StorageClass)
+			}
+			if err != nil {
+				action.Error = err.Error()
+				report.Failed++
+				continue
+			}
+		}
+
+		if action.Action != RetentionDelete {
+			report.Transitioned++
+			report.BytesTransitioned += action.Size
+			continue
+		}
+		report.Deleted++
+		report.BytesReclaimed += action.Size
+
+		deleted := []string{action.Key}
+		for j := range action.Linked {
+			linked := &action.Linked[j]
+			if !dryRun {
+				if err := rj.storageService.DeleteObject(ctx, linked.Key); err != nil {
+					linked.Error = err.Error()
+					report.Failed++
+					continue
+				}
+			}
+			deleted = append(deleted, linked.Key)
+			report.Deleted++
+			report.BytesReclaimed += linked.Size
+		}
+		if !dryRun {
+			if err := rj.unlinkHistory(action.JobID, deleted); err != nil {
+				action.Error = fmt.Sprintf("deleted, but the job history still links to it: %v", err)
+				report.Failed++
+			}
+		}
+	}
+	return nil
+}
+
+// link finds the job that stored each object to delete and, for images, the
+// original kept next to it and its thumbnails. Linked objects that are planned
+// for deletion themselves are left to their own action.
+func (rj *RetentionJanitor) link(ctx context.Context, actions []RetentionAction) error {
+	planned := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		planned[action.Key] = true
+	}
+
+	var thumbnails map[string][]ObjectInfo
+	for i := range actions {
+		action := &actions[i]
+		if action.Action != RetentionDelete {
+			continue
+		}
+		head, err := rj.storageService.HeadObject(ctx, action.Key)
+		if err != nil {
+			action.Error = fmt.Sprintf("failed to read links: %v", err)
+			continue
+		}
+		action.JobID = head.JobID
+		if !imageFolder(action.Folder) {
+			continue
+		}
+
+		if head.OriginalKey != "" && !planned[head.OriginalKey] {
+			original, err := rj.storageService.HeadObject(ctx, head.OriginalKey)
+			var missing *types.NotFound
+			switch {
+			case err == nil:
+				action.Linked = append(action.Linked, RetentionAction{
+					Key:          head.OriginalKey,
+					Folder:       FolderOriginals,
+					Action:       RetentionDelete,
+					Reason:       "original of a deleted image",
+					Size:         original.ContentLength,
+					LastModified: original.LastModified,
+				})
+			case !errors.As(err, &missing):
+				action.Error = fmt.Sprintf("failed to read kept original: %v", err)
+				continue
+			}
+		}
+
+		if thumbnails == nil {
+			if thumbnails, err = rj.listThumbnails(ctx); err != nil {
+				return err
+			}
+		}
+		source, _ := rj.orchestrator.Layout().Parse(action.Key)
+		for _, thumbnail := range thumbnails[thumbnailGroup(source.Tenant, source.Date, source.Folder, source.ObjectKey)] {
+			if planned[thumbnail.Key] {
+				continue
+			}
+			// Images differing only in extension share thumbnail keys, so
+			// only delete the thumbnails last made from this image
+			meta, err := rj.storageService.HeadObject(ctx, thumbnail.Key)
+			if err != nil {
+				action.Error = fmt.Sprintf("failed to read thumbnail: %v", err)
+				break
+			}
+			if sourceKey, _ := url.PathUnescape(meta.Metadata[MetadataSourceKey]); sourceKey != action.Key {
+				continue
+			}
+			action.Linked = append(action.Linked, RetentionAction{
+				Key:          thumbnail.Key,
+				Folder:       FolderThumbnails,
+				Action:       RetentionDelete,
+				Reason:       "thumbnail of a deleted image",
+				Size:         thumbnail.Size,
+				LastModified: thumbnail.LastModified,
+			})
+		}
+	}
+	return nil
+}
+
+// listThumbnails lists the thumbnails folder, grouped by the image they preview
+func (rj *RetentionJanitor) listThumbnails(ctx context.Context) (map[string][]ObjectInfo, error) {
+	layout := rj.orchestrator.Layout()
+	objects, err := listFolders(ctx, rj.storageService, layout, []string{FolderThumbnails})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]ObjectInfo)
+	for _, obj := range objects {
+		parsed, ok := layout.Parse(obj.Key)
+		if !ok || parsed.Folder != FolderThumbnails {
+			continue
+		}
+		// Thumbnail object keys are "<size>/<folder>/<object key>"
+		parts := strings.SplitN(parsed.ObjectKey, "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		group := thumbnailGroup(parsed.Tenant, parsed.Date, parts[1], parts[2])
+		groups[group] = append(groups[group], obj)
+	}
+	return groups, nil
+}
+
+// thumbnailGroup identifies the thumbnails of an image, which keep its tenant,
+// date partitions, folder and object key but not its extension
+func thumbnailGroup(tenant string, date time.Time, folder, objectKey string) string {
+	name := strings.TrimSuffix(objectKey, path.Ext(objectKey))
+	return tenant + "\x00" + date.Format(time.DateOnly) + "\x00" + folder + "\x00" + name
+}
+
+// unlinkHistory removes deleted keys from the history of the job that stored
+// them, so the job no longer points at objects that are gone
+func (rj *RetentionJanitor) unlinkHistory(jobID string, deleted []string) error {
+	history := rj.orchestrator.history
+	if jobID == "" || history == nil {
+		return nil
+	}
+	result, err := history.Get(jobID)
+	if err != nil || result == nil {
+		return err
+	}
+
+	changed := false
+	if result.StorageKey != "" && slices.Contains(deleted, result.StorageKey) {
+		result.StorageKey = ""
+		result.S3URL = ""
+		result.URLExpiresAt = time.Time{}
+		result.DeletedAt = time.Now()
+		changed = true
+	}
+	if result.OriginalCopy != "" && slices.Contains(deleted, result.OriginalCopy) {
+		result.OriginalCopy = ""
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return history.Update(result)
+}
+
+// imageFolder reports whether folder holds the images jobs produce, as
+// opposed to the staged uploads, originals and thumbnails that go with them
+func imageFolder(folder string) bool {
+	return folder != FolderProcessing && folder != FolderOriginals && folder != FolderThumbnails
+}
+
+// retentionKey identifies the versions of an object within a folder. Staged
+// copies are keyed "<job ID>/<filename>", so the filename is what repeats.
+func retentionKey(parsed LayoutKey) string {
+	if parsed.Folder == FolderProcessing {
+		if _, filename, ok := strings.Cut(parsed.ObjectKey, "/"); ok {
+			return filename
+		}
+	}
+	return parsed.ObjectKey
+}
+
+// plan decides what happens to the versions of one key, newest first: versions
+// beyond KeepLatest and versions older than DeleteAfterDays are deleted, and
+// the rest move to StorageClass once older than TransitionAfterDays
+func (p RetentionPolicy) plan(folder string, versions []ObjectInfo, now time.Time) []RetentionAction {
+	slices.SortFunc(versions, func(a, b ObjectInfo) int {
+		return b.LastModified.Compare(a.LastModified)
+	})
+
+	var actions []RetentionAction
+	for i, obj := range versions {
+		age := now.Sub(obj.LastModified)
+		action := RetentionAction{
+			Key:          obj.Key,
+			Folder:       folder,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		}
+
+		switch {
+		case p.KeepLatest > 0 && i >= p.KeepLatest:
+			action.Action = RetentionDelete
+			action.Reason = fmt.Sprintf("older than the latest %d versions", p.KeepLatest)
+		case p.DeleteAfterDays > 0 && age >= days(p.DeleteAfterDays):
+			action.Action = RetentionDelete
+			action.Reason = fmt.Sprintf("older than %d days", p.DeleteAfterDays)
+		case p.StorageClass != "" && age >= days(p.TransitionAfterDays) && !sameStorageClass(obj.StorageClass, p.StorageClass):
+			action.Action = RetentionTransition
+			action.Reason = fmt.Sprintf("older than %d days", p.TransitionAfterDays)
+			action.StorageClass = p.StorageClass
+		default:
+			continue
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+// sameStorageClass compares classes as listed, where STANDARD may be empty
+func sameStorageClass(current, target string) bool {
+	if current == "" {
+		current = string(types.StorageClassStandard)
+	}
+	return current == target
+}
+
+// days converts a number of days to a duration
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
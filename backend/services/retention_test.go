@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyPlan(t *testing.T) {
+	now := time.Now()
+	version := func(key string, ageDays int, class string) ObjectInfo {
+		return ObjectInfo{Key: key, Size: 100, LastModified: now.Add(-days(ageDays)), StorageClass: class}
+	}
+	policy := RetentionPolicy{
+		Folder:              FolderCouldntUpscale,
+		KeepLatest:          2,
+		DeleteAfterDays:     30,
+		TransitionAfterDays: 7,
+		StorageClass:        "STANDARD_IA",
+	}
+
+	actions := policy.plan(FolderCouldntUpscale, []ObjectInfo{
+		version("v-old", 40, ""),
+		version("v-newest", 1, ""),
+		version("v-week", 10, ""),
+		version("v-third", 12, "STANDARD"),
+	}, now)
+
+	got := make(map[string]string)
+	for _, action := range actions {
+		got[action.Key] = action.Action
+	}
+	want := map[string]string{
+		"v-week":  RetentionTransition,
+		"v-third": RetentionDelete, // beyond the latest 2
+		"v-old":   RetentionDelete,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+	for key, action := range want {
+		if got[key] != action {
+			t.Errorf("%s: action %q, want %q", key, got[key], action)
+		}
+	}
+
+	// Objects already in the target class are left alone
+	if actions := policy.plan(FolderCouldntUpscale, []ObjectInfo{version("ia", 10, "STANDARD_IA")}, now); len(actions) != 0 {
+		t.Errorf("re-transitioned an object: %+v", actions)
+	}
+}
+
+func TestRetentionPolicyValidation(t *testing.T) {
+	invalid := [][]RetentionPolicy{
+		{{DeleteAfterDays: 3}},
+		{{Folder: FolderUpscaled}},
+		{{Folder: FolderUpscaled, StorageClass: "COLDEST"}},
+		{{Folder: FolderUpscaled, StorageClass: "GLACIER", TransitionAfterDays: 30, DeleteAfterDays: 10}},
+		{{Folder: FolderUpscaled, KeepLatest: 1}, {Folder: FolderUpscaled, DeleteAfterDays: 1}},
+		{{Folder: "upscaled_typo", DeleteAfterDays: 1}},
+	}
+	for _, policies := range invalid {
+		if _, err := NewRetentionJanitor(nil, nil, policies); err == nil {
+			t.Errorf("NewRetentionJanitor(%+v) accepted invalid policies", policies)
+		}
+	}
+}
+
+func TestRetentionJanitorAgainstS3(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	layout, _ := NewFolderLayout("{folder}/{yyyy}/{mm}/{dd}/{key}", nil)
+	po.SetFolderLayout(layout, false)
+
+	// Three versions of the same failed image on different days, and one other
+	image := testPNG(t, 8, 8)
+	for i, at := range []time.Time{
+		time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+	} {
+		key := layout.Key(FolderCouldntUpscale, "", at, "broken.png")
+		if _, err := po.Storage().UploadObject(ctx, key, image, nil); err != nil {
+			t.Fatalf("UploadObject: %v", err)
+		}
+		fake.age(key, time.Duration(3-i)*time.Hour)
+	}
+	otherKey := layout.Key(FolderCouldntUpscale, "", time.Now(), "other.png")
+	po.Storage().UploadObject(ctx, otherKey, image, nil)
+	upscaledKey := layout.Key(FolderUpscaled, "", time.Now(), "kept.png")
+	po.Storage().UploadObject(ctx, upscaledKey, image, nil)
+
+	janitor, err := NewRetentionJanitor(po, po.Storage(), []RetentionPolicy{
+		{Folder: FolderCouldntUpscale, KeepLatest: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewRetentionJanitor: %v", err)
+	}
+
+	before := fake.keys()
+	report, err := janitor.Enforce(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if report.Scanned != 4 || report.Deleted != 2 || report.BytesReclaimed != int64(2*len(image)) {
+		t.Errorf("dry run report = %+v", report)
+	}
+	if !slices.Equal(fake.keys(), before) {
+		t.Fatalf("dry run changed the bucket: %v", fake.keys())
+	}
+	if janitor.Metrics().BytesReclaimed != 0 {
+		t.Error("dry run counted towards the metrics")
+	}
+
+	if _, err := janitor.Enforce(ctx, false); err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	latest := layout.Key(FolderCouldntUpscale, "", time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), "broken.png")
+	want := []string{latest, otherKey, upscaledKey}
+	slices.Sort(want)
+	if got := fake.keys(); !slices.Equal(got, want) {
+		t.Errorf("bucket after retention = %v, want %v", got, want)
+	}
+	if metrics := janitor.Metrics(); metrics.Runs != 1 || metrics.ObjectsDeleted != 2 || metrics.BytesReclaimed != int64(2*len(image)) {
+		t.Errorf("metrics = %+v", metrics)
+	}
+}
+
+func TestRetentionDeletesLinkedObjects(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	history := newTestHistory(t)
+	po.SetHistoryStore(history)
+	if err := po.SetThumbnails(ThumbnailSettings{Sizes: []int{16}, Format: FormatWebP}); err != nil {
+		t.Fatalf("SetThumbnails: %v", err)
+	}
+
+	expired := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "old.png", ProcessOptions{})
+	kept := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "new.png", ProcessOptions{})
+	if expired.Folder != FolderUpscaled || expired.OriginalCopy == "" || kept.Folder != FolderUpscaled {
+		t.Fatalf("results = %+v, %+v", expired, kept)
+	}
+	fake.age(expired.StorageKey, 48*time.Hour)
+	thumbnail, _ := po.ThumbnailKey(expired.StorageKey, 16)
+	keptThumbnail, _ := po.ThumbnailKey(kept.StorageKey, 16)
+
+	janitor, err := NewRetentionJanitor(po, po.Storage(), []RetentionPolicy{
+		{Folder: FolderUpscaled, DeleteAfterDays: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewRetentionJanitor: %v", err)
+	}
+
+	// A dry run reports the linked objects and the job without touching them
+	report, err := janitor.Enforce(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(report.Actions) != 1 || report.Deleted != 3 {
+		t.Fatalf("dry run report = %+v", report)
+	}
+	action := report.Actions[0]
+	var linked []string
+	for _, l := range action.Linked {
+		linked = append(linked, l.Key)
+	}
+	slices.Sort(linked)
+	if action.JobID != expired.JobID || !slices.Equal(linked, []string{expired.OriginalCopy, thumbnail}) {
+		t.Errorf("dry run action = %+v", action)
+	}
+	if fake.object(expired.OriginalCopy) == nil || fake.object(thumbnail) == nil {
+		t.Fatal("dry run deleted linked objects")
+	}
+
+	if report, err = janitor.Enforce(ctx, false); err != nil || report.Failed != 0 {
+		t.Fatalf("Enforce = %+v, %v", report, err)
+	}
+	for _, key := range []string{expired.StorageKey, expired.OriginalCopy, thumbnail} {
+		if fake.object(key) != nil {
+			t.Errorf("%s was not deleted", key)
+		}
+	}
+	for _, key := range []string{kept.StorageKey, kept.OriginalCopy, keptThumbnail} {
+		if fake.object(key) == nil {
+			t.Errorf("%s of the newer image was deleted", key)
+		}
+	}
+
+	recorded, err := history.Get(expired.JobID)
+	if err != nil || recorded == nil {
+		t.Fatalf("history.Get = %+v, %v", recorded, err)
+	}
+	if recorded.StorageKey != "" || recorded.OriginalCopy != "" || recorded.S3URL != "" || recorded.DeletedAt.IsZero() {
+		t.Errorf("history still links to deleted objects: %+v", recorded)
+	}
+	if recorded, _ := history.Get(kept.JobID); recorded.StorageKey != kept.StorageKey || !recorded.DeletedAt.IsZero() {
+		t.Errorf("history of the newer image = %+v", recorded)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryFailureLimit bounds how many failed results a retry report keeps;
+// every result is still counted
+const retryFailureLimit = 100
+
+// RetryFailedOptions selects which images in the couldn't_upscale folder are retried
+type RetryFailedOptions struct {
+	Prefix      string `json:"prefix,omitempty"` // key prefix inside couldn't_upscale; empty means all
+	Reason      string `json:"reason,omitempty"` // failure reason, e.g. upscale_failed; empty means any
+	Concurrency int    `json:"concurrency,omitempty"`
+}
+
+// RetryFailedReport summarizes a retry run over the couldn't_upscale folder
+type RetryFailedReport struct {
+	Listed    int                 `json:"listed"`
+	Skipped   int                 `json:"skipped"` // did not match the prefix or reason filter
+	Retried   int                 `json:"retried"`
+	Recovered int                 `json:"recovered"` // moved out of couldn't_upscale
+	Failed    int                 `json:"failed"`
+	Failures  []*ProcessingResult `json:"failures,omitempty"` // the first retryFailureLimit images that failed again
+}
+
+// FailedImageRetrier re-runs the pipeline on images stored in the couldn't_upscale folder
+type FailedImageRetrier struct {
+	orchestrator   *PipelineOrchestrator
+	storageService *StorageService
+}
+
+// NewFailedImageRetrier creates a new retrier for failed images
+func NewFailedImageRetrier(orchestrator *PipelineOrchestrator, storageService *StorageService) *FailedImageRetrier {
+	return &FailedImageRetrier{
+		orchestrator:   orchestrator,
+		storageService: storageService,
+	}
+}
+
+// RetryFailed re-processes matching images from couldn't_upscale. Images that now
+// succeed are stored in their new folder and the stale copy is deleted; images that
+// fail again stay in couldn't_upscale with an incremented retry count.
+func (fr *FailedImageRetrier) RetryFailed(ctx context.Context, opts RetryFailedOptions) (*RetryFailedReport, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 2
+	}
+
+	layout := fr.orchestrator.Layout()
+	objects, err := fr.storageService.ListPrefix(ctx, layout.Prefix(FolderCouldntUpscale))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetryFailedReport{}
+	var candidates []retryCandidate
+	for _, obj := range objects {
+		parsed, ok := layout.Parse(obj.Key)
+		if !ok || parsed.Folder != FolderCouldntUpscale {
+			// Another folder sharing the listing prefix, e.g. with tenant-first layouts
+			continue
+		}
+		report.Listed++
+		if strings.HasSuffix(parsed.ObjectKey, "/") || !strings.HasPrefix(parsed.ObjectKey, opts.Prefix) {
+			report.Skipped++
+			continue
+		}
+		candidates = append(candidates, retryCandidate{key: obj.Key, parsed: parsed})
+	}
+
+	var mu sync.Mutex
+	forEachConcurrently(ctx, opts.Concurrency, len(candidates), func(i int) {
+		result, matched := fr.retryOne(ctx, candidates[i], opts.Reason)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !matched {
+			report.Skipped++
+			return
+		}
+		report.Retried++
+		if result.Folder != FolderCouldntUpscale && result.Status == "success" {
+			report.Recovered++
+			return
+		}
+		report.Failed++
+		if len(report.Failures) < retryFailureLimit {
+			report.Failures = append(report.Failures, result)
+		}
+	})
+
+	return report, ctx.Err()
+}
+
+// retryCandidate is a stored image in couldn't_upscale
+type retryCandidate struct {
+	key    string // full bucket key
+	parsed LayoutKey
+}
+
+// retryOne retries a single image, returning false if it does not match the reason filter
+func (fr *FailedImageRetrier) retryOne(ctx context.Context, candidate retryCandidate, reason string) (*ProcessingResult, bool) {
+	objectKey := candidate.parsed.ObjectKey
+	metadata, err := fr.storageService.GetObjectMetadata(ctx, candidate.key)
+	if err != nil {
+		return retryErrorResult(objectKey, fmt.Sprintf("Reading metadata failed: %v", err)), reason == ""
+	}
+	if reason != "" && metadata[MetadataFailureReason] != reason {
+		return nil, false
+	}
+
+	retryCount, _ := strconv.Atoi(metadata[MetadataRetryCount])
+
+	imageData, err := fr.storageService.DownloadObject(ctx, candidate.key)
+	if err != nil {
+		return retryErrorResult(objectKey, fmt.Sprintf("Download failed: %v", err)), true
+	}
+
+	result := fr.orchestrator.ProcessImageWithOptions(ctx, imageData, objectKey, ProcessOptions{
+		Tenant:     candidate.parsed.Tenant,
+		RetryCount: retryCount + 1,
+	})
+
+	// Only drop the stale copy once the image is safely stored somewhere else.
+	// With date partitions a repeated failure lands under a new key, so the old
+	// copy goes too.
+	recovered := result.Status == "success" && result.Folder != FolderCouldntUpscale
+	movedOnFailure := result.Folder == FolderCouldntUpscale && result.S3URL != "" && result.StorageKey != candidate.key
+	if recovered || movedOnFailure {
+		if err := fr.storageService.DeleteObject(ctx, candidate.key); err != nil {
+			if recovered {
+				result.ErrorMessage = fmt.Sprintf("Recovered but failed to delete stale copy: %v", err)
+			} else {
+				result.ErrorMessage += fmt.Sprintf("; failed to delete stale copy: %v", err)
+			}
+		}
+	}
+
+	return result, true
+}
+
+func retryErrorResult(objectKey, message string) *ProcessingResult {
+	return &ProcessingResult{
+		OriginalKey:  objectKey,
+		Status:       "error",
+		Folder:       FolderCouldntUpscale,
+		ErrorMessage: message,
+		ProcessedAt:  time.Now(),
+	}
+}
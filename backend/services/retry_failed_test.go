@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestRetryFailed(t *testing.T) {
+	po, fake := newTestPipeline(t, 0)
+	ctx := context.Background()
+	storage := newTestStorage(fake, testBucket)
+	stuck := map[string]struct {
+		data   []byte
+		reason string
+	}{
+		"fixed.png":  {testPNG(t, 32, 32), FailureReasonUpscale},
+		"broken.png": {[]byte("not a png"), FailureReasonAssessment},
+	}
+	for key, obj := range stuck {
+		if _, err := storage.UploadImageWithMetadata(ctx, FolderCouldntUpscale, key, obj.data, map[string]string{MetadataFailureReason: obj.reason}); err != nil {
+			t.Fatalf("UploadImageWithMetadata: %v", err)
+		}
+	}
+	retrier := NewFailedImageRetrier(po, storage)
+
+	// Only images that failed for the given reason are retried; with a zero
+	// threshold the retried image passes as good quality
+	report, err := retrier.RetryFailed(ctx, RetryFailedOptions{Reason: FailureReasonUpscale})
+	if err != nil {
+		t.Fatalf("RetryFailed: %v", err)
+	}
+	if report.Listed != 2 || report.Skipped != 1 || report.Retried != 1 || report.Recovered != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	want := []string{"couldn't_upscale/broken.png", "good_quality/fixed.png"}
+	if got := fake.keys(); !slices.Equal(got, want) {
+		t.Fatalf("bucket holds %v, want %v", got, want)
+	}
+
+	// An image that fails again stays put with its retry count raised
+	report, err = retrier.RetryFailed(ctx, RetryFailedOptions{})
+	if err != nil || report.Retried != 1 || report.Failed != 1 || report.Recovered != 0 {
+		t.Fatalf("report = %+v, %v", report, err)
+	}
+	if report.Failures[0].RetryCount != 1 {
+		t.Errorf("retry count = %d, want 1", report.Failures[0].RetryCount)
+	}
+	if obj := fake.object("couldn't_upscale/broken.png"); obj == nil || obj.metadata[MetadataRetryCount] != "1" {
+		t.Errorf("failed image = %+v, want it kept with retry count 1", obj)
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Routing rule actions
+const (
+	RuleActionAccept  = "accept"  // store unchanged in good_quality
+	RuleActionUpscale = "upscale" // upscale, with the rule's scale or the scale policy
+	RuleActionReject  = "reject"  // store nothing
+	RuleActionFolder  = "folder"  // store unchanged in the rule's folder
+)
+
+// Names of the built-in rules evaluated after the configured ones
+const (
+	RuleQualityThreshold = "quality_threshold"
+	RuleDefault          = "default"
+)
+
+// routingMetrics are the sub-metrics rules may test
+var routingMetrics = []string{MetricQualityScore, MetricSharpness, MetricNoise, MetricContrast, MetricBrightness, MetricBlockiness}
+
+// RoutingRule sends images matching its conditions to an action. Rules are
+// evaluated in order and the first match wins.
+type RoutingRule struct {
+	Name   string         `json:"name"`
+	When   RuleConditions `json:"when"`
+	Action string         `json:"action"`
+	Scale  int            `json:"scale,omitempty"`  // upscale: exact factor, 0 for the scale policy
+	Model  string         `json:"model,omitempty"`  // upscale: model to use instead of the job's
+	Folder string         `json:"folder,omitempty"` // folder: destination folder
+}
+
+// RuleConditions must all hold for a rule to match; unset conditions always
+// hold. Numeric bounds are inclusive.
+type RuleConditions struct {
+	MinWidth    int                    `json:"min_width,omitempty"`
+	MaxWidth    int                    `json:"max_width,omitempty"`
+	MinHeight   int                    `json:"min_height,omitempty"`
+	MaxHeight   int                    `json:"max_height,omitempty"`
+	Formats     []string               `json:"formats,omitempty"` // decoder names, e.g. "jpeg", "png"
+	MinFileSize int64                  `json:"min_file_size,omitempty"`
+	MaxFileSize int64                  `json:"max_file_size,omitempty"`
+	Tenants     []string               `json:"tenants,omitempty"`
+	Classes     []string               `json:"classes,omitempty"`  // content classes, e.g. "photo"
+	Filename    string                 `json:"filename,omitempty"` // case-insensitive glob on the base name
+	Metrics     map[string]MetricRange `json:"metrics,omitempty"`
+	EXIF        map[string]string      `json:"exif,omitempty"` // tag -> glob; "*" only requires the tag
+}
+
+// MetricRange bounds a quality sub-metric
+type MetricRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// RouteInput is what routing rules are evaluated against
+type RouteInput struct {
+	Assessment *QualityAssessment
+	FileSize   int64
+	Tenant     string
+	ObjectKey  string
+	Class      string
+	EXIF       map[string]string
+}
+
+// Router picks the action for each image from an ordered list of rules
+type Router struct {
+	rules []RoutingRule
+}
+
+// rulesFile is the JSON layout of a routing rules file
+type rulesFile struct {
+	Rules []RoutingRule `json:"rules"`
+}
+
+// LoadRoutingRules reads rules from a JSON file ({"rules": [...]}), or returns
+// none if path is empty
+func LoadRoutingRules(path string) ([]RoutingRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing rules: %w", err)
+	}
+
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse routing rules: %w", err)
+	}
+	return file.Rules, nil
+}
+
+// DefaultRoutingRules reproduces the threshold routing: images scoring at least
+// threshold are accepted and everything else is upscaled
+func DefaultRoutingRules(threshold float64) []RoutingRule {
+	return []RoutingRule{
+		{
+			Name:   RuleQualityThreshold,
+			When:   RuleConditions{Metrics: map[string]MetricRange{MetricQualityScore: {Min: &threshold}}},
+			Action: RuleActionAccept,
+		},
+		{
+			Name:   RuleDefault,
+			Action: RuleActionUpscale,
+		},
+	}
+}
+
+// NewRouter validates rules against the registered models and appends the
+// default threshold rules, so every image matches some rule
+func NewRouter(rules []RoutingRule, threshold float64, models *ModelRegistry) (*Router, error) {
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("routing rule %d has no name", i+1)
+		}
+		if seen[rule.Name] || rule.Name == RuleQualityThreshold || rule.Name == RuleDefault {
+			return nil, fmt.Errorf("duplicate routing rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		if err := rule.validate(models); err != nil {
+			return nil, fmt.Errorf("routing rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return &Router{
+		rules: append(slices.Clone(rules), DefaultRoutingRules(threshold)...),
+	}, nil
+}
+
+// Rules returns the rules in evaluation order, including the built-in ones
+func (r *Router) Rules() []RoutingRule {
+	return r.rules
+}
+
+// Route returns the first rule matching the input
+func (r *Router) Route(input RouteInput) RoutingRule {
+	for _, rule := range r.rules {
+		if rule.When.matches(input) {
+			return rule
+		}
+	}
+	// Unreachable: the default rule has no conditions
+	return r.rules[len(r.rules)-1]
+}
+
+// validate checks the action's parameters and the condition syntax. An exact
+// scale must be buildable by the rule's model, or by the first model of the
+// default chain when the rule names none.
+func (rule RoutingRule) validate(models *ModelRegistry) error {
+	switch rule.Action {
+	case RuleActionAccept, RuleActionReject:
+	case RuleActionUpscale:
+		if rule.Model != "" && !models.Has(rule.Model) {
+			return fmt.Errorf("unknown model %q", rule.Model)
+		}
+		if rule.Scale != 0 {
+			scales := models.Scales(rule.Model)
+			if len(scales) == 0 {
+				scales = supportedScales
+			}
+			if _, ok := passesForScale(rule.Scale, scales); !ok {
+				return fmt.Errorf("scale %d cannot be built from %v in at most %d passes", rule.Scale, scales, maxScalePasses)
+			}
+		}
+	case RuleActionFolder:
+		if rule.Folder == "" || rule.Folder == "." || rule.Folder == ".." || strings.ContainsAny(rule.Folder, "/\\") {
+			return fmt.Errorf("invalid folder %q", rule.Folder)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+
+	if rule.When.Filename != "" {
+		if _, err := path.Match(rule.When.Filename, ""); err != nil {
+			return fmt.Errorf("invalid filename pattern %q: %w", rule.When.Filename, err)
+		}
+	}
+	for tag, pattern := range rule.When.EXIF {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern for EXIF tag %s: %w", tag, err)
+		}
+	}
+	for _, class := range rule.When.Classes {
+		if !slices.Contains(ContentClasses, class) {
+			return fmt.Errorf("unknown content class %q, expected one of %v", class, ContentClasses)
+		}
+	}
+	for name := range rule.When.Metrics {
+		if !slices.Contains(routingMetrics, name) {
+			return fmt.Errorf("unknown metric %q, expected one of %v", name, routingMetrics)
+		}
+	}
+	return nil
+}
+
+// matches reports whether every set condition holds for the input
+func (c RuleConditions) matches(input RouteInput) bool {
+	a := input.Assessment
+	if !inRange(int64(a.Width), int64(c.MinWidth), int64(c.MaxWidth)) ||
+		!inRange(int64(a.Height), int64(c.MinHeight), int64(c.MaxHeight)) ||
+		!inRange(input.FileSize, c.MinFileSize, c.MaxFileSize) {
+		return false
+	}
+	if len(c.Formats) > 0 && !slices.ContainsFunc(c.Formats, func(f string) bool { return strings.EqualFold(f, a.Format) }) {
+		return false
+	}
+	if len(c.Tenants) > 0 && !slices.Contains(c.Tenants, input.Tenant) {
+		return false
+	}
+	if len(c.Classes) > 0 && !slices.Contains(c.Classes, input.Class) {
+		return false
+	}
+	if c.Filename != "" {
+		if ok, _ := path.Match(strings.ToLower(c.Filename), strings.ToLower(path.Base(input.ObjectKey))); !ok {
+			return false
+		}
+	}
+
+	for name, bounds := range c.Metrics {
+		value, ok := a.Metrics[name]
+		if !ok || (bounds.Min != nil && value < *bounds.Min) || (bounds.Max != nil && value > *bounds.Max) {
+			return false
+		}
+	}
+
+	for tag, pattern := range c.EXIF {
+		value, ok := input.EXIF[tag]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// inRange checks value against inclusive bounds, where zero means unbounded
+func inRange(value, lo, hi int64) bool {
+	return (lo == 0 || value >= lo) && (hi == 0 || value <= hi)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+func TestNewRouterValidatesRules(t *testing.T) {
+	tests := map[string]RoutingRule{
+		"no name":           {Action: RuleActionAccept},
+		"reserved name":     {Name: RuleDefault, Action: RuleActionAccept},
+		"unknown action":    {Name: "r", Action: "delete"},
+		"unbuildable scale": {Name: "r", Action: RuleActionUpscale, Scale: 7},
+		"unknown model":     {Name: "r", Action: RuleActionUpscale, Model: "missing"},
+		"scale of no model": {Name: "r", Action: RuleActionUpscale, Model: "x4", Scale: 2},
+		"default lacks it":  {Name: "r", Action: RuleActionUpscale, Scale: 3},
+		"nested folder":     {Name: "r", Action: RuleActionFolder, Folder: "a/b"},
+		"missing folder":    {Name: "r", Action: RuleActionFolder},
+		"bad filename glob": {Name: "r", Action: RuleActionAccept, When: RuleConditions{Filename: "[a"}},
+		"bad EXIF glob":     {Name: "r", Action: RuleActionAccept, When: RuleConditions{EXIF: map[string]string{"Make": "[a"}}},
+		"unknown class":     {Name: "r", Action: RuleActionAccept, When: RuleConditions{Classes: []string{"painting"}}},
+		"unknown metric":    {Name: "r", Action: RuleActionAccept, When: RuleConditions{Metrics: map[string]MetricRange{"warmth": {}}}},
+	}
+	models := testModelRegistry(t)
+	for name, rule := range tests {
+		if _, err := NewRouter([]RoutingRule{rule}, 0.5, models); err == nil {
+			t.Errorf("%s: NewRouter accepted %+v", name, rule)
+		}
+	}
+
+	duplicate := RoutingRule{Name: "r", Action: RuleActionAccept}
+	if _, err := NewRouter([]RoutingRule{duplicate, duplicate}, 0.5, models); err == nil {
+		t.Error("NewRouter accepted duplicate rule names")
+	}
+
+	// Scales are checked against the rule's own model
+	chained := RoutingRule{Name: "r", Action: RuleActionUpscale, Model: "x4", Scale: 16}
+	if _, err := NewRouter([]RoutingRule{chained}, 0.5, models); err != nil {
+		t.Errorf("NewRouter rejected %+v: %v", chained, err)
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	maxNoise := 5.0
+	router, err := NewRouter([]RoutingRule{
+		{Name: "tiny", When: RuleConditions{MaxWidth: 16, MaxHeight: 16}, Action: RuleActionReject},
+		{Name: "acme-gifs", When: RuleConditions{Tenants: []string{"acme"}, Formats: []string{"GIF"}}, Action: RuleActionAccept},
+		{Name: "scans", When: RuleConditions{Filename: "scan_*.png"}, Action: RuleActionFolder, Folder: "scans"},
+		{Name: "documents", When: RuleConditions{Classes: []string{ContentClasses[0]}, MinFileSize: 1000}, Action: RuleActionUpscale, Scale: 4},
+		{Name: "camera", When: RuleConditions{EXIF: map[string]string{"Make": "Canon*", "Model": "*"}}, Action: RuleActionFolder, Folder: "camera"},
+		{Name: "clean", When: RuleConditions{Metrics: map[string]MetricRange{MetricNoise: {Max: &maxNoise}}}, Action: RuleActionAccept},
+	}, 0.8, testModelRegistry(t))
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	assessment := func(width, height int, format string, score, noise float64) *QualityAssessment {
+		return &QualityAssessment{
+			Width: width, Height: height, Format: format, QualityScore: score,
+			Metrics: map[string]float64{MetricQualityScore: score, MetricNoise: noise},
+		}
+	}
+	tests := []struct {
+		name  string
+		input RouteInput
+		want  string
+	}{
+		{"small on both axes", RouteInput{Assessment: assessment(16, 10, "png", 0.1, 20)}, "tiny"},
+		{"small on one axis", RouteInput{Assessment: assessment(16, 400, "png", 0.1, 20)}, RuleDefault},
+		{"tenant and format", RouteInput{Assessment: assessment(400, 400, "gif", 0.1, 20), Tenant: "acme"}, "acme-gifs"},
+		{"other tenant", RouteInput{Assessment: assessment(400, 400, "gif", 0.1, 20), Tenant: "globex"}, RuleDefault},
+		{"filename glob", RouteInput{Assessment: assessment(400, 400, "png", 0.1, 20), ObjectKey: "uploads/SCAN_01.PNG"}, "scans"},
+		{"class and size", RouteInput{Assessment: assessment(400, 400, "png", 0.1, 20), Class: ContentClasses[0], FileSize: 5000}, "documents"},
+		{"class below size", RouteInput{Assessment: assessment(400, 400, "png", 0.1, 20), Class: ContentClasses[0], FileSize: 500}, RuleDefault},
+		{"EXIF tags", RouteInput{Assessment: assessment(400, 400, "jpeg", 0.1, 20), EXIF: map[string]string{"Make": "Canon", "Model": "EOS R5"}}, "camera"},
+		{"missing EXIF tag", RouteInput{Assessment: assessment(400, 400, "jpeg", 0.1, 20), EXIF: map[string]string{"Make": "Canon"}}, RuleDefault},
+		{"metric bound", RouteInput{Assessment: assessment(400, 400, "png", 0.1, 5)}, "clean"},
+		{"threshold", RouteInput{Assessment: assessment(400, 400, "png", 0.9, 20)}, RuleQualityThreshold},
+	}
+	for _, tt := range tests {
+		if got := router.Route(tt.input); got.Name != tt.want {
+			t.Errorf("%s: routed by %q, want %q", tt.name, got.Name, tt.want)
+		}
+	}
+}
+
+// tiffWithTags builds a little-endian TIFF structure holding ASCII IFD0 tags
+func tiffWithTags(tags map[uint16]string) []byte {
+	ids := make([]uint16, 0, len(tags))
+	for id := range maps.Keys(tags) {
+		ids = append(ids, id)
+	}
+	order := binary.LittleEndian
+	ifdEnd := 8 + 2 + len(ids)*12 + 4
+
+	tiff := []byte("II*\x00")
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, uint16(len(ids)))
+	var values []byte
+	for _, id := range ids {
+		value := append([]byte(tags[id]), 0)
+		tiff = order.AppendUint16(tiff, id)
+		tiff = order.AppendUint16(tiff, 2)
+		tiff = order.AppendUint32(tiff, uint32(len(value)))
+		if len(value) <= 4 {
+			tiff = append(tiff, append(value, make([]byte, 4-len(value))...)...)
+		} else {
+			tiff = order.AppendUint32(tiff, uint32(ifdEnd+len(values)))
+			values = append(values, value...)
+		}
+	}
+	tiff = order.AppendUint32(tiff, 0)
+	return append(tiff, values...)
+}
+
+func TestReadEXIF(t *testing.T) {
+	tiff := tiffWithTags(map[uint16]string{0x010F: "Canon", 0x0110: "EOS R5", 0x013B: "Al"})
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00}) // SOI, empty APP0
+	jpeg.Write([]byte{0xFF, 0xE1})
+	jpeg.Write(binary.BigEndian.AppendUint16(nil, uint16(2+6+len(tiff))))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff)
+	jpeg.Write([]byte{0xFF, 0xD9})
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	png = binary.BigEndian.AppendUint32(png, uint32(len(tiff)))
+	png = append(png, "eXIf"...)
+	png = append(png, tiff...)
+	png = append(png, 0, 0, 0, 0) // CRC, not checked
+
+	want := map[string]string{"Make": "Canon", "Model": "EOS R5", "Artist": "Al"}
+	for name, data := range map[string][]byte{"jpeg": jpeg.Bytes(), "png": png} {
+		if got := ReadEXIF(data); !maps.Equal(got, want) {
+			t.Errorf("%s: ReadEXIF = %v, want %v", name, got, want)
+		}
+	}
+
+	if got := ReadEXIF(testPNG(t, 8, 8)); len(got) != 0 {
+		t.Errorf("ReadEXIF of an image without EXIF = %v", got)
+	}
+	if got := ReadEXIF(append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}, "Exif"...)); len(got) != 0 {
+		t.Errorf("ReadEXIF of a truncated segment = %v", got)
+	}
+}
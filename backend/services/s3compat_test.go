@@ -0,0 +1,388 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+const testBucket = "visioncloud-test"
+
+// newTestPipeline runs an orchestrator against a fake S3 endpoint. Upscaling
+// uses the built-in bicubic model so no Python environment is needed.
+func newTestPipeline(t *testing.T, threshold float64) (*PipelineOrchestrator, *fakeS3) {
+	t.Helper()
+	fake := newFakeS3(t, testBucket)
+	po := NewPipelineOrchestrator(NewQualityService(threshold), newTestStorage(fake, testBucket), "upscale.py", 2)
+	if err := po.Models().SetDefaultChain([]string{StrategyBicubic}); err != nil {
+		t.Fatalf("SetDefaultChain: %v", err)
+	}
+	po.SetFolderLayout(DefaultFolderLayout(), true)
+	return po, fake
+}
+
+// newTestStorage returns a storage service for a bucket on the fake endpoint
+func newTestStorage(fake *fakeS3, bucket string) *StorageService {
+	awsCfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+	}
+	return NewStorageServiceWithOptions(awsCfg, bucket, S3Options{
+		Endpoint:     fake.URL,
+		UsePathStyle: true,
+		DisableTLS:   true,
+	})
+}
+
+// testPNG returns a small textured PNG
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x ^ y) * 8), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestS3CompatiblePipelineUpscales(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 64, 48), "small photo.png", ProcessOptions{})
+	if result.Status != "success" || result.Folder != FolderUpscaled {
+		t.Fatalf("status %q folder %q: %s", result.Status, result.Folder, result.ErrorMessage)
+	}
+	if result.StorageKey != "upscaled/small photo.png" {
+		t.Errorf("StorageKey = %q", result.StorageKey)
+	}
+
+	obj := fake.object(result.StorageKey)
+	if obj == nil {
+		t.Fatalf("no object stored; bucket has %v", fake.keys())
+	}
+	if obj.contentType != "image/png" || obj.cacheControl != DefaultCacheControl {
+		t.Errorf("stored with Content-Type %q, Cache-Control %q", obj.contentType, obj.cacheControl)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(obj.data))
+	if err != nil || cfg.Width != 128 || cfg.Height != 96 {
+		t.Errorf("stored image is %dx%d (%v), want 128x96", cfg.Width, cfg.Height, err)
+	}
+
+	// The staged copy is written while processing and removed afterwards; the
+	// source is kept in the originals folder
+	want := []string{"originals/small photo.png", "upscaled/small photo.png"}
+	if got := fake.keys(); !slices.Equal(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+	staged := false
+	for _, request := range fake.requests {
+		if strings.HasPrefix(request, "PUT /"+testBucket+"/"+FolderProcessing+"/") {
+			staged = true
+		}
+	}
+	if !staged {
+		t.Errorf("image was not staged; requests: %v", fake.requests)
+	}
+
+	head, err := po.Storage().HeadObject(ctx, result.StorageKey)
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if head.JobID != result.JobID || head.OriginalFilename != "small photo.png" || head.Scale != "2" || head.Model != StrategyBicubic {
+		t.Errorf("HeadObject = %+v", head)
+	}
+
+	// The pre-signed URL is path-style on the custom endpoint
+	if !strings.HasPrefix(result.S3URL, fake.URL+"/"+testBucket+"/upscaled/") {
+		t.Fatalf("S3URL = %q", result.S3URL)
+	}
+	resp, err := http.Get(result.S3URL)
+	if err != nil {
+		t.Fatalf("GET pre-signed URL: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, obj.data) {
+		t.Errorf("pre-signed GET returned %d with %d bytes", resp.StatusCode, len(body))
+	}
+
+	stored, err := po.ListStored(ctx, FolderUpscaled, "")
+	if err != nil || len(stored) != 1 || stored[0].Key != result.StorageKey {
+		t.Errorf("ListStored = %+v, %v", stored, err)
+	}
+}
+
+func TestS3CompatibleOriginalsAreLinked(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	original := testPNG(t, 40, 30)
+
+	result := po.ProcessImageWithOptions(ctx, original, "linked.png", ProcessOptions{OutputFormat: FormatJPEG})
+	if result.Status != "success" || result.OriginalCopy != "originals/linked.png" || result.StorageKey != "upscaled/linked.jpg" {
+		t.Fatalf("result = %+v", result)
+	}
+	if obj := fake.object(result.OriginalCopy); obj == nil || !bytes.Equal(obj.data, original) {
+		t.Fatal("original was not kept unchanged")
+	}
+
+	versions, err := po.Versions(ctx, result)
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	if versions.Original == nil || versions.Derivative == nil {
+		t.Fatalf("versions = %+v", versions)
+	}
+	if versions.Original.Metadata.DerivativeKey != result.StorageKey || versions.Derivative.Metadata.OriginalKey != result.OriginalCopy {
+		t.Errorf("original links to %q, derivative links to %q",
+			versions.Original.Metadata.DerivativeKey, versions.Derivative.Metadata.OriginalKey)
+	}
+	if versions.Original.Metadata.Scale != "" || versions.Original.Metadata.ContentType != "image/png" {
+		t.Errorf("original metadata = %+v", versions.Original.Metadata)
+	}
+
+	// With originals disabled only the upscaled image is stored
+	po.SetKeepOriginals(false)
+	kept := po.ProcessImageWithOptions(ctx, testPNG(t, 20, 20), "plain.png", ProcessOptions{})
+	if kept.OriginalCopy != "" || fake.object("originals/plain.png") != nil {
+		t.Errorf("original kept although disabled: %+v", kept)
+	}
+}
+
+func TestS3CompatiblePipelineKeepsGoodQuality(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.0001)
+	original := testPNG(t, 64, 48)
+
+	result := po.ProcessImageWithOptions(context.Background(), original, "sharp.png", ProcessOptions{Tenant: "acme"})
+	if result.Status != "success" || result.Folder != FolderGoodQuality {
+		t.Fatalf("status %q folder %q: %s", result.Status, result.Folder, result.ErrorMessage)
+	}
+
+	obj := fake.object(result.StorageKey)
+	if obj == nil || !bytes.Equal(obj.data, original) {
+		t.Fatalf("original not stored unchanged at %q; bucket has %v", result.StorageKey, fake.keys())
+	}
+	if obj.metadata[MetadataJobID] != result.JobID {
+		t.Errorf("job-id metadata = %q, want %q", obj.metadata[MetadataJobID], result.JobID)
+	}
+
+	versions, err := po.Versions(context.Background(), result)
+	if err != nil || versions.Original == nil || versions.Original.Key != result.StorageKey || versions.Derivative != nil {
+		t.Errorf("Versions = %+v, %v", versions, err)
+	}
+}
+
+func TestS3CompatibleObjectMetadata(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.0001)
+	ctx := context.Background()
+	po.Storage().SetCacheControl("")
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "photos/café menu.png", ProcessOptions{})
+	if result.Status != "success" || result.Folder != FolderGoodQuality {
+		t.Fatalf("result = %+v", result)
+	}
+
+	// Metadata values must be ASCII, so the filename is escaped when written
+	obj := fake.object(result.StorageKey)
+	if obj == nil || obj.metadata[MetadataOriginalFilename] != "caf%C3%A9%20menu.png" || obj.cacheControl != "" {
+		t.Fatalf("stored object = %+v", obj)
+	}
+
+	head, err := po.Storage().HeadObject(ctx, result.StorageKey)
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if head.OriginalFilename != "café menu.png" || head.PipelineVersion != PipelineVersion || head.QualityScore == "" {
+		t.Errorf("HeadObject = %+v", head)
+	}
+	if head.ContentType != "image/png" || head.ContentLength != int64(len(obj.data)) || head.Metadata[MetadataContentClass] != result.ContentClass {
+		t.Errorf("HeadObject = %+v", head)
+	}
+	// Only upscaled images record the scale and model
+	if head.Scale != "" || head.Model != "" {
+		t.Errorf("good-quality image has scale %q and model %q", head.Scale, head.Model)
+	}
+}
+
+func TestS3CompatibleDirectUpload(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	original := testPNG(t, 40, 30)
+	sum := sha256.Sum256(original)
+
+	upload, err := po.PresignUpload(ctx, DirectUploadRequest{
+		Filename: "direct.png",
+		Size:     int64(len(original)),
+		SHA256:   hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatalf("PresignUpload: %v", err)
+	}
+	if !strings.HasPrefix(upload.Upload.URL, fake.URL+"/"+testBucket+"/"+FolderProcessing+"/") {
+		t.Fatalf("upload URL = %q", upload.Upload.URL)
+	}
+
+	put := func(data []byte) {
+		req, _ := http.NewRequest(upload.Upload.Method, upload.Upload.URL, bytes.NewReader(data))
+		for name, value := range upload.Upload.Headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT to pre-signed URL: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT to pre-signed URL returned %d", resp.StatusCode)
+		}
+	}
+
+	// A different body is rejected and left in place
+	tampered := bytes.Clone(original)
+	tampered[len(tampered)-1] ^= 0xff
+	put(tampered)
+	if _, err := po.ProcessStagedObject(ctx, upload.Key, ProcessOptions{}); !errors.Is(err, ErrUploadMismatch) {
+		t.Fatalf("tampered upload: err = %v, want ErrUploadMismatch", err)
+	}
+	if fake.object(upload.Key) == nil {
+		t.Fatal("rejected upload was deleted")
+	}
+
+	put(original)
+	result, err := po.ProcessStagedObject(ctx, upload.Key, ProcessOptions{})
+	if err != nil {
+		t.Fatalf("ProcessStagedObject: %v", err)
+	}
+	if result.Status != "success" || result.JobID != upload.JobID || result.OriginalKey != "direct.png" {
+		t.Errorf("result = %+v", result)
+	}
+	if fake.object(upload.Key) != nil {
+		t.Error("uploaded original was not removed after processing")
+	}
+	if fake.object(result.StorageKey) == nil {
+		t.Errorf("no image at %q; bucket has %v", result.StorageKey, fake.keys())
+	}
+}
+
+func TestS3CompatibleWriteSettings(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	storage := po.Storage()
+
+	folderTags, err := ParseFolderTags(map[string]string{
+		AllFolders:        "classification=internal",
+		FolderUpscaled:    "status=upscaled",
+		FolderGoodQuality: "quality=good&status=original",
+	})
+	if err != nil {
+		t.Fatalf("ParseFolderTags: %v", err)
+	}
+	err = storage.SetWriteSettings(WriteSettings{
+		Encryption:   "SSE-KMS",
+		KMSKeyID:     "alias/visioncloud",
+		StorageClass: "standard_ia",
+		FolderTags:   folderTags,
+	})
+	if err != nil {
+		t.Fatalf("SetWriteSettings: %v", err)
+	}
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "tagged.png", ProcessOptions{})
+	if result.Status != "success" {
+		t.Fatalf("status %q: %s", result.Status, result.ErrorMessage)
+	}
+
+	head, err := storage.HeadObject(ctx, result.StorageKey)
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if head.Encryption != "aws:kms" || head.KMSKeyID != "alias/visioncloud" || head.StorageClass != "STANDARD_IA" {
+		t.Errorf("HeadObject reports encryption %q key %q class %q", head.Encryption, head.KMSKeyID, head.StorageClass)
+	}
+	tags, err := storage.ObjectTags(ctx, result.StorageKey)
+	if err != nil {
+		t.Fatalf("ObjectTags: %v", err)
+	}
+	want := map[string]string{"classification": "internal", "status": "upscaled"}
+	if !maps.Equal(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+
+	// A copy into another folder is re-encrypted and tagged for that folder
+	copyKey := FolderGoodQuality + "/tagged.png"
+	if err := storage.CopyObject(ctx, result.StorageKey, copyKey); err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	if obj := fake.object(copyKey); obj.encryption != "aws:kms" || obj.tags.Get("quality") != "good" || obj.tags.Get("status") != "original" {
+		t.Errorf("copy has encryption %q and tags %v", obj.encryption, obj.tags)
+	}
+
+	// Pre-signed uploads must send the same settings
+	upload, err := po.PresignUpload(ctx, DirectUploadRequest{Filename: "direct.png", Size: 1024})
+	if err != nil {
+		t.Fatalf("PresignUpload: %v", err)
+	}
+	if upload.Upload.Headers["X-Amz-Server-Side-Encryption"] != "aws:kms" || upload.Upload.Headers["X-Amz-Tagging"] != "classification=internal" {
+		t.Errorf("upload headers = %v", upload.Upload.Headers)
+	}
+}
+
+func TestWriteSettingsValidation(t *testing.T) {
+	storage := NewStorageService(aws.Config{Region: "us-east-1"}, testBucket)
+	tooMany := make(map[string]string)
+	for i := range maxObjectTags + 1 {
+		tooMany[fmt.Sprintf("tag%d", i)] = "x"
+	}
+
+	invalid := []WriteSettings{
+		{Encryption: "sse-c"},
+		{Encryption: "sse-s3", KMSKeyID: "alias/key"},
+		{StorageClass: "COLD"},
+		{FolderTags: map[string]map[string]string{FolderUpscaled: tooMany}},
+	}
+	for _, settings := range invalid {
+		if err := storage.SetWriteSettings(settings); err == nil {
+			t.Errorf("SetWriteSettings(%+v) accepted invalid settings", settings)
+		}
+	}
+	if err := storage.SetWriteSettings(WriteSettings{Encryption: "AES256", StorageClass: "GLACIER_IR"}); err != nil {
+		t.Errorf("SetWriteSettings: %v", err)
+	}
+}
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint   string
+		disableTLS bool
+		want       string
+	}{
+		{"localhost:9000", true, "http://localhost:9000"},
+		{"minio.internal:9000", false, "https://minio.internal:9000"},
+		{"http://localstack:4566", false, "http://localstack:4566"},
+	}
+	for _, tt := range tests {
+		if got := endpointURL(tt.endpoint, tt.disableTLS); got != tt.want {
+			t.Errorf("endpointURL(%q, %v) = %q, want %q", tt.endpoint, tt.disableTLS, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// supportedScales are the factors a single upscaling pass can apply when the
+// model does not list its own
+var supportedScales = []int{2, 3, 4}
+
+// errOutputTooLarge marks plans refused because of the output pixel cap
+var errOutputTooLarge = errors.New("output too large")
+
+// maxScalePasses bounds how many passes may be chained to reach a target
+const maxScalePasses = 3
+
+// ScalePolicy controls how the upscale factor is chosen for each image.
+// With no target configured every image gets the fixed default scale.
+type ScalePolicy struct {
+	TargetPixels    int // desired output pixel count, 0 for none
+	MaxOutputPixels int // hard cap on output pixel count, 0 for none
+}
+
+// ScalePlan is the chosen sequence of upscaling passes for an image
+type ScalePlan struct {
+	Passes []int  // factor of each pass, applied in order
+	Scale  int    // product of all passes
+	Reason string // human-readable explanation of the choice
+}
+
+// scaleCandidate is one way of combining passes
+type scaleCandidate struct {
+	passes []int
+	total  int
+}
+
+// scaleCandidates lists every combination of up to maxScalePasses of the given
+// pass scales, ordered by total factor and then by number of passes
+func scaleCandidates(scales []int) []scaleCandidate {
+	scales = slices.Compact(slices.Sorted(slices.Values(scales)))
+	var candidates []scaleCandidate
+	var build func(passes []int, total int)
+	build = func(passes []int, total int) {
+		if len(passes) > 0 {
+			candidates = append(candidates, scaleCandidate{passes: append([]int(nil), passes...), total: total})
+		}
+		if len(passes) == maxScalePasses {
+			return
+		}
+		for _, s := range scales {
+			if s < 2 {
+				continue
+			}
+			// Non-increasing order avoids listing permutations of the same passes
+			if len(passes) > 0 && s > passes[len(passes)-1] {
+				continue
+			}
+			build(append(passes, s), total*s)
+		}
+	}
+	build(nil, 1)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].total != candidates[j].total {
+			return candidates[i].total < candidates[j].total
+		}
+		return len(candidates[i].passes) < len(candidates[j].passes)
+	})
+	return candidates
+}
+
+// PlanScale chooses the passes needed to bring a width x height image to the
+// target pixel count without exceeding the output cap. defaultScale is used
+// when no target is configured. Passes are built from scales, the factors the
+// model supports; nil means supportedScales.
+func (sp ScalePolicy) PlanScale(width, height, defaultScale int, scales []int) (*ScalePlan, error) {
+	pixels := width * height
+	if pixels <= 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+	if len(scales) == 0 {
+		scales = supportedScales
+	}
+
+	fits := func(total int) bool {
+		return sp.MaxOutputPixels <= 0 || pixels*total*total <= sp.MaxOutputPixels
+	}
+
+	if sp.TargetPixels <= 0 {
+		if !fits(defaultScale) {
+			return nil, fmt.Errorf("%w: %dx upscale of %dx%d would exceed the %d pixel output cap", errOutputTooLarge, defaultScale, width, height, sp.MaxOutputPixels)
+		}
+		if passes, ok := passesForScale(defaultScale, scales); ok {
+			return &ScalePlan{
+				Passes: passes,
+				Scale:  defaultScale,
+				Reason: fmt.Sprintf("fixed %dx scale in %d pass(es)", defaultScale, len(passes)),
+			}, nil
+		}
+
+		// The model cannot produce the default; use the nearest larger factor
+		for _, candidate := range scaleCandidates(scales) {
+			if candidate.total >= defaultScale && fits(candidate.total) {
+				return &ScalePlan{
+					Passes: candidate.passes,
+					Scale:  candidate.total,
+					Reason: fmt.Sprintf("fixed %dx scale cannot be built from %v; nearest is %dx in %d pass(es)", defaultScale, scales, candidate.total, len(candidate.passes)),
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("%dx cannot be built from %v in at most %d passes within the output cap", defaultScale, scales, maxScalePasses)
+	}
+
+	needed := math.Sqrt(float64(sp.TargetPixels) / float64(pixels))
+
+	var largest *scaleCandidate
+	for _, candidate := range scaleCandidates(scales) {
+		if !fits(candidate.total) {
+			continue
+		}
+		if float64(candidate.total) >= needed {
+			return &ScalePlan{
+				Passes: candidate.passes,
+				Scale:  candidate.total,
+				Reason: fmt.Sprintf("%dx%d needs %.2fx to reach %d pixels; smallest available is %dx in %d pass(es)",
+					width, height, needed, sp.TargetPixels, candidate.total, len(candidate.passes)),
+			}, nil
+		}
+		// Candidates come in order, so on a tie the fewest passes are kept
+		if largest == nil || candidate.total > largest.total {
+			c := candidate
+			largest = &c
+		}
+	}
+
+	if largest == nil {
+		return nil, fmt.Errorf("%w: any upscale of %dx%d would exceed the %d pixel output cap", errOutputTooLarge, width, height, sp.MaxOutputPixels)
+	}
+
+	limit := fmt.Sprintf("the %d pixel output limit", sp.MaxOutputPixels)
+	if sp.MaxOutputPixels <= 0 {
+		limit = fmt.Sprintf("the %d pass limit", maxScalePasses)
+	}
+	return &ScalePlan{
+		Passes: largest.passes,
+		Scale:  largest.total,
+		Reason: fmt.Sprintf("%dx%d needs %.2fx to reach %d pixels; capped at %dx by %s",
+			width, height, needed, sp.TargetPixels, largest.total, limit),
+	}, nil
+}
+
+// PlanFixedScale plans an exact total factor, chaining passes of the given
+// scales (nil means supportedScales) if no single pass provides it, without
+// exceeding the output cap
+func (sp ScalePolicy) PlanFixedScale(width, height, scale int, scales []int) (*ScalePlan, error) {
+	if len(scales) == 0 {
+		scales = supportedScales
+	}
+	passes, ok := passesForScale(scale, scales)
+	if !ok {
+		return nil, fmt.Errorf("%dx cannot be built from %v in at most %d passes", scale, scales, maxScalePasses)
+	}
+	if pixels := width * height; sp.MaxOutputPixels > 0 && pixels*scale*scale > sp.MaxOutputPixels {
+		return nil, fmt.Errorf("%w: %dx upscale of %dx%d would exceed the %d pixel output cap", errOutputTooLarge, scale, width, height, sp.MaxOutputPixels)
+	}
+
+	return &ScalePlan{
+		Passes: passes,
+		Scale:  scale,
+		Reason: fmt.Sprintf("fixed %dx scale in %d pass(es)", scale, len(passes)),
+	}, nil
+}
+
+// passesForScale returns the fewest passes of the given scales whose factors
+// multiply to scale
+func passesForScale(scale int, scales []int) ([]int, bool) {
+	for _, candidate := range scaleCandidates(scales) {
+		if candidate.total == scale {
+			return candidate.passes, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestPlanScale(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ScalePolicy
+		scales []int
+		want   []int
+	}{
+		{"fixed default", ScalePolicy{}, nil, []int{2}},
+		{"default from an x2 model", ScalePolicy{}, []int{2}, []int{2}},
+		{"default not offered by an x4 model", ScalePolicy{}, []int{4}, []int{4}},
+		{"target in one pass", ScalePolicy{TargetPixels: 100 * 100 * 9}, nil, []int{3}},
+		{"target in two passes", ScalePolicy{TargetPixels: 100 * 100 * 36}, nil, []int{3, 2}},
+		{"target from x4 passes", ScalePolicy{TargetPixels: 100 * 100 * 9}, []int{4}, []int{4}},
+		{"target capped by the output limit", ScalePolicy{TargetPixels: 100 * 100 * 100, MaxOutputPixels: 100 * 100 * 16}, nil, []int{4}},
+		{"target capped for an x4 model", ScalePolicy{TargetPixels: 100 * 100 * 100, MaxOutputPixels: 100 * 100 * 9}, []int{2, 4}, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := tt.policy.PlanScale(100, 100, 2, tt.scales)
+			if err != nil {
+				t.Fatalf("PlanScale: %v", err)
+			}
+			if !slices.Equal(plan.Passes, tt.want) {
+				t.Errorf("passes = %v (%s), want %v", plan.Passes, plan.Reason, tt.want)
+			}
+			total := 1
+			for _, pass := range plan.Passes {
+				if tt.scales != nil && !slices.Contains(tt.scales, pass) {
+					t.Errorf("pass %dx is not one of %v", pass, tt.scales)
+				}
+				total *= pass
+			}
+			if plan.Scale != total {
+				t.Errorf("scale = %d, passes multiply to %d", plan.Scale, total)
+			}
+		})
+	}
+
+	// A 4x default from an x2-only model takes two passes
+	plan, err := ScalePolicy{}.PlanScale(100, 100, 4, []int{2})
+	if err != nil || !slices.Equal(plan.Passes, []int{2, 2}) {
+		t.Errorf("PlanScale(4x from [2]) = %+v, %v", plan, err)
+	}
+
+	_, err = ScalePolicy{MaxOutputPixels: 100 * 100 * 3}.PlanScale(100, 100, 2, nil)
+	if !errors.Is(err, errOutputTooLarge) {
+		t.Errorf("PlanScale over the cap = %v, want errOutputTooLarge", err)
+	}
+}
+
+func TestPlanFixedScale(t *testing.T) {
+	plan, err := ScalePolicy{}.PlanFixedScale(10, 10, 8, nil)
+	if err != nil || !slices.Equal(plan.Passes, []int{4, 2}) {
+		t.Errorf("PlanFixedScale(8x) = %+v, %v", plan, err)
+	}
+	plan, err = ScalePolicy{}.PlanFixedScale(10, 10, 16, []int{4})
+	if err != nil || !slices.Equal(plan.Passes, []int{4, 4}) {
+		t.Errorf("PlanFixedScale(16x from [4]) = %+v, %v", plan, err)
+	}
+
+	// An x4 model cannot produce an exact 2x
+	if _, err := (ScalePolicy{}).PlanFixedScale(10, 10, 2, []int{4}); err == nil || errors.Is(err, errOutputTooLarge) {
+		t.Errorf("PlanFixedScale(2x from [4]) = %v, want an unsupported scale error", err)
+	}
+	if _, err := (ScalePolicy{MaxOutputPixels: 300}).PlanFixedScale(10, 10, 2, nil); !errors.Is(err, errOutputTooLarge) {
+		t.Errorf("PlanFixedScale over the cap = %v, want errOutputTooLarge", err)
+	}
+}
+
+func TestPipelinePlansFromModelScales(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	models, err := NewModelRegistry([]ModelSpec{{ID: "x4-only", Type: ModelTypeBicubic, Scales: []int{4}}})
+	if err != nil {
+		t.Fatalf("NewModelRegistry: %v", err)
+	}
+	po.SetModelRegistry(models)
+	ctx := context.Background()
+
+	// The configured 2x is not available, so the model's 4x is used
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 20, 10), "x4.png", ProcessOptions{})
+	if result.Status != "success" || result.UpscaleScale != 4 || !slices.Equal(result.UpscalePasses, []int{4}) {
+		t.Fatalf("result = %+v", result)
+	}
+	obj := fake.object("upscaled/x4.png")
+	if obj == nil {
+		t.Fatalf("upscaled image not stored, have %v", fake.keys())
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(obj.data)); err != nil || cfg.Width != 80 || cfg.Height != 40 {
+		t.Errorf("stored image is %dx%d (%v), want 80x40", cfg.Width, cfg.Height, err)
+	}
+
+	// A rule asking for an exact 2x cannot be met by the model
+	if _, err := NewRouter([]RoutingRule{{Name: "exact-2x", Action: RuleActionUpscale, Scale: 2}}, 0.5, models); err == nil {
+		t.Error("NewRouter accepted a scale the model cannot build")
+	}
+
+	// A larger exact scale is built by chaining the model's passes
+	router, err := NewRouter([]RoutingRule{{Name: "exact-16x", Action: RuleActionUpscale, Scale: 16}}, 0.5, models)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	po.SetRouter(router)
+	result = po.ProcessImageWithOptions(ctx, testPNG(t, 4, 2), "exact.png", ProcessOptions{})
+	if result.Status != "success" || !slices.Equal(result.UpscalePasses, []int{4, 4}) {
+		t.Errorf("result = %+v, want two 4x passes", result)
+	}
+
+	// A rule naming a model upscales with it instead of the default chain
+	models, err = NewModelRegistry([]ModelSpec{
+		{ID: StrategyBicubic, Type: ModelTypeBicubic},
+		{ID: "x4-only", Type: ModelTypeBicubic, Scales: []int{4}},
+	})
+	if err != nil {
+		t.Fatalf("NewModelRegistry: %v", err)
+	}
+	po.SetModelRegistry(models)
+	if router, err = NewRouter([]RoutingRule{{Name: "x4-model", Action: RuleActionUpscale, Model: "x4-only"}}, 0.5, models); err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	po.SetRouter(router)
+	result = po.ProcessImageWithOptions(ctx, testPNG(t, 20, 10), "ruled.png", ProcessOptions{})
+	if result.Status != "success" || result.ModelID != "x4-only" || result.UpscaleScale != 4 {
+		t.Errorf("result = %+v, want the rule's 4x model", result)
+	}
+}
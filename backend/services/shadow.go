@@ -0,0 +1,335 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var historyComparisonsBucket = []byte("shadow_comparisons") // created-at nanos + ID -> ShadowComparison JSON
+
+// psnrTieDB is the PSNR difference below which two outputs are considered
+// equally faithful and sharpness decides the winner
+const psnrTieDB = 0.1
+
+// ModelScore is how one model's output scored in a shadow comparison
+type ModelScore struct {
+	Model     string   `json:"model"`
+	Passes    []string `json:"passes,omitempty"` // model that produced each pass; a fallback may stand in for Model
+	Sharpness float64  `json:"sharpness"`        // Laplacian variance of the output
+	PSNR      float64  `json:"psnr_db"`          // output downscaled to the original size vs. the original
+	LatencyMs int64    `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ShadowComparison records a primary and a candidate model run on the same image
+type ShadowComparison struct {
+	ID          string     `json:"id"`
+	JobID       string     `json:"job_id"`
+	OriginalKey string     `json:"original_key"`
+	Scale       int        `json:"scale"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Primary     ModelScore `json:"primary"`
+	Candidate   ModelScore `json:"candidate"`
+	Winner      string     `json:"winner"` // model ID, or "tie"
+}
+
+// ShadowModelSummary aggregates one model's scores across comparisons
+type ShadowModelSummary struct {
+	Model        string  `json:"model"`
+	Runs         int     `json:"runs"`
+	Wins         int     `json:"wins"`
+	Errors       int     `json:"errors"`
+	Fallbacks    int     `json:"fallbacks"` // runs where another model produced some pass
+	AvgSharpness float64 `json:"avg_sharpness"`
+	AvgPSNR      float64 `json:"avg_psnr_db"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// ShadowReport summarizes shadow comparisons per model
+type ShadowReport struct {
+	Comparisons int                   `json:"comparisons"`
+	Ties        int                   `json:"ties"`
+	Leader      string                `json:"leader,omitempty"` // model with the most wins
+	Models      []*ShadowModelSummary `json:"models"`
+}
+
+// ShadowTester runs a sample of upscale jobs through a candidate model in the
+// background and records how its output compares with the primary model's. The
+// candidate output is only scored, never stored.
+type ShadowTester struct {
+	models    *ModelRegistry
+	candidate string
+	percent   float64
+	history   *HistoryStore
+	slots     chan struct{}
+}
+
+// NewShadowTester creates a tester that sends percent (0-100) of upscale jobs to
+// the candidate model, with at most concurrency comparisons running at once
+func NewShadowTester(models *ModelRegistry, candidate string, percent float64, concurrency int, history *HistoryStore) (*ShadowTester, error) {
+	if !models.Has(candidate) {
+		return nil, fmt.Errorf("unknown shadow model %q", candidate)
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("shadow percentage must be between 0 and 100, got %v", percent)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &ShadowTester{
+		models:    models,
+		candidate: candidate,
+		percent:   percent,
+		history:   history,
+		slots:     make(chan struct{}, concurrency),
+	}, nil
+}
+
+// Candidate returns the ID of the model under evaluation
+func (st *ShadowTester) Candidate() string {
+	return st.candidate
+}
+
+// Observe samples a finished upscale job and, if selected, compares the
+// candidate model against the primary output in the background. The primary
+// side is credited to the model the job asked for, even when a fallback
+// produced some passes. Jobs meant for the candidate or whose output already
+// came from it are skipped, as are samples arriving while every comparison
+// slot is busy.
+func (st *ShadowTester) Observe(result *ProcessingResult, original, primaryOutput []byte, primaryLatency time.Duration) {
+	if result.UpscaleStrategy == "" || rand.Float64()*100 >= st.percent {
+		return
+	}
+	primary := st.models.PrimaryModel(result.ModelID)
+	strategies := strings.Split(result.UpscaleStrategy, ",")
+	if primary == st.candidate || allPassesBy(strategies, st.candidate) {
+		return
+	}
+
+	select {
+	case st.slots <- struct{}{}:
+	default:
+		log.Printf("Shadow test skipped for job %s: all comparison slots busy", result.JobID)
+		return
+	}
+
+	comparison := &ShadowComparison{
+		ID:          newJobID(),
+		JobID:       result.JobID,
+		OriginalKey: result.OriginalKey,
+		Scale:       result.UpscaleScale,
+		CreatedAt:   time.Now(),
+		Primary: ModelScore{
+			Model:     primary,
+			Passes:    strategies,
+			LatencyMs: primaryLatency.Milliseconds(),
+		},
+		Candidate: ModelScore{Model: st.candidate},
+	}
+	passes := append([]int(nil), result.UpscalePasses...)
+
+	go func() {
+		defer func() { <-st.slots }()
+		st.compare(comparison, original, primaryOutput, passes)
+	}()
+}
+
+// compare runs the candidate, scores both outputs and records the comparison
+func (st *ShadowTester) compare(comparison *ShadowComparison, original, primaryOutput []byte, passes []int) {
+	originalImg, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		log.Printf("Shadow test for job %s abandoned: failed to decode original: %v", comparison.JobID, err)
+		return
+	}
+
+	if err := scoreOutput(&comparison.Primary, originalImg, primaryOutput); err != nil {
+		comparison.Primary.Error = err.Error()
+	}
+
+	upscaler, _ := st.models.Get(st.candidate)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	output := original
+	for _, scale := range passes {
+		if output, err = upscaler.Upscale(ctx, output, scale); err != nil {
+			break
+		}
+	}
+	comparison.Candidate.LatencyMs = time.Since(start).Milliseconds()
+	if err == nil {
+		err = scoreOutput(&comparison.Candidate, originalImg, output)
+	}
+	if err != nil {
+		comparison.Candidate.Error = err.Error()
+	}
+
+	comparison.Winner = pickWinner(comparison.Primary, comparison.Candidate)
+	if err := st.history.RecordComparison(comparison); err != nil {
+		log.Printf("Warning: failed to record shadow comparison for job %s: %v", comparison.JobID, err)
+	}
+}
+
+// scoreOutput fills in the sharpness and PSNR of an upscaled output
+func scoreOutput(score *ModelScore, original image.Image, output []byte) error {
+	img, _, err := image.Decode(bytes.NewReader(output))
+	if err != nil {
+		return fmt.Errorf("failed to decode output: %w", err)
+	}
+
+	gray, width, height := luminance(img)
+	score.Sharpness = laplacianVariance(gray, width, height)
+
+	bounds := original.Bounds()
+	score.PSNR = psnr(original, resizeTo(img, bounds.Dx(), bounds.Dy()))
+	if math.IsInf(score.PSNR, 1) {
+		// JSON cannot encode infinity; 100 dB is beyond any real difference
+		score.PSNR = 100
+	}
+	return nil
+}
+
+// pickWinner prefers the output more faithful to the original, falling back to
+// sharpness when PSNR is within psnrTieDB. A model that failed always loses.
+func pickWinner(primary, candidate ModelScore) string {
+	switch {
+	case primary.Error != "" && candidate.Error != "":
+		return "tie"
+	case candidate.Error != "":
+		return primary.Model
+	case primary.Error != "":
+		return candidate.Model
+	case primary.PSNR-candidate.PSNR > psnrTieDB:
+		return primary.Model
+	case candidate.PSNR-primary.PSNR > psnrTieDB:
+		return candidate.Model
+	case primary.Sharpness > candidate.Sharpness:
+		return primary.Model
+	case candidate.Sharpness > primary.Sharpness:
+		return candidate.Model
+	default:
+		return "tie"
+	}
+}
+
+// allPassesBy reports whether every pass was produced by model
+func allPassesBy(passes []string, model string) bool {
+	for _, pass := range passes {
+		if pass != model {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordComparison stores a shadow comparison
+func (hs *HistoryStore) RecordComparison(comparison *ShadowComparison) error {
+	data, err := json.Marshal(comparison)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 8, 8+len(comparison.ID))
+	binary.BigEndian.PutUint64(key, uint64(comparison.CreatedAt.UnixNano()))
+	key = append(key, comparison.ID...)
+
+	return hs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyComparisonsBucket).Put(key, data)
+	})
+}
+
+// ListComparisons returns shadow comparisons recorded since the given time,
+// newest first, optionally only those involving a candidate model. A limit of
+// zero returns all of them.
+func (hs *HistoryStore) ListComparisons(candidate string, since time.Time, limit int) ([]*ShadowComparison, error) {
+	comparisons := []*ShadowComparison{}
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(historyComparisonsBucket).Cursor()
+		for k, data := cursor.Last(); k != nil; k, data = cursor.Prev() {
+			if !since.IsZero() && time.Unix(0, int64(binary.BigEndian.Uint64(k[:8]))).Before(since) {
+				break
+			}
+
+			var comparison ShadowComparison
+			if err := json.Unmarshal(data, &comparison); err != nil {
+				return err
+			}
+			if candidate != "" && comparison.Candidate.Model != candidate {
+				continue
+			}
+
+			comparisons = append(comparisons, &comparison)
+			if limit > 0 && len(comparisons) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return comparisons, err
+}
+
+// SummarizeComparisons tallies wins and average scores per model. Averages only
+// cover runs that produced an output.
+func SummarizeComparisons(comparisons []*ShadowComparison) *ShadowReport {
+	report := &ShadowReport{
+		Comparisons: len(comparisons),
+		Models:      []*ShadowModelSummary{},
+	}
+
+	summaries := make(map[string]*ShadowModelSummary)
+	add := func(score ModelScore, won bool) {
+		summary, ok := summaries[score.Model]
+		if !ok {
+			summary = &ShadowModelSummary{Model: score.Model}
+			summaries[score.Model] = summary
+			report.Models = append(report.Models, summary)
+		}
+		summary.Runs++
+		if won {
+			summary.Wins++
+		}
+		if len(score.Passes) > 0 && !allPassesBy(score.Passes, score.Model) {
+			summary.Fallbacks++
+		}
+		if score.Error != "" {
+			summary.Errors++
+			return
+		}
+		// Running means over the successful runs
+		n := float64(summary.Runs - summary.Errors)
+		summary.AvgSharpness += (score.Sharpness - summary.AvgSharpness) / n
+		summary.AvgPSNR += (score.PSNR - summary.AvgPSNR) / n
+		summary.AvgLatencyMs += (float64(score.LatencyMs) - summary.AvgLatencyMs) / n
+	}
+
+	for _, comparison := range comparisons {
+		if comparison.Winner == "tie" {
+			report.Ties++
+		}
+		add(comparison.Primary, comparison.Winner == comparison.Primary.Model)
+		add(comparison.Candidate, comparison.Winner == comparison.Candidate.Model)
+	}
+
+	sort.SliceStable(report.Models, func(i, j int) bool {
+		return report.Models[i].Wins > report.Models[j].Wins
+	})
+	if len(report.Models) > 0 && report.Models[0].Wins > 0 &&
+		(len(report.Models) == 1 || report.Models[0].Wins > report.Models[1].Wins) {
+		report.Leader = report.Models[0].Model
+	}
+
+	return report
+}
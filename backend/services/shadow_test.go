@@ -0,0 +1,111 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPickWinner(t *testing.T) {
+	primary := ModelScore{Model: "primary", PSNR: 30, Sharpness: 10}
+	tests := []struct {
+		name      string
+		candidate ModelScore
+		want      string
+	}{
+		{"more faithful", ModelScore{Model: "candidate", PSNR: 31, Sharpness: 1}, "candidate"},
+		{"less faithful", ModelScore{Model: "candidate", PSNR: 29, Sharpness: 100}, "primary"},
+		{"sharper within the tie margin", ModelScore{Model: "candidate", PSNR: 30.05, Sharpness: 20}, "candidate"},
+		{"identical", ModelScore{Model: "candidate", PSNR: 30, Sharpness: 10}, "tie"},
+		{"candidate failed", ModelScore{Model: "candidate", PSNR: 50, Error: "boom"}, "primary"},
+	}
+	for _, tt := range tests {
+		if got := pickWinner(primary, tt.candidate); got != tt.want {
+			t.Errorf("%s: winner = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeComparisons(t *testing.T) {
+	comparison := func(winner string, candidateErr string) *ShadowComparison {
+		return &ShadowComparison{
+			Primary:   ModelScore{Model: "primary", PSNR: 30, LatencyMs: 100},
+			Candidate: ModelScore{Model: "candidate", PSNR: 34, LatencyMs: 300, Error: candidateErr},
+			Winner:    winner,
+		}
+	}
+	// A pass produced by a fallback still counts for the primary model
+	fallback := comparison("primary", "boom")
+	fallback.Primary.Passes = []string{"primary", "bicubic"}
+	report := SummarizeComparisons([]*ShadowComparison{
+		comparison("candidate", ""),
+		comparison("candidate", ""),
+		fallback,
+		comparison("tie", ""),
+	})
+
+	if report.Comparisons != 4 || report.Ties != 1 || report.Leader != "candidate" || len(report.Models) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	if primary := report.Models[1]; primary.Model != "primary" || primary.Runs != 4 || primary.Wins != 1 || primary.Fallbacks != 1 {
+		t.Errorf("primary summary = %+v", primary)
+	}
+	candidate := report.Models[0]
+	if candidate.Model != "candidate" || candidate.Runs != 4 || candidate.Wins != 2 || candidate.Errors != 1 {
+		t.Errorf("candidate summary = %+v", candidate)
+	}
+	// Averages leave out the failed run
+	if candidate.AvgPSNR != 34 || candidate.AvgLatencyMs != 300 {
+		t.Errorf("candidate averages = %+v", candidate)
+	}
+
+	if report := SummarizeComparisons(nil); report.Leader != "" || len(report.Models) != 0 {
+		t.Errorf("empty report = %+v", report)
+	}
+}
+
+func TestShadowTesterRecordsComparison(t *testing.T) {
+	models, err := NewModelRegistry([]ModelSpec{
+		{ID: StrategyBicubic, Type: ModelTypeBicubic},
+		{ID: "candidate", Type: ModelTypeBicubic},
+	})
+	if err != nil {
+		t.Fatalf("NewModelRegistry: %v", err)
+	}
+	history := newTestHistory(t)
+	if _, err := NewShadowTester(models, "missing", 100, 1, history); err == nil {
+		t.Error("NewShadowTester accepted an unknown model")
+	}
+	shadow, err := NewShadowTester(models, "candidate", 100, 1, history)
+	if err != nil {
+		t.Fatalf("NewShadowTester: %v", err)
+	}
+
+	// Jobs meant for or already upscaled by the candidate are not compared with themselves
+	original := testPNG(t, 16, 16)
+	shadow.Observe(&ProcessingResult{JobID: "self", UpscaleStrategy: "candidate", UpscalePasses: []int{2}}, original, original, 0)
+	shadow.Observe(&ProcessingResult{JobID: "fallback", ModelID: "candidate", UpscaleStrategy: StrategyBicubic, UpscalePasses: []int{2}}, original, original, 0)
+
+	upscaled := testPNG(t, 32, 32)
+	result := &ProcessingResult{JobID: "job-1", UpscaleStrategy: StrategyBicubic, UpscaleScale: 2, UpscalePasses: []int{2}}
+	shadow.Observe(result, original, upscaled, 40*time.Millisecond)
+
+	var comparisons []*ShadowComparison
+	for deadline := time.Now().Add(5 * time.Second); len(comparisons) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		if comparisons, err = history.ListComparisons("candidate", time.Time{}, 0); err != nil {
+			t.Fatalf("ListComparisons: %v", err)
+		}
+	}
+	if len(comparisons) != 1 {
+		t.Fatalf("recorded %d comparisons, want 1", len(comparisons))
+	}
+	comparison := comparisons[0]
+	if comparison.JobID != "job-1" || comparison.Primary.Model != StrategyBicubic || comparison.Primary.LatencyMs != 40 ||
+		!slices.Equal(comparison.Primary.Passes, []string{StrategyBicubic}) {
+		t.Errorf("comparison = %+v", comparison)
+	}
+	if comparison.Candidate.Error != "" || comparison.Candidate.PSNR == 0 || comparison.Winner == "" {
+		t.Errorf("candidate score = %+v, winner %q", comparison.Candidate, comparison.Winner)
+	}
+}
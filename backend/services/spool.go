@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SpoolEntry describes an image whose storage write failed and is waiting to be retried
+type SpoolEntry struct {
+	ID         string            `json:"id"`
+	JobID      string            `json:"job_id"`
+	Folder     string            `json:"folder"`
+	Key        string            `json:"key"`                   // full bucket key
+	StagingKey string            `json:"staging_key,omitempty"` // staged copy of the job's input, removed once flushed
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	LastError  string            `json:"last_error"`
+	Attempts   int               `json:"attempts"`
+	SpooledAt  time.Time         `json:"spooled_at"`
+}
+
+// SpoolFlushHook is called after a spooled image was uploaded to location
+type SpoolFlushHook func(ctx context.Context, entry *SpoolEntry, data []byte, location string)
+
+// Spool is a durable local dead-letter directory for images that could not be
+// written to storage. Each entry is an image file plus a JSON sidecar; the
+// sidecar is written last so a partially written entry is never picked up.
+type Spool struct {
+	dir            string
+	storageService *StorageService
+	onFlushed      SpoolFlushHook
+	flushMu        sync.Mutex // serializes flushes; Add needs no lock as entry IDs are unique
+}
+
+// NewSpool creates a spool rooted at dir, creating the directory if needed
+func NewSpool(dir string, storageService *StorageService) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &Spool{
+		dir:            dir,
+		storageService: storageService,
+	}, nil
+}
+
+// OnFlushed registers the hook that completes a job once its spooled image is
+// uploaded
+func (sp *Spool) OnFlushed(hook SpoolFlushHook) {
+	sp.onFlushed = hook
+}
+
+// Add stores an image of the job in result that failed to upload to the full
+// bucket key so it can be retried later
+func (sp *Spool) Add(result *ProcessingResult, key string, data []byte, metadata map[string]string, uploadErr error) (*SpoolEntry, error) {
+	sum := sha256.Sum256([]byte(key))
+	entry := &SpoolEntry{
+		ID:         fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(sum[:8])),
+		JobID:      result.JobID,
+		Folder:     result.Folder,
+		Key:        key,
+		StagingKey: result.stagingKey,
+		Metadata:   metadata,
+		LastError:  uploadErr.Error(),
+		SpooledAt:  time.Now(),
+	}
+
+	if err := os.WriteFile(sp.dataPath(entry.ID), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to spool image: %w", err)
+	}
+	if err := sp.writeEntry(entry); err != nil {
+		os.Remove(sp.dataPath(entry.ID))
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Pending returns the entries currently waiting in the spool
+func (sp *Spool) Pending() ([]*SpoolEntry, error) {
+	return sp.pending()
+}
+
+// Flush retries every spooled entry once, removing those that upload successfully
+// and passing them to the flush hook. It returns the number of entries flushed.
+func (sp *Spool) Flush(ctx context.Context) (int, error) {
+	sp.flushMu.Lock()
+	defer sp.flushMu.Unlock()
+
+	entries, err := sp.pending()
+	if err != nil {
+		return 0, err
+	}
+
+	flushed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return flushed, ctx.Err()
+		}
+
+		data, err := os.ReadFile(sp.dataPath(entry.ID))
+		if err != nil {
+			log.Printf("Warning: spooled image %s is unreadable: %v", entry.ID, err)
+			continue
+		}
+
+		entry.Attempts++
+		location, err := sp.storageService.UploadObject(ctx, entry.Key, data, entry.Metadata)
+		if err != nil {
+			entry.LastError = err.Error()
+			if err := sp.writeEntry(entry); err != nil {
+				log.Printf("Warning: failed to update spool entry %s: %v", entry.ID, err)
+			}
+			continue
+		}
+
+		os.Remove(sp.entryPath(entry.ID))
+		os.Remove(sp.dataPath(entry.ID))
+		flushed++
+		if sp.onFlushed != nil {
+			sp.onFlushed(ctx, entry, data, location)
+		}
+	}
+
+	return flushed, nil
+}
+
+// Run flushes the spool every interval until ctx is cancelled
+func (sp *Spool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flushed, err := sp.Flush(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Warning: spool flush failed: %v", err)
+			}
+			if flushed > 0 {
+				log.Printf("Flushed %d spooled images to storage", flushed)
+			}
+		}
+	}
+}
+
+func (sp *Spool) pending() ([]*SpoolEntry, error) {
+	matches, err := filepath.Glob(filepath.Join(sp.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*SpoolEntry, 0, len(matches))
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool entry: %w", err)
+		}
+
+		var entry SpoolEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Warning: skipping corrupt spool entry %s: %v", filepath.Base(path), err)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// writeEntry writes the sidecar atomically
+func (sp *Spool) writeEntry(entry *SpoolEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := sp.entryPath(entry.ID) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	return os.Rename(tmpPath, sp.entryPath(entry.ID))
+}
+
+func (sp *Spool) entryPath(id string) string {
+	return filepath.Join(sp.dir, id+".json")
+}
+
+func (sp *Spool) dataPath(id string) string {
+	return filepath.Join(sp.dir, id+".img")
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolKeepsFailedUploadsUntilFlushed(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Storage is unreachable while the job runs, so the image goes to the spool
+	unavailable := newTestStorage(fake, "missing-bucket")
+	spool, err := NewSpool(dir, unavailable)
+	if err != nil {
+		t.Fatalf("NewSpool: %v", err)
+	}
+	po := NewPipelineOrchestrator(NewQualityService(0.5), unavailable, "upscale.py", 2)
+	if err := po.Models().SetDefaultChain([]string{StrategyBicubic}); err != nil {
+		t.Fatalf("SetDefaultChain: %v", err)
+	}
+	po.SetRetryPolicies(DefaultRetryPolicy(), RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	po.SetKeepOriginals(false)
+	po.SetSpool(spool)
+	history := newTestHistory(t)
+	po.SetHistoryStore(history)
+
+	// The input was staged before storage went away
+	staged := "processing/job-1/spooled.png"
+	if _, err := newTestStorage(fake, testBucket).UploadObject(ctx, staged, testPNG(t, 32, 32), nil); err != nil {
+		t.Fatalf("UploadObject: %v", err)
+	}
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 32, 32), "spooled.png", ProcessOptions{StagedKey: staged})
+	if !result.Spooled || result.StorageError == "" || result.Status != "error" || result.StorageKey != "" || result.SpooledKey != "upscaled/spooled.png" {
+		t.Fatalf("result = %+v, want the image spooled", result)
+	}
+	pending, err := spool.Pending()
+	if err != nil || len(pending) != 1 || pending[0].Key != "upscaled/spooled.png" || pending[0].JobID != result.JobID || pending[0].StagingKey != staged {
+		t.Fatalf("Pending = %+v, %v", pending, err)
+	}
+	if fake.object(staged) == nil {
+		t.Fatal("staged input was removed while its image is spooled")
+	}
+
+	// A failed flush keeps the entry and counts the attempt
+	if flushed, err := spool.Flush(ctx); err != nil || flushed != 0 {
+		t.Errorf("Flush = %d, %v; want nothing flushed", flushed, err)
+	}
+	if pending, _ := spool.Pending(); len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Errorf("entry after failed flush = %+v", pending)
+	}
+
+	// Once storage is back the entry is uploaded and removed, and the job completed
+	available := newTestStorage(fake, testBucket)
+	recovered, err := NewSpool(dir, available)
+	if err != nil {
+		t.Fatalf("NewSpool: %v", err)
+	}
+	po = NewPipelineOrchestrator(NewQualityService(0.5), available, "upscale.py", 2)
+	po.SetHistoryStore(history)
+	po.SetSpool(recovered)
+	if flushed, err := recovered.Flush(ctx); err != nil || flushed != 1 {
+		t.Fatalf("Flush = %d, %v; want 1 flushed", flushed, err)
+	}
+	obj := fake.object("upscaled/spooled.png")
+	if obj == nil || imageFormat(obj.data) != "png" || obj.metadata[MetadataJobID] != result.JobID {
+		t.Errorf("flushed object = %+v", obj)
+	}
+	if pending, _ := recovered.Pending(); len(pending) != 0 {
+		t.Errorf("%d entries left after flushing", len(pending))
+	}
+	if fake.object(staged) != nil {
+		t.Error("staged input was kept after the spooled image was flushed")
+	}
+	recorded, err := history.Get(result.JobID)
+	if err != nil || recorded == nil {
+		t.Fatalf("history.Get = %+v, %v", recorded, err)
+	}
+	if recorded.Spooled || recorded.SpooledKey != "" || recorded.Status != "success" || recorded.StorageKey != "upscaled/spooled.png" || !strings.HasPrefix(recorded.S3URL, fake.URL+"/") {
+		t.Errorf("recorded result = %+v, want the stored image", recorded)
+	}
+}
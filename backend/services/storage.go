@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 const (
@@ -15,36 +19,179 @@ const (
 	FolderUpscaled       = "upscaled"
 	FolderCouldntUpscale = "couldn't_upscale"
 	FolderProcessing     = "processing"
+	FolderOriginals      = "originals"
+	FolderThumbnails     = "thumbnails"
 )
 
+// Object metadata keys written by the pipeline
+const (
+	MetadataFailureReason    = "failure-reason"
+	MetadataFailureMessage   = "failure-message"
+	MetadataRetryCount       = "retry-count"
+	MetadataJobID            = "job-id"
+	MetadataContentClass     = "content-class"
+	MetadataQualityScore     = "quality-score"
+	MetadataOriginalFilename = "original-filename"
+	MetadataScale            = "scale"
+	MetadataModel            = "model"
+	MetadataPipelineVersion  = "pipeline-version"
+	MetadataOriginalKey      = "original-key"   // on an upscaled image: where its source is kept
+	MetadataDerivativeKey    = "derivative-key" // on a kept original: the upscaled image made from it
+	MetadataSourceKey        = "source-key"     // on a thumbnail: the image it previews
+)
+
+// DefaultCacheControl is the Cache-Control header written on stored objects
+const DefaultCacheControl = "public, max-age=86400"
+
+// Default lifetimes of pre-signed URLs; S3 accepts at most seven days
+const (
+	DefaultURLExpiry       = time.Hour
+	DefaultUploadURLExpiry = 15 * time.Minute
+	maxPresignExpiry       = 7 * 24 * time.Hour
+)
+
+// maxMetadataValueLen keeps user metadata well under the 2KB S3 header limit
+const maxMetadataValueLen = 256
+
 // StorageService handles AWS S3 operations
 type StorageService struct {
-	client     *s3.Client
-	bucket     string
-	uploader   *manager.Uploader
-	downloader *manager.Downloader
+	client       *s3.Client
+	bucket       string
+	uploader     *manager.Uploader
+	downloader   *manager.Downloader
+	presigner    *s3.PresignClient
+	cacheControl string
+	urlExpiry    time.Duration
+	uploadExpiry time.Duration
+
+	writeSettings WriteSettings
+}
+
+// PresignedURL is a time-limited URL granting access to a single object
+type PresignedURL struct {
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers,omitempty"` // must be sent with the request
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// ObjectMetadata describes a stored object as returned by HeadObject
+type ObjectMetadata struct {
+	Key              string            `json:"key"`
+	ContentType      string            `json:"content_type"`
+	ContentLength    int64             `json:"content_length"`
+	CacheControl     string            `json:"cache_control,omitempty"`
+	ETag             string            `json:"etag,omitempty"`
+	LastModified     time.Time         `json:"last_modified"`
+	JobID            string            `json:"job_id,omitempty"`
+	QualityScore     string            `json:"quality_score,omitempty"`
+	OriginalFilename string            `json:"original_filename,omitempty"`
+	Scale            string            `json:"scale,omitempty"`
+	Model            string            `json:"model,omitempty"`
+	PipelineVersion  string            `json:"pipeline_version,omitempty"`
+	OriginalKey      string            `json:"original_key,omitempty"`
+	DerivativeKey    string            `json:"derivative_key,omitempty"`
+	Encryption       string            `json:"encryption"` // AES256, aws:kms or none
+	KMSKeyID         string            `json:"kms_key_id,omitempty"`
+	StorageClass     string            `json:"storage_class"`
+	Tags             map[string]string `json:"tags,omitempty"` // filled in by callers that read tags
+	Metadata         map[string]string `json:"metadata"`       // all user metadata, lower-cased keys
+}
+
+// S3Options points the storage service at an S3-compatible store such as
+// MinIO or LocalStack instead of AWS
+type S3Options struct {
+	Endpoint     string // base URL, e.g. http://localhost:9000; empty uses AWS
+	UsePathStyle bool   // bucket in the path rather than the host name
+	DisableTLS   bool   // plain HTTP; an endpoint without a scheme gets http://
 }
 
 // NewStorageService creates a new storage service
 func NewStorageService(cfg aws.Config, bucket string) *StorageService {
-	client := s3.NewFromConfig(cfg)
+	return NewStorageServiceWithOptions(cfg, bucket, S3Options{})
+}
+
+// NewStorageServiceWithOptions creates a storage service for a custom S3
+// endpoint. Pre-signed URLs use the same endpoint and addressing style.
+func NewStorageServiceWithOptions(cfg aws.Config, bucket string, opts S3Options) *StorageService {
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(endpointURL(opts.Endpoint, opts.DisableTLS))
+		}
+		o.UsePathStyle = opts.UsePathStyle
+		o.EndpointOptions.DisableHTTPS = opts.DisableTLS
+	})
 	return &StorageService{
-		client:     client,
-		bucket:     bucket,
-		uploader:   manager.NewUploader(client),
-		downloader: manager.NewDownloader(client),
+		client:       client,
+		bucket:       bucket,
+		uploader:     manager.NewUploader(client),
+		downloader:   manager.NewDownloader(client),
+		presigner:    s3.NewPresignClient(client),
+		cacheControl: DefaultCacheControl,
+		urlExpiry:    DefaultURLExpiry,
+		uploadExpiry: DefaultUploadURLExpiry,
+		writeSettings: WriteSettings{
+			Layout: DefaultFolderLayout(),
+		},
+	}
+}
+
+// endpointURL adds a scheme to an endpoint given as host:port
+func endpointURL(endpoint string, disableTLS bool) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
 	}
+	if disableTLS {
+		return "http://" + endpoint
+	}
+	return "https://" + endpoint
+}
+
+// SetURLExpiry sets how long pre-signed download and upload URLs stay valid
+func (ss *StorageService) SetURLExpiry(download, upload time.Duration) {
+	ss.urlExpiry = min(download, maxPresignExpiry)
+	ss.uploadExpiry = min(upload, maxPresignExpiry)
+}
+
+// SetCacheControl sets the Cache-Control header written on uploads; empty omits it
+func (ss *StorageService) SetCacheControl(cacheControl string) {
+	ss.cacheControl = cacheControl
 }
 
 // UploadImage uploads an image to the specified S3 folder
 func (ss *StorageService) UploadImage(ctx context.Context, folder, objectKey string, data []byte) (string, error) {
-	fullKey := fmt.Sprintf("%s/%s", folder, objectKey)
+	return ss.UploadImageWithMetadata(ctx, folder, objectKey, data, nil)
+}
 
-	result, err := ss.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(ss.bucket),
-		Key:    aws.String(fullKey),
-		Body:   bytes.NewReader(data),
-	})
+// UploadImageWithMetadata uploads an image to the specified S3 folder with user metadata
+func (ss *StorageService) UploadImageWithMetadata(ctx context.Context, folder, objectKey string, data []byte, metadata map[string]string) (string, error) {
+	return ss.UploadObject(ctx, folderKey(folder, objectKey), data, metadata)
+}
+
+// UploadObject uploads data to a full bucket key with user metadata. The
+// Content-Type is detected from the data, falling back to the key's extension.
+func (ss *StorageService) UploadObject(ctx context.Context, fullKey string, data []byte, metadata map[string]string) (string, error) {
+	var userMetadata map[string]string
+	if len(metadata) > 0 {
+		userMetadata = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			userMetadata[k] = sanitizeMetadataValue(v)
+		}
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(ss.bucket),
+		Key:         aws.String(fullKey),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(DetectContentType(data, fullKey)),
+		Metadata:    userMetadata,
+	}
+	if ss.cacheControl != "" {
+		input.CacheControl = aws.String(ss.cacheControl)
+	}
+	ss.applyWriteSettings(input)
+
+	result, err := ss.uploader.Upload(ctx, input)
 
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image to S3: %w", err)
@@ -55,8 +202,11 @@ func (ss *StorageService) UploadImage(ctx context.Context, folder, objectKey str
 
 // DownloadImage downloads an image from S3
 func (ss *StorageService) DownloadImage(ctx context.Context, folder, objectKey string) ([]byte, error) {
-	fullKey := fmt.Sprintf("%s/%s", folder, objectKey)
+	return ss.DownloadObject(ctx, folderKey(folder, objectKey))
+}
 
+// DownloadObject downloads the object at a full bucket key
+func (ss *StorageService) DownloadObject(ctx context.Context, fullKey string) ([]byte, error) {
 	buf := manager.NewWriteAtBuffer([]byte{})
 	_, err := ss.downloader.Download(ctx, buf, &s3.GetObjectInput{
 		Bucket: aws.String(ss.bucket),
@@ -70,20 +220,192 @@ func (ss *StorageService) DownloadImage(ctx context.Context, folder, objectKey s
 	return buf.Bytes(), nil
 }
 
-// GetImageURL generates the S3 URL for an image
+// GetImageMetadata reads the user metadata of a stored image
+func (ss *StorageService) GetImageMetadata(ctx context.Context, folder, objectKey string) (map[string]string, error) {
+	return ss.GetObjectMetadata(ctx, folderKey(folder, objectKey))
+}
+
+// GetObjectMetadata reads the user metadata of the object at a full bucket key
+func (ss *StorageService) GetObjectMetadata(ctx context.Context, fullKey string) (map[string]string, error) {
+	head, err := ss.HeadObject(ctx, fullKey)
+	if err != nil {
+		return nil, err
+	}
+	return head.Metadata, nil
+}
+
+// HeadObject reads the headers and pipeline metadata of the object at a full
+// bucket key without downloading it
+func (ss *StorageService) HeadObject(ctx context.Context, fullKey string) (*ObjectMetadata, error) {
+	result, err := ss.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(fullKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image metadata from S3: %w", err)
+	}
+
+	metadata := make(map[string]string, len(result.Metadata))
+	for k, v := range result.Metadata {
+		metadata[strings.ToLower(k)] = v
+	}
+
+	encryption := string(result.ServerSideEncryption)
+	if encryption == "" {
+		encryption = EncryptionNone
+	}
+	// S3 omits the storage class of STANDARD objects
+	storageClass := string(result.StorageClass)
+	if storageClass == "" {
+		storageClass = string(types.StorageClassStandard)
+	}
+
+	// Names and keys are escaped when written, as metadata values must be ASCII
+	unescape := func(name string) string {
+		if unescaped, err := url.PathUnescape(metadata[name]); err == nil {
+			return unescaped
+		}
+		return metadata[name]
+	}
+
+	return &ObjectMetadata{
+		Key:              fullKey,
+		ContentType:      aws.ToString(result.ContentType),
+		ContentLength:    aws.ToInt64(result.ContentLength),
+		CacheControl:     aws.ToString(result.CacheControl),
+		ETag:             strings.Trim(aws.ToString(result.ETag), `"`),
+		LastModified:     aws.ToTime(result.LastModified),
+		JobID:            metadata[MetadataJobID],
+		QualityScore:     metadata[MetadataQualityScore],
+		OriginalFilename: unescape(MetadataOriginalFilename),
+		Scale:            metadata[MetadataScale],
+		Model:            metadata[MetadataModel],
+		PipelineVersion:  metadata[MetadataPipelineVersion],
+		OriginalKey:      unescape(MetadataOriginalKey),
+		DerivativeKey:    unescape(MetadataDerivativeKey),
+		Encryption:       encryption,
+		KMSKeyID:         aws.ToString(result.SSEKMSKeyId),
+		StorageClass:     storageClass,
+		Metadata:         metadata,
+	}, nil
+}
+
+// GetImageURL generates a pre-signed download URL for an image, or "" if it
+// cannot be signed
 func (ss *StorageService) GetImageURL(folder, objectKey string) string {
-	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s/%s", ss.bucket, folder, objectKey)
+	presigned, err := ss.PresignGet(context.Background(), folderKey(folder, objectKey))
+	if err != nil {
+		return ""
+	}
+	return presigned.URL
+}
+
+// PresignGet returns a pre-signed GET URL for the object at a full bucket key.
+// Signing happens locally, so it works for private buckets in any region.
+func (ss *StorageService) PresignGet(ctx context.Context, fullKey string) (*PresignedURL, error) {
+	request, err := ss.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(fullKey),
+	}, s3.WithPresignExpires(ss.urlExpiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pre-sign download URL: %w", err)
+	}
+	return presignedURL(request.URL, request.Method, nil, ss.urlExpiry), nil
+}
+
+// PresignPut returns a pre-signed PUT URL for uploading an object of the given
+// content type directly to a full bucket key
+func (ss *StorageService) PresignPut(ctx context.Context, fullKey, contentType string, metadata map[string]string) (*PresignedURL, error) {
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(ss.bucket),
+		Key:      aws.String(fullKey),
+		Metadata: metadata,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	ss.applyWriteSettings(input)
+
+	request, err := ss.presigner.PresignPutObject(ctx, input, s3.WithPresignExpires(ss.uploadExpiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pre-sign upload URL: %w", err)
+	}
+
+	// Signed headers other than Host must be sent verbatim by the client
+	headers := make(map[string]string)
+	for name, values := range request.SignedHeader {
+		if !strings.EqualFold(name, "Host") && len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+	if contentType != "" {
+		// Not always signed, but sent so the object is stored with the right type
+		headers["Content-Type"] = contentType
+	}
+	return presignedURL(request.URL, request.Method, headers, ss.uploadExpiry), nil
+}
+
+// SignResultURL points result's URL at its stored image with a fresh
+// pre-signed URL. Results without a stored image are left unchanged.
+func (ss *StorageService) SignResultURL(ctx context.Context, result *ProcessingResult) {
+	if result.StorageKey == "" || result.S3URL == "" {
+		return
+	}
+	presigned, err := ss.PresignGet(ctx, result.StorageKey)
+	if err != nil {
+		return
+	}
+	result.S3URL = presigned.URL
+	result.URLExpiresAt = presigned.ExpiresAt
+}
+
+// presignedURL builds a PresignedURL expiring after expiry from now
+func presignedURL(signedURL, method string, headers map[string]string, expiry time.Duration) *PresignedURL {
+	return &PresignedURL{
+		URL:       signedURL,
+		Method:    method,
+		Headers:   headers,
+		ExpiresAt: time.Now().Add(expiry).UTC(),
+	}
+}
+
+// ObjectInfo describes a stored object as returned by a listing
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	StorageClass string    `json:"storage_class,omitempty"`
 }
 
 // ListImages lists all images in a folder
 func (ss *StorageService) ListImages(ctx context.Context, folder string) ([]string, error) {
-	prefix := fmt.Sprintf("%s/", folder)
+	objects, err := ss.ListObjects(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		images = append(images, obj.Key)
+	}
+
+	return images, nil
+}
+
+// ListObjects lists all objects in a folder along with their size and modification time
+func (ss *StorageService) ListObjects(ctx context.Context, folder string) ([]ObjectInfo, error) {
+	return ss.ListPrefix(ctx, fmt.Sprintf("%s/", folder))
+}
+
+// ListPrefix lists all objects whose full key starts with prefix; an empty
+// prefix lists the whole bucket
+func (ss *StorageService) ListPrefix(ctx context.Context, prefix string) ([]ObjectInfo, error) {
 	paginator := s3.NewListObjectsV2Paginator(ss.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(ss.bucket),
 		Prefix: aws.String(prefix),
 	})
 
-	var images []string
+	var objects []ObjectInfo
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
@@ -91,17 +413,25 @@ func (ss *StorageService) ListImages(ctx context.Context, folder string) ([]stri
 		}
 
 		for _, obj := range page.Contents {
-			images = append(images, *obj.Key)
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				StorageClass: string(obj.StorageClass),
+			})
 		}
 	}
 
-	return images, nil
+	return objects, nil
 }
 
 // DeleteImage deletes an image from S3
 func (ss *StorageService) DeleteImage(ctx context.Context, folder, objectKey string) error {
-	fullKey := fmt.Sprintf("%s/%s", folder, objectKey)
+	return ss.DeleteObject(ctx, folderKey(folder, objectKey))
+}
 
+// DeleteObject deletes the object at a full bucket key
+func (ss *StorageService) DeleteObject(ctx context.Context, fullKey string) error {
 	_, err := ss.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(ss.bucket),
 		Key:    aws.String(fullKey),
@@ -113,3 +443,72 @@ func (ss *StorageService) DeleteImage(ctx context.Context, folder, objectKey str
 
 	return nil
 }
+
+// CopyObject copies an object within the bucket, keeping its metadata. The
+// copy gets the configured encryption, storage class and folder tags.
+func (ss *StorageService) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(ss.bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(escapeCopySource(ss.bucket + "/" + srcKey)),
+	}
+	ss.applyCopySettings(input)
+
+	_, err := ss.client.CopyObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// TransitionObject moves an object to another storage class by copying it
+// onto itself, keeping its metadata and tags
+func (ss *StorageService) TransitionObject(ctx context.Context, fullKey, storageClass string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(ss.bucket),
+		Key:               aws.String(fullKey),
+		CopySource:        aws.String(escapeCopySource(ss.bucket + "/" + fullKey)),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		StorageClass:      types.StorageClass(storageClass),
+	}
+	input.ServerSideEncryption = ss.encryption()
+	if ss.writeSettings.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(ss.writeSettings.KMSKeyID)
+	}
+
+	if _, err := ss.client.CopyObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to move %s to storage class %s: %w", fullKey, storageClass, err)
+	}
+	return nil
+}
+
+// escapeCopySource URL-encodes each segment of "bucket/key", as S3 expects in
+// the copy source header
+func escapeCopySource(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// folderKey joins a folder and an object key into a full bucket key
+func folderKey(folder, objectKey string) string {
+	return fmt.Sprintf("%s/%s", folder, objectKey)
+}
+
+// sanitizeMetadataValue restricts a value to printable ASCII, since S3 sends user
+// metadata as HTTP headers, and truncates it to a reasonable length
+func sanitizeMetadataValue(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if b.Len() >= maxMetadataValueLen {
+			break
+		}
+		if r < 0x20 || r > 0x7e {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
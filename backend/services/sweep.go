@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepFailureLimit bounds how many failed results a sweep report keeps;
+// every result is still counted
+const sweepFailureLimit = 100
+
+// The checkpoint is written after this many newly finished objects, or once
+// this much time has passed since the last write, and when the sweep ends
+const (
+	sweepCheckpointBatch    = 100
+	sweepCheckpointInterval = 10 * time.Second
+)
+
+// ErrSweepRunning is returned when a sweep is started while another one runs
+var ErrSweepRunning = errors.New("a sweep is already running")
+
+// SweepOptions controls which objects a bucket sweep picks up and how
+type SweepOptions struct {
+	SourcePrefix   string    `json:"source_prefix"`
+	Concurrency    int       `json:"concurrency"`
+	CheckpointPath string    `json:"-"`                    // set by the server or CLI, never by clients
+	Extensions     []string  `json:"extensions,omitempty"` // e.g. [".jpg", ".png"]; empty means any
+	MinSize        int64     `json:"min_size,omitempty"`   // bytes, 0 means no lower bound
+	MaxSize        int64     `json:"max_size,omitempty"`   // bytes, 0 means no upper bound
+	ModifiedAfter  time.Time `json:"modified_after,omitempty"`
+	ModifiedBefore time.Time `json:"modified_before,omitempty"`
+}
+
+// SweepReport summarizes a bucket sweep run
+type SweepReport struct {
+	SourcePrefix string              `json:"source_prefix"`
+	Running      bool                `json:"running"`
+	StartedAt    time.Time           `json:"started_at"`
+	FinishedAt   time.Time           `json:"finished_at,omitempty"`
+	Listed       int                 `json:"listed"`
+	Filtered     int                 `json:"filtered"` // excluded by size/date/extension
+	Resumed      int                 `json:"resumed"`  // already done according to the checkpoint
+	Processed    int                 `json:"processed"`
+	Skipped      int                 `json:"skipped"` // rejected by a routing rule
+	Failed       int                 `json:"failed"`
+	Failures     []*ProcessingResult `json:"failures,omitempty"` // the first sweepFailureLimit failed results
+	Error        string              `json:"error,omitempty"`
+}
+
+// sweepCheckpoint is the on-disk record of keys a sweep has finished. Failed
+// keys are left out so a resumed sweep retries them.
+type sweepCheckpoint struct {
+	SourcePrefix string            `json:"source_prefix"`
+	Completed    map[string]string `json:"completed"` // key -> result status (success or skipped)
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// BucketSweeper reprocesses objects already stored in the bucket
+type BucketSweeper struct {
+	orchestrator   *PipelineOrchestrator
+	storageService *StorageService
+
+	mu     sync.Mutex
+	report *SweepReport
+}
+
+// NewBucketSweeper creates a new bucket sweeper
+func NewBucketSweeper(orchestrator *PipelineOrchestrator, storageService *StorageService) *BucketSweeper {
+	return &BucketSweeper{
+		orchestrator:   orchestrator,
+		storageService: storageService,
+	}
+}
+
+// Status returns a snapshot of the current or most recent sweep
+func (bs *BucketSweeper) Status() *SweepReport {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.report == nil {
+		return nil
+	}
+	snapshot := *bs.report
+	snapshot.Failures = append([]*ProcessingResult(nil), bs.report.Failures...)
+	return &snapshot
+}
+
+// Sweep lists the source prefix, downloads each matching object and runs it through
+// the pipeline. Keys recorded in the checkpoint file are skipped, so an interrupted
+// sweep can be resumed by running it again with the same checkpoint path. Only one
+// sweep runs at a time; others fail with ErrSweepRunning.
+func (bs *BucketSweeper) Sweep(ctx context.Context, opts SweepOptions) (*SweepReport, error) {
+	opts, report, err := bs.begin(opts)
+	if err != nil {
+		return nil, err
+	}
+	err = bs.run(ctx, opts, report)
+	bs.finish(report, err)
+	return bs.Status(), err
+}
+
+// Start runs a sweep in the background and returns its initial status. It
+// fails with ErrSweepRunning rather than queueing behind a running sweep.
+func (bs *BucketSweeper) Start(opts SweepOptions) (*SweepReport, error) {
+	opts, report, err := bs.begin(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sweep outlives the caller, so it gets a context of its own
+	go func() {
+		err := bs.run(context.Background(), opts, report)
+		bs.finish(report, err)
+		if err != nil {
+			log.Printf("Sweep of %q failed: %v", opts.SourcePrefix, err)
+		}
+	}()
+	return bs.Status(), nil
+}
+
+// begin validates the options and claims the sweeper for a new run
+func (bs *BucketSweeper) begin(opts SweepOptions) (SweepOptions, *SweepReport, error) {
+	opts.SourcePrefix = strings.Trim(opts.SourcePrefix, "/")
+	if opts.SourcePrefix == "" {
+		return opts, nil, fmt.Errorf("source prefix is required")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.report != nil && bs.report.Running {
+		return opts, nil, fmt.Errorf("%w: %q", ErrSweepRunning, bs.report.SourcePrefix)
+	}
+	report := &SweepReport{
+		SourcePrefix: opts.SourcePrefix,
+		Running:      true,
+		StartedAt:    time.Now(),
+	}
+	bs.report = report
+	return opts, report, nil
+}
+
+// finish marks a run as done
+func (bs *BucketSweeper) finish(report *SweepReport, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	report.Running = false
+	report.FinishedAt = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+func (bs *BucketSweeper) run(ctx context.Context, opts SweepOptions, report *SweepReport) error {
+	checkpoint, err := loadSweepCheckpoint(opts.CheckpointPath, opts.SourcePrefix)
+	if err != nil {
+		return err
+	}
+
+	objects, err := bs.storageService.ListObjects(ctx, opts.SourcePrefix)
+	if err != nil {
+		return err
+	}
+
+	var pending []ObjectInfo
+	bs.mu.Lock()
+	report.Listed = len(objects)
+	for _, obj := range objects {
+		switch {
+		case !opts.matches(obj):
+			report.Filtered++
+		case checkpoint.Completed[obj.Key] != "":
+			report.Resumed++
+		default:
+			pending = append(pending, obj)
+		}
+	}
+	bs.mu.Unlock()
+
+	var checkpointMu sync.Mutex
+	unsaved, lastSave := 0, time.Now()
+	saveCheckpoint := func() {
+		if err := checkpoint.save(opts.CheckpointPath); err != nil {
+			log.Printf("Warning: failed to save sweep checkpoint: %v", err)
+		}
+		unsaved, lastSave = 0, time.Now()
+	}
+
+	forEachConcurrently(ctx, opts.Concurrency, len(pending), func(i int) {
+		obj := pending[i]
+		result := bs.processObject(ctx, opts.SourcePrefix, obj)
+		if ctx.Err() != nil {
+			// Interrupted mid-flight; leave it out of the checkpoint so it is retried
+			return
+		}
+
+		bs.mu.Lock()
+		report.Processed++
+		switch result.Status {
+		case "skipped":
+			report.Skipped++
+		case "error":
+			report.Failed++
+			if len(report.Failures) < sweepFailureLimit {
+				report.Failures = append(report.Failures, result)
+			}
+		}
+		bs.mu.Unlock()
+
+		if result.Status == "error" {
+			return
+		}
+		checkpointMu.Lock()
+		defer checkpointMu.Unlock()
+		checkpoint.Completed[obj.Key] = result.Status
+		unsaved++
+		if unsaved >= sweepCheckpointBatch || time.Since(lastSave) >= sweepCheckpointInterval {
+			saveCheckpoint()
+		}
+	})
+
+	if unsaved > 0 {
+		saveCheckpoint()
+	}
+	return ctx.Err()
+}
+
+// processObject downloads a single object and runs it through the pipeline
+func (bs *BucketSweeper) processObject(ctx context.Context, prefix string, obj ObjectInfo) *ProcessingResult {
+	objectKey := strings.TrimPrefix(obj.Key, prefix+"/")
+
+	imageData, err := bs.storageService.DownloadImage(ctx, prefix, objectKey)
+	if err != nil {
+		return &ProcessingResult{
+			OriginalKey:  objectKey,
+			Status:       "error",
+			ErrorMessage: fmt.Sprintf("Download failed: %v", err),
+			ProcessedAt:  time.Now(),
+		}
+	}
+
+	return bs.orchestrator.ProcessImage(ctx, imageData, objectKey)
+}
+
+// matches reports whether an object passes the size, date and extension filters
+func (opts SweepOptions) matches(obj ObjectInfo) bool {
+	if strings.HasSuffix(obj.Key, "/") {
+		return false
+	}
+	if opts.MinSize > 0 && obj.Size < opts.MinSize {
+		return false
+	}
+	if opts.MaxSize > 0 && obj.Size > opts.MaxSize {
+		return false
+	}
+	if !opts.ModifiedAfter.IsZero() && obj.LastModified.Before(opts.ModifiedAfter) {
+		return false
+	}
+	if !opts.ModifiedBefore.IsZero() && obj.LastModified.After(opts.ModifiedBefore) {
+		return false
+	}
+	if len(opts.Extensions) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(obj.Key))
+	for _, allowed := range opts.Extensions {
+		allowed = strings.ToLower(allowed)
+		if !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// SweepCheckpointPath returns the checkpoint file of a prefix in a directory
+// owned by the server. The name is the prefix reduced to safe characters plus
+// a hash of it, so distinct prefixes never share a file.
+func SweepCheckpointPath(dir, prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	sum := sha256.Sum256([]byte(prefix))
+	name := unsafeNameChars.ReplaceAllString(prefix, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return filepath.Join(dir, fmt.Sprintf("sweep-%s-%x.json", name, sum[:4]))
+}
+
+// unsafeNameChars matches runs of characters not kept in generated file names
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// loadSweepCheckpoint reads the checkpoint file, returning an empty checkpoint if
+// no path is configured or the file does not exist yet
+func loadSweepCheckpoint(path, prefix string) (*sweepCheckpoint, error) {
+	checkpoint := &sweepCheckpoint{
+		SourcePrefix: prefix,
+		Completed:    make(map[string]string),
+	}
+	if path == "" {
+		return checkpoint, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sweep checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse sweep checkpoint: %w", err)
+	}
+	if checkpoint.SourcePrefix != prefix {
+		return nil, fmt.Errorf("checkpoint %s belongs to prefix %q, not %q", path, checkpoint.SourcePrefix, prefix)
+	}
+	if checkpoint.Completed == nil {
+		checkpoint.Completed = make(map[string]string)
+	}
+
+	return checkpoint, nil
+}
+
+// save writes the checkpoint atomically so an interrupted write never corrupts it
+func (c *sweepCheckpoint) save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	c.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
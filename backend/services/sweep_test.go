@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSweep(t *testing.T) {
+	po, fake := newTestPipeline(t, 0)
+	ctx := context.Background()
+	storage := newTestStorage(fake, testBucket)
+	uploads := map[string][]byte{
+		"a.png":      testPNG(t, 32, 32),
+		"b.png":      testPNG(t, 24, 24),
+		"notes.txt":  []byte("not an image"),
+		"broken.png": []byte("not a png"),
+	}
+	for key, data := range uploads {
+		if _, err := storage.UploadImage(ctx, "incoming", key, data); err != nil {
+			t.Fatalf("UploadImage: %v", err)
+		}
+	}
+
+	sweeper := NewBucketSweeper(po, storage)
+	checkpoint := filepath.Join(t.TempDir(), "sweep.json")
+	opts := SweepOptions{SourcePrefix: "incoming/", Extensions: []string{"png"}, CheckpointPath: checkpoint}
+	report, err := sweeper.Sweep(ctx, opts)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if report.Running || report.Listed != 4 || report.Filtered != 1 || report.Processed != 3 || report.Failed != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].OriginalKey != "broken.png" {
+		t.Errorf("failures = %+v, want broken.png", report.Failures)
+	}
+
+	// The checkpoint makes a repeated run skip what is done, but failures are retried
+	report, err = sweeper.Sweep(ctx, opts)
+	if err != nil || report.Resumed != 2 || report.Processed != 1 || report.Failed != 1 {
+		t.Errorf("resumed report = %+v, %v", report, err)
+	}
+}
+
+func TestSweepCheckpointPath(t *testing.T) {
+	dir := t.TempDir()
+	path := SweepCheckpointPath(dir, "/../../etc/passwd")
+	if name := filepath.Base(path); filepath.Dir(path) != dir || strings.Count(name, ".") != 1 || !strings.HasSuffix(name, ".json") {
+		t.Errorf("checkpoint path = %q, want a file directly in %q", path, dir)
+	}
+	if SweepCheckpointPath(dir, "a/b") == SweepCheckpointPath(dir, "a_b") {
+		t.Error("distinct prefixes share a checkpoint file")
+	}
+	if SweepCheckpointPath(dir, "raw/") != SweepCheckpointPath(dir, "raw") {
+		t.Error("a trailing slash changes the checkpoint file")
+	}
+
+	// Clients cannot choose the checkpoint file
+	var opts SweepOptions
+	if err := json.Unmarshal([]byte(`{"source_prefix": "raw", "checkpoint_path": "/tmp/x"}`), &opts); err != nil || opts.CheckpointPath != "" {
+		t.Errorf("decoded options = %+v, %v", opts, err)
+	}
+}
+
+func TestSweepKeepsBoundedFailures(t *testing.T) {
+	po, fake := newTestPipeline(t, 0)
+	ctx := context.Background()
+	storage := newTestStorage(fake, testBucket)
+	for i := range sweepFailureLimit + 5 {
+		if _, err := storage.UploadImage(ctx, "bad", fmt.Sprintf("%03d.png", i), []byte("not a png")); err != nil {
+			t.Fatalf("UploadImage: %v", err)
+		}
+	}
+
+	report, err := NewBucketSweeper(po, storage).Sweep(ctx, SweepOptions{SourcePrefix: "bad", Concurrency: 8})
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if report.Failed != sweepFailureLimit+5 || len(report.Failures) != sweepFailureLimit {
+		t.Errorf("failed = %d with %d kept, want %d with %d kept", report.Failed, len(report.Failures), sweepFailureLimit+5, sweepFailureLimit)
+	}
+}
+
+func TestSweepRefusesConcurrentRuns(t *testing.T) {
+	po, fake := newTestPipeline(t, 0)
+	sweeper := NewBucketSweeper(po, newTestStorage(fake, testBucket))
+
+	// Hold the sweeper as a running sweep would
+	_, running, err := sweeper.begin(SweepOptions{SourcePrefix: "first"})
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := sweeper.Start(SweepOptions{SourcePrefix: "second"}); !errors.Is(err, ErrSweepRunning) {
+		t.Errorf("Start during a sweep = %v, want ErrSweepRunning", err)
+	}
+	if _, err := sweeper.Sweep(context.Background(), SweepOptions{SourcePrefix: "second"}); !errors.Is(err, ErrSweepRunning) {
+		t.Errorf("Sweep during a sweep = %v, want ErrSweepRunning", err)
+	}
+
+	sweeper.finish(running, nil)
+	if _, err := sweeper.Sweep(context.Background(), SweepOptions{SourcePrefix: "second"}); err != nil {
+		t.Errorf("Sweep after the first finished: %v", err)
+	}
+	if _, err := sweeper.Start(SweepOptions{}); err == nil || errors.Is(err, ErrSweepRunning) {
+		t.Errorf("Start without a prefix = %v", err)
+	}
+}
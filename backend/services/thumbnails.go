@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Thumbnail size limits, as the longest edge in pixels
+const (
+	minThumbnailSize = 16
+	maxThumbnailSize = 2048
+)
+
+// ThumbnailSettings controls the previews generated for every stored image
+type ThumbnailSettings struct {
+	Sizes   []int  // longest edge of each thumbnail in pixels; none disables thumbnails
+	Format  string // webp (lossless) or jpeg
+	Quality int    // JPEG quality (1-100)
+}
+
+// Thumbnail is a stored preview of an image
+type Thumbnail struct {
+	Size int    `json:"size"`
+	Key  string `json:"key"`
+}
+
+// SetThumbnails validates and sets the thumbnail sizes and format. Sizes are
+// kept in ascending order.
+func (po *PipelineOrchestrator) SetThumbnails(settings ThumbnailSettings) error {
+	if settings.Format != FormatWebP && settings.Format != FormatJPEG {
+		return fmt.Errorf("thumbnail format must be %s or %s", FormatWebP, FormatJPEG)
+	}
+	if settings.Format == FormatJPEG && (settings.Quality < 1 || settings.Quality > 100) {
+		return fmt.Errorf("thumbnail JPEG quality must be between 1 and 100")
+	}
+
+	settings.Sizes = slices.Clone(settings.Sizes)
+	slices.Sort(settings.Sizes)
+	settings.Sizes = slices.Compact(settings.Sizes)
+	for _, size := range settings.Sizes {
+		if size < minThumbnailSize || size > maxThumbnailSize {
+			return fmt.Errorf("thumbnail size %d must be between %d and %d", size, minThumbnailSize, maxThumbnailSize)
+		}
+	}
+
+	po.thumbnails = settings
+	return nil
+}
+
+// Thumbnails returns the thumbnail settings
+func (po *PipelineOrchestrator) Thumbnails() ThumbnailSettings {
+	return po.thumbnails
+}
+
+// ThumbnailKey returns the bucket key of the thumbnail of a stored image:
+// "<size>/<folder>/<object key>" in the thumbnails folder, with the tenant and
+// date partitions of the image
+func (po *PipelineOrchestrator) ThumbnailKey(sourceKey string, size int) (string, error) {
+	parsed, ok := po.layout.Parse(sourceKey)
+	if !ok {
+		return "", fmt.Errorf("key %q does not fit the folder layout", sourceKey)
+	}
+	objectKey := strconv.Itoa(size) + "/" + parsed.Folder + "/" + withExtension(parsed.ObjectKey, po.thumbnails.Format)
+	return po.layout.Key(FolderThumbnails, parsed.Tenant, parsed.Date, objectKey), nil
+}
+
+// GenerateThumbnails renders and stores a thumbnail of a stored image at every
+// configured size. Images are only ever scaled down.
+func (po *PipelineOrchestrator) GenerateThumbnails(ctx context.Context, sourceKey string, data []byte, jobID string) ([]Thumbnail, error) {
+	if len(po.thumbnails.Sizes) == 0 {
+		return nil, nil
+	}
+
+	img, _, err := decodeImage(data, po.qualityService.MaxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", sourceKey, err)
+	}
+
+	metadata := map[string]string{
+		MetadataSourceKey:       url.PathEscape(sourceKey),
+		MetadataPipelineVersion: PipelineVersion,
+	}
+	if jobID != "" {
+		metadata[MetadataJobID] = jobID
+	}
+
+	thumbnails := make([]Thumbnail, 0, len(po.thumbnails.Sizes))
+	for _, size := range po.thumbnails.Sizes {
+		key, err := po.ThumbnailKey(sourceKey, size)
+		if err != nil {
+			return thumbnails, err
+		}
+		encoded, err := encodeDecoded(fitWithin(img, size), po.thumbnails.Format, po.thumbnails.Quality)
+		if err != nil {
+			return thumbnails, err
+		}
+		if _, err := po.uploadWithRetry(ctx, key, encoded, metadata); err != nil {
+			return thumbnails, err
+		}
+		thumbnails = append(thumbnails, Thumbnail{Size: size, Key: key})
+	}
+	return thumbnails, nil
+}
+
+// Thumbnail returns the thumbnail of a stored image at one of the configured
+// sizes, generating the thumbnails first if they are missing
+func (po *PipelineOrchestrator) Thumbnail(ctx context.Context, sourceKey string, size int) ([]byte, error) {
+	if !slices.Contains(po.thumbnails.Sizes, size) {
+		return nil, fmt.Errorf("thumbnail size %d is not configured", size)
+	}
+	key, err := po.ThumbnailKey(sourceKey, size)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := po.storageService.DownloadObject(ctx, key)
+	var missing *types.NoSuchKey
+	if !errors.As(err, &missing) {
+		return data, err
+	}
+
+	source, err := po.storageService.DownloadObject(ctx, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := po.GenerateThumbnails(ctx, sourceKey, source, ""); err != nil {
+		return nil, err
+	}
+	return po.storageService.DownloadObject(ctx, key)
+}
+
+// storeThumbnails generates the thumbnails of an image the pipeline just
+// stored, as part of the upload stage. Thumbnails are best effort: a failure
+// is logged and does not fail the job, and the thumbnail endpoint or a
+// backfill creates them later.
+func (po *PipelineOrchestrator) storeThumbnails(ctx context.Context, result *ProcessingResult, data []byte) {
+	if len(po.thumbnails.Sizes) == 0 || result.StorageKey == "" {
+		return
+	}
+	if _, err := po.GenerateThumbnails(ctx, result.StorageKey, data, result.JobID); err != nil {
+		log.Printf("Warning: failed to generate thumbnails for job %s: %v", result.JobID, err)
+	}
+}
+
+// fitWithin scales an image down so its longest edge is at most size
+func fitWithin(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+	return resizeTo(img, width, height)
+}
+
+// ThumbnailBackfillOptions controls a backfill of thumbnails for images stored
+// before thumbnails were enabled
+type ThumbnailBackfillOptions struct {
+	Folders     []string // logical folders to cover; empty means good_quality, upscaled and couldn't_upscale
+	DryRun      bool
+	Force       bool // regenerate thumbnails that already exist, e.g. after changing the format
+	Concurrency int
+}
+
+// ThumbnailBackfillReport summarizes a thumbnail backfill
+type ThumbnailBackfillReport struct {
+	DryRun    bool               `json:"dry_run"`
+	Listed    int                `json:"listed"`
+	Complete  int                `json:"complete"` // already had every thumbnail
+	Generated int                `json:"generated"`
+	Failed    int                `json:"failed"`
+	Pending   []string           `json:"pending,omitempty"` // dry run: keys that would get thumbnails
+	Failures  []ThumbnailFailure `json:"failures,omitempty"`
+}
+
+// ThumbnailFailure is an image whose thumbnails could not be generated
+type ThumbnailFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// BackfillThumbnails generates the missing thumbnails of the images already in
+// the bucket. Images with every configured thumbnail are skipped, so a run can
+// simply be repeated.
+func (po *PipelineOrchestrator) BackfillThumbnails(ctx context.Context, opts ThumbnailBackfillOptions) (*ThumbnailBackfillReport, error) {
+	if len(po.thumbnails.Sizes) == 0 {
+		return nil, fmt.Errorf("no thumbnail sizes configured")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	folders := opts.Folders
+	if len(folders) == 0 {
+		folders = []string{FolderGoodQuality, FolderUpscaled, FolderCouldntUpscale}
+	}
+
+	objects, err := listFolders(ctx, po.storageService, po.layout, append(slices.Clone(folders), FolderThumbnails))
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool)
+	var sources []string
+	for _, obj := range objects {
+		parsed, ok := po.layout.Parse(obj.Key)
+		if !ok {
+			continue
+		}
+		if parsed.Folder == FolderThumbnails {
+			existing[obj.Key] = true
+		} else if slices.Contains(folders, parsed.Folder) {
+			sources = append(sources, obj.Key)
+		}
+	}
+
+	report := &ThumbnailBackfillReport{DryRun: opts.DryRun, Listed: len(sources)}
+	var pending []string
+	for _, key := range sources {
+		if opts.Force || !po.hasThumbnails(key, existing) {
+			pending = append(pending, key)
+		} else {
+			report.Complete++
+		}
+	}
+
+	if opts.DryRun {
+		report.Pending = pending
+		return report, nil
+	}
+
+	var mu sync.Mutex
+	forEachConcurrently(ctx, opts.Concurrency, len(pending), func(i int) {
+		key := pending[i]
+		data, err := po.storageService.DownloadObject(ctx, key)
+		if err == nil {
+			_, err = po.GenerateThumbnails(ctx, key, data, "")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			report.Failed++
+			report.Failures = append(report.Failures, ThumbnailFailure{Key: key, Error: err.Error()})
+		} else {
+			report.Generated++
+		}
+	})
+
+	return report, ctx.Err()
+}
+
+// hasThumbnails reports whether every configured thumbnail of an image exists
+func (po *PipelineOrchestrator) hasThumbnails(sourceKey string, existing map[string]bool) bool {
+	for _, size := range po.thumbnails.Sizes {
+		key, err := po.ThumbnailKey(sourceKey, size)
+		if err != nil || !existing[key] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestThumbnails(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	if err := po.SetThumbnails(ThumbnailSettings{Sizes: []int{32, 16, 32}, Format: FormatWebP}); err != nil {
+		t.Fatalf("SetThumbnails: %v", err)
+	}
+	if sizes := po.Thumbnails().Sizes; !slices.Equal(sizes, []int{16, 32}) {
+		t.Errorf("sizes = %v, want [16 32]", sizes)
+	}
+
+	result := po.ProcessImageWithOptions(ctx, testPNG(t, 64, 48), "thumb.png", ProcessOptions{})
+	if result.Status != "success" || result.StorageKey != "upscaled/thumb.png" {
+		t.Fatalf("result = %+v", result)
+	}
+
+	// The 128x96 upscaled image is scaled to fit each size
+	for size, want := range map[int]image.Point{16: {16, 12}, 32: {32, 24}} {
+		key, err := po.ThumbnailKey(result.StorageKey, size)
+		if err != nil {
+			t.Fatalf("ThumbnailKey: %v", err)
+		}
+		obj := fake.object(key)
+		if obj == nil {
+			t.Fatalf("no thumbnail at %s; bucket has %v", key, fake.keys())
+		}
+		if obj.contentType != "image/webp" || obj.metadata[MetadataSourceKey] != "upscaled%2Fthumb.png" || obj.metadata[MetadataJobID] != result.JobID {
+			t.Errorf("thumbnail stored with Content-Type %q, metadata %v", obj.contentType, obj.metadata)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(obj.data))
+		if err != nil || format != "webp" || cfg.Width != want.X || cfg.Height != want.Y {
+			t.Errorf("%d px thumbnail is %s %dx%d (%v), want webp %v", size, format, cfg.Width, cfg.Height, err, want)
+		}
+	}
+	if key, _ := po.ThumbnailKey(result.StorageKey, 16); key != "thumbnails/16/upscaled/thumb.webp" {
+		t.Errorf("ThumbnailKey = %q", key)
+	}
+
+	// A missing thumbnail is generated when it is requested
+	key, _ := po.ThumbnailKey(result.StorageKey, 32)
+	if err := po.Storage().DeleteObject(ctx, key); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	data, err := po.Thumbnail(ctx, result.StorageKey, 32)
+	if err != nil || imageFormat(data) != "webp" {
+		t.Fatalf("Thumbnail = %d bytes of %q, %v", len(data), imageFormat(data), err)
+	}
+	if fake.object(key) == nil {
+		t.Error("regenerated thumbnail was not stored")
+	}
+	if _, err := po.Thumbnail(ctx, result.StorageKey, 64); err == nil {
+		t.Error("Thumbnail accepted a size that is not configured")
+	}
+}
+
+func TestBackfillThumbnails(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	ctx := context.Background()
+	if err := po.SetThumbnails(ThumbnailSettings{Sizes: []int{16}, Format: FormatJPEG, Quality: 80}); err != nil {
+		t.Fatalf("SetThumbnails: %v", err)
+	}
+
+	// Images stored before thumbnails were enabled
+	for _, key := range []string{"good_quality/old.png", "upscaled/older.png", "originals/source.png"} {
+		if _, err := po.Storage().UploadObject(ctx, key, testPNG(t, 40, 40), nil); err != nil {
+			t.Fatalf("UploadObject: %v", err)
+		}
+	}
+
+	report, err := po.BackfillThumbnails(ctx, ThumbnailBackfillOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !slices.Equal(report.Pending, []string{"good_quality/old.png", "upscaled/older.png"}) || report.Generated != 0 {
+		t.Errorf("dry run report = %+v", report)
+	}
+	if fake.object("thumbnails/16/good_quality/old.jpg") != nil {
+		t.Error("dry run generated a thumbnail")
+	}
+
+	report, err = po.BackfillThumbnails(ctx, ThumbnailBackfillOptions{})
+	if err != nil || report.Generated != 2 || report.Failed != 0 {
+		t.Fatalf("backfill report = %+v, %v", report, err)
+	}
+	obj := fake.object("thumbnails/16/good_quality/old.jpg")
+	if obj == nil || imageFormat(obj.data) != "jpeg" {
+		t.Fatalf("no JPEG thumbnail; bucket has %v", fake.keys())
+	}
+
+	// A repeated run finds nothing to do
+	report, err = po.BackfillThumbnails(ctx, ThumbnailBackfillOptions{})
+	if err != nil || report.Complete != 2 || report.Generated != 0 {
+		t.Errorf("second backfill report = %+v, %v", report, err)
+	}
+}
+
+func TestThumbnailSettingsValidation(t *testing.T) {
+	po, _ := newTestPipeline(t, 0.5)
+	invalid := []ThumbnailSettings{
+		{Sizes: []int{256}, Format: FormatPNG},
+		{Sizes: []int{256}, Format: FormatJPEG, Quality: 0},
+		{Sizes: []int{8}, Format: FormatWebP},
+		{Sizes: []int{4096}, Format: FormatWebP},
+	}
+	for _, settings := range invalid {
+		if err := po.SetThumbnails(settings); err == nil {
+			t.Errorf("SetThumbnails(%+v) succeeded", settings)
+		}
+	}
+	if err := po.SetThumbnails(ThumbnailSettings{Format: FormatWebP}); err != nil {
+		t.Errorf("disabling thumbnails: %v", err)
+	}
+}
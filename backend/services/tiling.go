@@ -0,0 +1,242 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+)
+
+// TileOptions configures tiled upscaling of large images
+type TileOptions struct {
+	TileSize        int // tile edge in input pixels; 0 disables tiling
+	Overlap         int // overlap between neighbouring tiles in input pixels
+	ThresholdPixels int // images with more pixels than this are tiled
+	Concurrency     int // tiles upscaled in parallel
+}
+
+// TiledUpscaler splits large images into overlapping tiles, upscales the tiles
+// independently with the wrapped strategy and blends them back together with
+// feathered seams. Small images are passed straight through, so the upscaler
+// process never has to hold a huge image in memory.
+type TiledUpscaler struct {
+	inner Upscaler
+	opts  TileOptions
+}
+
+// NewTiledUpscaler wraps inner with tiling for images above the threshold
+func NewTiledUpscaler(inner Upscaler, opts TileOptions) *TiledUpscaler {
+	if opts.Overlap < 0 {
+		opts.Overlap = 0
+	}
+	// Keep at least half of every tile unique so the grid always advances
+	if opts.Overlap > opts.TileSize/2 {
+		opts.Overlap = opts.TileSize / 2
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &TiledUpscaler{
+		inner: inner,
+		opts:  opts,
+	}
+}
+
+// Name returns the wrapped strategy's name
+func (tu *TiledUpscaler) Name() string {
+	return tu.inner.Name()
+}
+
+// Upscale tiles the image if it is large enough, otherwise delegates directly
+func (tu *TiledUpscaler) Upscale(ctx context.Context, imageData []byte, scale int) ([]byte, error) {
+	if tu.opts.TileSize <= 0 {
+		return tu.inner.Upscale(ctx, imageData, scale)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("failed to decode image: %w", err))
+	}
+	if cfg.Width*cfg.Height <= tu.opts.ThresholdPixels ||
+		(cfg.Width <= tu.opts.TileSize && cfg.Height <= tu.opts.TileSize) {
+		return tu.inner.Upscale(ctx, imageData, scale)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("failed to decode image: %w", err))
+	}
+
+	return tu.upscaleTiles(ctx, src, scale)
+}
+
+// upscaleDecoded is Upscale for an image the pipeline already decoded
+func (tu *TiledUpscaler) upscaleDecoded(ctx context.Context, imageData []byte, img image.Image, scale int) ([]byte, error) {
+	bounds := img.Bounds()
+	if tu.opts.TileSize <= 0 || bounds.Dx()*bounds.Dy() <= tu.opts.ThresholdPixels ||
+		(bounds.Dx() <= tu.opts.TileSize && bounds.Dy() <= tu.opts.TileSize) {
+		return upscaleImage(ctx, tu.inner, imageData, img, scale)
+	}
+	return tu.upscaleTiles(ctx, img, scale)
+}
+
+func (tu *TiledUpscaler) upscaleTiles(ctx context.Context, src image.Image, scale int) ([]byte, error) {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	xStarts := tileStarts(width, tu.opts.TileSize, tu.opts.Overlap)
+	yStarts := tileStarts(height, tu.opts.TileSize, tu.opts.Overlap)
+	xWeights := axisWeights(xStarts, width, tu.opts.TileSize, tu.opts.Overlap, scale)
+	yWeights := axisWeights(yStarts, height, tu.opts.TileSize, tu.opts.Overlap, scale)
+
+	// Overlapping tiles are summed at full precision and rounded once at the end
+	dst := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+	sum := make([]float32, len(dst.Pix))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	tileCount := len(xStarts) * len(yStarts)
+	forEachConcurrently(ctx, tu.opts.Concurrency, tileCount, func(i int) {
+		col, row := i%len(xStarts), i/len(xStarts)
+		x0, y0 := xStarts[col], yStarts[row]
+		rect := image.Rect(x0, y0, min(x0+tu.opts.TileSize, width), min(y0+tu.opts.TileSize, height)).Add(bounds.Min)
+
+		tile, err := tu.upscaleTile(ctx, src, rect, scale)
+		if err == nil {
+			mu.Lock()
+			blendTile(sum, dst.Stride, tile, x0*scale, y0*scale, xWeights[col], yWeights[row])
+			mu.Unlock()
+			return
+		}
+
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = fmt.Errorf("tile %d/%d at (%d,%d): %w", i+1, tileCount, x0, y0, err)
+			cancel()
+		}
+		mu.Unlock()
+	})
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, v := range sum {
+		dst.Pix[i] = uint8(min(v+0.5, 255))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("failed to encode upscaled image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// upscaleTile runs one tile through the wrapped upscaler and returns it as RGBA
+func (tu *TiledUpscaler) upscaleTile(ctx context.Context, src image.Image, rect image.Rectangle, scale int) (*image.RGBA, error) {
+	tile := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(tile, tile.Bounds(), src, rect.Min, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tile); err != nil {
+		return nil, fmt.Errorf("failed to encode tile: %w", err)
+	}
+
+	upscaledData, err := tu.inner.Upscale(ctx, buf.Bytes(), scale)
+	if err != nil {
+		return nil, err
+	}
+
+	upscaled, _, err := image.Decode(bytes.NewReader(upscaledData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode upscaled tile: %w", err)
+	}
+	if got, want := upscaled.Bounds().Size(), rect.Size().Mul(scale); got != want {
+		return nil, fmt.Errorf("upscaled tile is %v, expected %v", got, want)
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, upscaled.Bounds().Dx(), upscaled.Bounds().Dy()))
+	draw.Draw(out, out.Bounds(), upscaled, upscaled.Bounds().Min, draw.Src)
+	return out, nil
+}
+
+// tileStarts spreads tiles evenly over size so that neighbours overlap by at
+// least overlap pixels and the last tile ends exactly at the image edge
+func tileStarts(size, tileSize, overlap int) []int {
+	if size <= tileSize {
+		return []int{0}
+	}
+
+	step := tileSize - overlap
+	n := int(math.Ceil(float64(size-overlap) / float64(step)))
+	starts := make([]int, n)
+	for i := range starts {
+		starts[i] = int(math.Round(float64(i) * float64(size-tileSize) / float64(n-1)))
+	}
+	return starts
+}
+
+// axisWeights returns, for every tile along one axis, the blend weight of each
+// output pixel it covers. Weights ramp linearly across the overlap and are
+// normalized so that they sum to one at every pixel; the 2D weight of a tile is
+// the product of its column and row weights, which therefore also sums to one.
+func axisWeights(starts []int, size, tileSize, overlap, scale int) [][]float32 {
+	ramp := float64(overlap * scale)
+	total := make([]float64, size*scale)
+	raw := make([][]float64, len(starts))
+
+	for i, start := range starts {
+		lo, hi := start*scale, min(start+tileSize, size)*scale
+		raw[i] = make([]float64, hi-lo)
+		for p := lo; p < hi; p++ {
+			w := 1.0
+			if ramp > 0 && start > 0 {
+				w = math.Min(w, (float64(p-lo)+0.5)/ramp)
+			}
+			if ramp > 0 && hi < size*scale {
+				w = math.Min(w, (float64(hi-p)-0.5)/ramp)
+			}
+			raw[i][p-lo] = w
+			total[p] += w
+		}
+	}
+
+	weights := make([][]float32, len(starts))
+	for i, start := range starts {
+		lo := start * scale
+		weights[i] = make([]float32, len(raw[i]))
+		for j, w := range raw[i] {
+			weights[i][j] = float32(w / total[lo+j])
+		}
+	}
+	return weights
+}
+
+// blendTile adds a weighted tile into sum, an RGBA buffer with the given
+// stride, at (ox, oy)
+func blendTile(sum []float32, stride int, tile *image.RGBA, ox, oy int, xWeights, yWeights []float32) {
+	size := tile.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		wy := yWeights[y]
+		srcRow := tile.Pix[y*tile.Stride:]
+		dstRow := sum[(oy+y)*stride+ox*4:]
+		for x := 0; x < size.X; x++ {
+			w := wy * xWeights[x]
+			for c := 0; c < 4; c++ {
+				dstRow[x*4+c] += w * float32(srcRow[x*4+c])
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestTileStarts(t *testing.T) {
+	tests := []struct {
+		size, tileSize, overlap int
+	}{
+		{100, 100, 16},
+		{50, 100, 16},
+		{101, 100, 16},
+		{1000, 256, 32},
+		{1000, 256, 0},
+		{513, 256, 128},
+	}
+	for _, tt := range tests {
+		starts := tileStarts(tt.size, tt.tileSize, tt.overlap)
+		if starts[0] != 0 {
+			t.Errorf("tileStarts(%d, %d, %d) = %v, first tile does not start at 0", tt.size, tt.tileSize, tt.overlap, starts)
+		}
+		if tt.size <= tt.tileSize {
+			if len(starts) != 1 {
+				t.Errorf("tileStarts(%d, %d, %d) = %v, want a single tile", tt.size, tt.tileSize, tt.overlap, starts)
+			}
+			continue
+		}
+		if last := starts[len(starts)-1]; last+tt.tileSize != tt.size {
+			t.Errorf("tileStarts(%d, %d, %d) = %v, last tile ends at %d", tt.size, tt.tileSize, tt.overlap, starts, last+tt.tileSize)
+		}
+		for i := 1; i < len(starts); i++ {
+			if overlap := starts[i-1] + tt.tileSize - starts[i]; overlap < tt.overlap {
+				t.Errorf("tileStarts(%d, %d, %d) = %v, tiles %d and %d overlap by %d", tt.size, tt.tileSize, tt.overlap, starts, i-1, i, overlap)
+			}
+		}
+	}
+}
+
+func TestAxisWeights(t *testing.T) {
+	const size, tileSize, overlap, scale = 1000, 256, 32, 2
+	starts := tileStarts(size, tileSize, overlap)
+	weights := axisWeights(starts, size, tileSize, overlap, scale)
+
+	sums := make([]float64, size*scale)
+	for i, start := range starts {
+		if want := (min(start+tileSize, size) - start) * scale; len(weights[i]) != want {
+			t.Fatalf("tile %d has %d weights, want %d", i, len(weights[i]), want)
+		}
+		for j, w := range weights[i] {
+			if w < 0 || w > 1 {
+				t.Errorf("tile %d weight %d = %v", i, j, w)
+			}
+			sums[start*scale+j] += float64(w)
+		}
+	}
+	for p, sum := range sums {
+		if math.Abs(sum-1) > 1e-5 {
+			t.Fatalf("weights at output pixel %d sum to %v", p, sum)
+		}
+	}
+
+	// Away from the overlaps a tile has full weight
+	if weights[0][0] != 1 || weights[len(weights)-1][len(weights[len(weights)-1])-1] != 1 {
+		t.Error("image edges are not fully weighted")
+	}
+}
+
+func TestTiledUpscalerBlendsSeamlessly(t *testing.T) {
+	// A flat image must stay flat: any seam shows up as a changed pixel
+	src := image.NewRGBA(image.Rect(0, 0, 90, 70))
+	fill := color.RGBA{120, 60, 200, 255}
+	for y := range 70 {
+		for x := range 90 {
+			src.SetRGBA(x, y, fill)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	tu := NewTiledUpscaler(NewBicubicUpscaler(), TileOptions{TileSize: 32, Overlap: 8, Concurrency: 4})
+	for name, upscale := range map[string]func() ([]byte, error){
+		"encoded": func() ([]byte, error) { return tu.Upscale(context.Background(), buf.Bytes(), 2) },
+		"decoded": func() ([]byte, error) { return tu.upscaleDecoded(context.Background(), buf.Bytes(), src, 2) },
+	} {
+		data, err := upscale()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		out, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decoding output: %v", name, err)
+		}
+		if size := out.Bounds().Size(); size != image.Pt(180, 140) {
+			t.Fatalf("%s: output is %v, want 180x140", name, size)
+		}
+		for y := range 140 {
+			for x := range 180 {
+				c := color.RGBAModel.Convert(out.At(x, y)).(color.RGBA)
+				if c != fill {
+					t.Fatalf("%s: pixel (%d,%d) = %v, want %v", name, x, y, c, fill)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package services
+
+import "time"
+
+// ParseTimeBound parses a time range bound given as an RFC3339 timestamp or a
+// plain YYYY-MM-DD date. A date covers the whole day: as a lower bound it means
+// midnight, as an upper bound (upper set) the last instant of that day. An
+// empty value returns the zero time, meaning unbounded.
+func ParseTimeBound(value string, upper bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return day, nil
+}
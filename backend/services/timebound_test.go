@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	tests := []struct {
+		value string
+		upper bool
+		want  time.Time
+	}{
+		{"", false, time.Time{}},
+		{"", true, time.Time{}},
+		{"2026-03-01", false, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2026-03-01", true, time.Date(2026, 3, 1, 23, 59, 59, 999999999, time.UTC)},
+		{"2026-03-01T12:00:00Z", true, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeBound(tt.value, tt.upper)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTimeBound(%q, %v) = %v, %v; want %v", tt.value, tt.upper, got, err, tt.want)
+		}
+	}
+	if _, err := ParseTimeBound("yesterday", false); err == nil {
+		t.Error("ParseTimeBound accepted an invalid value")
+	}
+}
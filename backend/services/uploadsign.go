@@ -0,0 +1,100 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// UploadPathPrefix is where the server accepts uploads signed by an UploadSigner
+const UploadPathPrefix = "/api/uploads/"
+
+// UploadSigner issues upload URLs served by this backend instead of S3, for
+// stores clients cannot reach or that do not support pre-signing. The URL
+// carries the key, expiry and expected size and checksum, authenticated with
+// an HMAC, so the server keeps no state between signing and upload.
+type UploadSigner struct {
+	secret []byte
+	expiry time.Duration
+}
+
+// NewUploadSigner creates a signer. Without a secret a random one is used, so
+// URLs stop working when the server restarts.
+func NewUploadSigner(secret string, expiry time.Duration) *UploadSigner {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	if expiry <= 0 {
+		expiry = DefaultUploadURLExpiry
+	}
+	return &UploadSigner{secret: key, expiry: expiry}
+}
+
+// Sign returns a PUT URL, relative to the server, for uploading to key
+func (us *UploadSigner) Sign(key string, metadata map[string]string) *PresignedURL {
+	expiresAt := time.Now().Add(us.expiry).UTC()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	for name, value := range metadata {
+		query.Set(name, value)
+	}
+	query.Set("signature", us.signature(key, query))
+
+	target := url.URL{Path: UploadPathPrefix + key, RawQuery: query.Encode()}
+	return &PresignedURL{
+		URL:       target.String(),
+		Method:    "PUT",
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Verify checks the signature and expiry of an upload URL's query and returns
+// the metadata to store with the object
+func (us *UploadSigner) Verify(key string, query url.Values) (map[string]string, error) {
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, us.mac(key, query)) {
+		return nil, fmt.Errorf("invalid upload signature")
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, fmt.Errorf("upload URL has expired")
+	}
+
+	metadata := make(map[string]string)
+	for name := range query {
+		if name != "signature" && name != "expires" {
+			metadata[name] = query.Get(name)
+		}
+	}
+	return metadata, nil
+}
+
+// signature returns the hex HMAC-SHA256 of the key and query
+func (us *UploadSigner) signature(key string, query url.Values) string {
+	return hex.EncodeToString(us.mac(key, query))
+}
+
+// mac authenticates the key and every query parameter except the signature.
+// url.Values.Encode sorts by name, so the result does not depend on order.
+func (us *UploadSigner) mac(key string, query url.Values) []byte {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != "signature" {
+			signed[name] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, us.secret)
+	mac.Write([]byte(key))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(signed.Encode()))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadSigner(t *testing.T) {
+	signer := NewUploadSigner("secret", time.Minute)
+	key := "processing/job-1/cat.png"
+	signed := signer.Sign(key, map[string]string{MetadataJobID: "job-1", MetadataExpectedSize: "42"})
+	if signed.Method != "PUT" || !strings.HasPrefix(signed.URL, UploadPathPrefix+key+"?") {
+		t.Fatalf("signed URL = %+v", signed)
+	}
+	target, err := url.Parse(signed.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	metadata, err := signer.Verify(key, target.Query())
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(metadata) != 2 || metadata[MetadataJobID] != "job-1" || metadata[MetadataExpectedSize] != "42" {
+		t.Errorf("metadata = %v", metadata)
+	}
+
+	tampered := target.Query()
+	tampered.Set(MetadataExpectedSize, "4200")
+	if _, err := signer.Verify(key, tampered); err == nil {
+		t.Error("Verify accepted a changed expected size")
+	}
+	if _, err := signer.Verify("processing/job-2/cat.png", target.Query()); err == nil {
+		t.Error("Verify accepted the signature for another key")
+	}
+	if _, err := NewUploadSigner("other", time.Minute).Verify(key, target.Query()); err == nil {
+		t.Error("Verify accepted a signature made with another secret")
+	}
+
+	// The expiry is signed too, so an expired URL cannot be extended
+	expired := NewUploadSigner("secret", time.Nanosecond).Sign(key, nil)
+	time.Sleep(1100 * time.Millisecond)
+	target, _ = url.Parse(expired.URL)
+	if _, err := signer.Verify(key, target.Query()); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Verify of an expired URL = %v", err)
+	}
+}
+
+func TestProcessStagedObjectChecksSizeFirst(t *testing.T) {
+	po, fake := newTestPipeline(t, 0.5)
+	po.SetUploadSigner(NewUploadSigner("secret", time.Minute))
+	ctx := context.Background()
+	original := testPNG(t, 32, 32)
+
+	upload, err := po.PresignUpload(ctx, DirectUploadRequest{Filename: "short.png", Size: int64(len(original)) + 1})
+	if err != nil {
+		t.Fatalf("PresignUpload: %v", err)
+	}
+	if !strings.HasPrefix(upload.Upload.URL, UploadPathPrefix+upload.Key+"?") {
+		t.Fatalf("upload URL = %q, want one served by the backend", upload.Upload.URL)
+	}
+
+	// The server stores the upload with the metadata signed into its URL
+	target, _ := url.Parse(upload.Upload.URL)
+	metadata, err := po.UploadSigner().Verify(upload.Key, target.Query())
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if _, err := po.Storage().UploadObject(ctx, upload.Key, original, metadata); err != nil {
+		t.Fatalf("UploadObject: %v", err)
+	}
+
+	requests := len(fake.requests)
+	if _, err := po.ProcessStagedObject(ctx, upload.Key, ProcessOptions{}); !errors.Is(err, ErrUploadMismatch) {
+		t.Fatalf("ProcessStagedObject = %v, want ErrUploadMismatch", err)
+	}
+	for _, request := range fake.requests[requests:] {
+		if strings.HasPrefix(request, "GET ") {
+			t.Errorf("object was downloaded despite the wrong size: %s", request)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// IDs of the built-in models, usable in the fallback chain
+const (
+	StrategyScript  = "script"
+	StrategyBicubic = "bicubic"
+)
+
+// Upscaler is a single upscaling strategy
+type Upscaler interface {
+	// Name identifies the strategy in ProcessingResult and configuration
+	Name() string
+	// Upscale returns the image enlarged by scale. Errors wrapped with Permanent
+	// are not retried.
+	Upscale(ctx context.Context, imageData []byte, scale int) ([]byte, error)
+}
+
+// decodedUpscaler is implemented by upscalers that can work from an image the
+// pipeline already decoded, rather than decoding imageData again
+type decodedUpscaler interface {
+	upscaleDecoded(ctx context.Context, imageData []byte, img image.Image, scale int) ([]byte, error)
+}
+
+// upscaleImage runs upscaler on imageData, handing it the decoded img when
+// it can use it. img may be nil.
+func upscaleImage(ctx context.Context, upscaler Upscaler, imageData []byte, img image.Image, scale int) ([]byte, error) {
+	if du, ok := upscaler.(decodedUpscaler); ok && img != nil {
+		return du.upscaleDecoded(ctx, imageData, img, scale)
+	}
+	return upscaler.Upscale(ctx, imageData, scale)
+}
+
+// ScriptUpscaler runs the Python upscaling script via subprocess
+type ScriptUpscaler struct {
+	script  string
+	args    []string
+	tempDir string
+}
+
+// NewScriptUpscaler creates an upscaler backed by a Python script. Extra args are
+// passed after the standard --input/--output/--scale arguments.
+func NewScriptUpscaler(script string, args ...string) *ScriptUpscaler {
+	return &ScriptUpscaler{
+		script:  script,
+		args:    args,
+		tempDir: filepath.Join(os.TempDir(), "visioncloud"),
+	}
+}
+
+// Name returns the strategy name
+func (su *ScriptUpscaler) Name() string {
+	return StrategyScript
+}
+
+// Upscale calls the Python upscaling script via subprocess
+func (su *ScriptUpscaler) Upscale(ctx context.Context, imageData []byte, scale int) ([]byte, error) {
+	// Create temporary directory if it doesn't exist
+	if err := os.MkdirAll(su.tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	// Each call gets its own directory, so tiles upscaled in parallel never
+	// share input or output files
+	workDir, err := os.MkdirTemp(su.tempDir, "upscale-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	inputPath := filepath.Join(workDir, "input.png")
+	outputPath := filepath.Join(workDir, "output.png")
+
+	// Save image to temp file
+	if err := os.WriteFile(inputPath, imageData, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write temp image: %w", err)
+	}
+
+	// Call Python upscaling script directly
+	args := []string{su.script,
+		"--input", inputPath,
+		"--output", outputPath,
+		"--scale", strconv.Itoa(scale),
+	}
+	cmd := exec.CommandContext(ctx, "python", append(args, su.args...)...)
+
+	// Capture stderr for debugging
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Run the command with timeout
+	if err := cmd.Run(); err != nil {
+		return nil, classifyScriptError(ctx, fmt.Errorf("upscaling script failed: %w, stderr: %s", err, stderr.String()), stderr.String())
+	}
+
+	// Check if output file was created
+	if _, err := os.Stat(outputPath); err != nil {
+		return nil, fmt.Errorf("upscaled image not created: %w", err)
+	}
+
+	// Read the upscaled image
+	upscaledData, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upscaled image: %w", err)
+	}
+
+	return upscaledData, nil
+}
+
+// classifyScriptError marks script failures that will not go away on retry as
+// permanent. Timeouts, the OOM killer and memory errors are left retryable.
+func classifyScriptError(ctx context.Context, err error, stderr string) error {
+	if ctx.Err() != nil {
+		return err
+	}
+
+	lower := strings.ToLower(stderr)
+	for _, marker := range []string{"could not read image", "input file not found", "scale must be"} {
+		if strings.Contains(lower, marker) {
+			return Permanent(err)
+		}
+	}
+	return err
+}
+
+// BicubicUpscaler resizes images in-process, without the Python toolchain
+type BicubicUpscaler struct{}
+
+// NewBicubicUpscaler creates an in-process bicubic upscaler
+func NewBicubicUpscaler() *BicubicUpscaler {
+	return &BicubicUpscaler{}
+}
+
+// Name returns the strategy name
+func (bu *BicubicUpscaler) Name() string {
+	return StrategyBicubic
+}
+
+// Upscale resizes the image with Catmull-Rom (bicubic) interpolation and encodes it as PNG
+func (bu *BicubicUpscaler) Upscale(ctx context.Context, imageData []byte, scale int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("failed to decode image: %w", err))
+	}
+	return bu.upscaleDecoded(ctx, imageData, src, scale)
+}
+
+// upscaleDecoded resizes an already decoded image
+func (bu *BicubicUpscaler) upscaleDecoded(ctx context.Context, imageData []byte, src image.Image, scale int) ([]byte, error) {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("failed to encode upscaled image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// fakePython puts a "python" on PATH that copies --input to --output after a
+// short pause, so concurrent calls overlap
+func fakePython(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake interpreter is a shell script")
+	}
+	bin := t.TempDir()
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--input) in="$2"; shift ;;
+	--output) out="$2"; shift ;;
+	esac
+	shift
+done
+sleep 0.05
+cp "$in" "$out"
+`
+	if err := os.WriteFile(filepath.Join(bin, "python"), []byte(script), 0755); err != nil {
+		t.Fatalf("writing fake python: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestScriptUpscalerConcurrentCalls(t *testing.T) {
+	fakePython(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	su := NewScriptUpscaler("upscale.py")
+
+	// Every call must get back its own input, even when started together
+	const calls = 8
+	var wg sync.WaitGroup
+	errs := make([]error, calls)
+	for i := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			input := []byte(fmt.Sprintf("image %d", i))
+			output, err := su.Upscale(context.Background(), input, 2)
+			if err == nil && !bytes.Equal(output, input) {
+				err = fmt.Errorf("got %q", output)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+
+	// Temporary files are removed
+	entries, err := os.ReadDir(su.tempDir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("temp directory holds %d entries (%v)", len(entries), err)
+	}
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Webhook request headers
+const (
+	WebhookSignatureHeader = "X-VisionCloud-Signature"
+	WebhookTimestampHeader = "X-VisionCloud-Timestamp"
+	WebhookDeliveryHeader  = "X-VisionCloud-Delivery"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var historyDeliveriesBucket = []byte("webhook_deliveries") // delivery ID -> WebhookDelivery JSON
+
+// WebhookPayload is the JSON body POSTed to callback URLs
+type WebhookPayload struct {
+	Event  string            `json:"event"`
+	Result *ProcessingResult `json:"result"`
+}
+
+// WebhookDelivery records a single webhook and its delivery attempts
+type WebhookDelivery struct {
+	ID           string          `json:"id"`
+	JobID        string          `json:"job_id"`
+	URL          string          `json:"url"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	DeliveredAt  time.Time       `json:"delivered_at,omitempty"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+// WebhookNotifier POSTs signed processing results to client callback URLs
+type WebhookNotifier struct {
+	secret         string
+	tenantWebhooks map[string]string // tenant -> callback URL
+	allowedHosts   []string          // callback hosts clients may use; empty allows any public host
+	retry          RetryPolicy
+	client         *http.Client
+	history        *HistoryStore
+}
+
+// NewWebhookNotifier creates a notifier that signs payloads with secret and logs
+// deliveries to the history store
+func NewWebhookNotifier(secret string, tenantWebhooks map[string]string, retry RetryPolicy, history *HistoryStore) *WebhookNotifier {
+	wn := &WebhookNotifier{
+		secret:         secret,
+		tenantWebhooks: tenantWebhooks,
+		retry:          retry,
+		history:        history,
+	}
+	wn.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{DialContext: wn.dialContext},
+		// A redirect could point a public callback at an internal host
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return wn
+}
+
+// SetAllowedHosts restricts client-supplied callback URLs to these hosts. An
+// entry "*.example.com" allows any subdomain. Allowed hosts and the hosts of
+// tenant webhooks are configured by the operator, so they may be internal;
+// any other callback may only reach public addresses.
+func (wn *WebhookNotifier) SetAllowedHosts(hosts []string) {
+	wn.allowedHosts = nil
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			wn.allowedHosts = append(wn.allowedHosts, host)
+		}
+	}
+}
+
+// ValidateCallbackURL checks that a client-supplied callback URL is an absolute
+// http or https URL
+func ValidateCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// ValidateCallback checks that the server may deliver to a client-supplied
+// callback URL: its host must be allowed, or resolve to public addresses only.
+// Deliveries check the addresses again when connecting.
+func (wn *WebhookNotifier) ValidateCallback(ctx context.Context, callbackURL string) error {
+	if err := ValidateCallbackURL(callbackURL); err != nil {
+		return err
+	}
+	u, _ := url.Parse(callbackURL)
+	host := strings.ToLower(u.Hostname())
+
+	if len(wn.allowedHosts) > 0 {
+		if !wn.allowedHost(host) {
+			return fmt.Errorf("callback host %q is not allowed", host)
+		}
+		return nil
+	}
+	if wn.trustedHost(host) {
+		return nil
+	}
+	_, err := publicAddrs(ctx, host)
+	return err
+}
+
+// allowedHost reports whether host matches the allow-list
+func (wn *WebhookNotifier) allowedHost(host string) bool {
+	for _, allowed := range wn.allowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); (ok && strings.HasSuffix(host, suffix)) || host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedHost reports whether host was configured by the operator, either in
+// the allow-list or as a tenant webhook
+func (wn *WebhookNotifier) trustedHost(host string) bool {
+	if wn.allowedHost(host) {
+		return true
+	}
+	for _, webhook := range wn.tenantWebhooks {
+		if u, err := url.Parse(webhook); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext connects to trusted hosts as usual and to any other host only
+// on a public address, checked after resolution so DNS cannot point a
+// callback at an internal address later
+func (wn *WebhookNotifier) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if wn.trustedHost(host) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := publicAddrs(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].String(), port))
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddrs resolves host and fails unless every address is public, i.e.
+// not loopback, private, link-local (such as cloud metadata endpoints) or
+// otherwise reserved
+func publicAddrs(ctx context.Context, host string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve callback host %q: %w", host, err)
+		}
+		addrs = resolved
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("callback host %q has no addresses", host)
+	}
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if !addr.IsGlobalUnicast() || addr.IsPrivate() || sharedAddressSpace.Contains(addr) {
+			return nil, Permanent(fmt.Errorf("callback host %q resolves to non-public address %s", host, addr))
+		}
+	}
+	return addrs, nil
+}
+
+// Notify delivers result to callbackURL, or to the tenant's configured webhook if
+// callbackURL is empty. Delivery happens in the background.
+func (wn *WebhookNotifier) Notify(result *ProcessingResult, callbackURL string) {
+	if callbackURL == "" {
+		callbackURL = wn.tenantWebhooks[result.Tenant]
+	}
+	if callbackURL == "" {
+		return
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event:  "job.completed",
+		Result: result,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to encode webhook for job %s: %v", result.JobID, err)
+		return
+	}
+
+	delivery := &WebhookDelivery{
+		ID:        newJobID(),
+		JobID:     result.JobID,
+		URL:       callbackURL,
+		Status:    DeliveryPending,
+		CreatedAt: time.Now(),
+		Payload:   payload,
+	}
+	wn.saveDelivery(delivery)
+
+	go wn.deliver(context.Background(), delivery)
+}
+
+// Replay re-sends a logged delivery and waits for the outcome
+func (wn *WebhookNotifier) Replay(ctx context.Context, deliveryID string) (*WebhookDelivery, error) {
+	delivery, err := wn.history.GetDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if delivery == nil {
+		return nil, nil
+	}
+
+	wn.deliver(ctx, delivery)
+	return delivery, nil
+}
+
+// deliver POSTs the payload with retries and records the outcome
+func (wn *WebhookNotifier) deliver(ctx context.Context, delivery *WebhookDelivery) {
+	attempts, err := retryWithBackoff(ctx, wn.retry, func(ctx context.Context) error {
+		code, err := wn.post(ctx, delivery)
+		delivery.ResponseCode = code
+		return err
+	})
+
+	delivery.Attempts += attempts
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.LastError = err.Error()
+	} else {
+		delivery.Status = DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = time.Now()
+	}
+	wn.saveDelivery(delivery)
+}
+
+// post sends one signed request. Client errors other than 408 and 429 are permanent.
+func (wn *WebhookNotifier) post(ctx context.Context, delivery *WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(wn.secret, timestamp, delivery.Payload))
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, nil
+	}
+
+	err = fmt.Errorf("callback responded with %s", resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return resp.StatusCode, Permanent(err)
+	}
+	return resp.StatusCode, err
+}
+
+func (wn *WebhookNotifier) saveDelivery(delivery *WebhookDelivery) {
+	if err := wn.history.RecordDelivery(delivery); err != nil {
+		log.Printf("Warning: failed to log webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// SignWebhook computes the hex HMAC-SHA256 of "timestamp.body". Receivers verify
+// a request by recomputing it from the timestamp header and the raw body.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RecordDelivery stores or replaces a webhook delivery log entry
+func (hs *HistoryStore) RecordDelivery(delivery *WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return hs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyDeliveriesBucket).Put([]byte(delivery.ID), data)
+	})
+}
+
+// GetDelivery returns a webhook delivery by ID, or nil if it is unknown
+func (hs *HistoryStore) GetDelivery(id string) (*WebhookDelivery, error) {
+	var delivery *WebhookDelivery
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(historyDeliveriesBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		delivery = &WebhookDelivery{}
+		return json.Unmarshal(data, delivery)
+	})
+	return delivery, err
+}
+
+// ListDeliveries returns logged webhook deliveries, optionally only those for a job or in a status
+func (hs *HistoryStore) ListDeliveries(jobID, status string) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyDeliveriesBucket).ForEach(func(_, data []byte) error {
+			var delivery WebhookDelivery
+			if err := json.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			if (jobID == "" || delivery.JobID == jobID) && (status == "" || delivery.Status == status) {
+				deliveries = append(deliveries, &delivery)
+			}
+			return nil
+		})
+	})
+	return deliveries, err
+}
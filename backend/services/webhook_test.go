@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// webhookReceiver is a callback endpoint answering with the queued status
+// codes, then 200, and recording every request it receives
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, receivedWebhook{at: time.Now(), header: r.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status, receiver.statuses = receiver.statuses[0], receiver.statuses[1:]
+		}
+		receiver.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (wr *webhookReceiver) received() []receivedWebhook {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]receivedWebhook(nil), wr.requests...)
+}
+
+// newTestNotifier returns a notifier allowed to reach the local receiver
+func newTestNotifier(t *testing.T, retry RetryPolicy) (*WebhookNotifier, *HistoryStore) {
+	t.Helper()
+	history := newTestHistory(t)
+	notifier := NewWebhookNotifier(testWebhookSecret, nil, retry, history)
+	notifier.SetAllowedHosts([]string{"127.0.0.1"})
+	return notifier, history
+}
+
+// waitForDelivery waits until the job's webhook is no longer pending
+func waitForDelivery(t *testing.T, history *HistoryStore, jobID string) *WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := history.ListDeliveries(jobID, "")
+		if err != nil {
+			t.Fatalf("ListDeliveries: %v", err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("webhook for job %s was not delivered in time", jobID)
+	return nil
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	notifier, history := newTestNotifier(t, RetryPolicy{MaxAttempts: 1})
+
+	notifier.Notify(&ProcessingResult{JobID: "job-1", Status: "success"}, receiver.URL+"/hooks")
+	delivery := waitForDelivery(t, history, "job-1")
+	if delivery.Status != DeliveryDelivered || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusOK {
+		t.Fatalf("delivery = %+v", delivery)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	timestamp := request.header.Get(WebhookTimestampHeader)
+	if ts, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("timestamp header = %q", timestamp)
+	}
+	if request.header.Get(WebhookDeliveryHeader) != delivery.ID {
+		t.Errorf("delivery header = %q, want %q", request.header.Get(WebhookDeliveryHeader), delivery.ID)
+	}
+
+	// The receiver can verify the signature from the timestamp and raw body
+	want := "sha256=" + SignWebhook(testWebhookSecret, timestamp, request.body)
+	if got := request.header.Get(WebhookSignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if SignWebhook("other-secret", timestamp, request.body) == SignWebhook(testWebhookSecret, timestamp, request.body) {
+		t.Error("signature does not depend on the secret")
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(request.body, &payload); err != nil || payload.Event != "job.completed" || payload.Result.JobID != "job-1" {
+		t.Errorf("payload = %+v, %v", payload, err)
+	}
+}
+
+func TestWebhookRetrySchedule(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	notifier, history := newTestNotifier(t, RetryPolicy{MaxAttempts: 4, InitialBackoff: 40 * time.Millisecond, MaxBackoff: time.Second})
+
+	notifier.Notify(&ProcessingResult{JobID: "job-retry"}, receiver.URL)
+	delivery := waitForDelivery(t, history, "job-retry")
+	if delivery.Status != DeliveryDelivered || delivery.Attempts != 3 {
+		t.Fatalf("delivery = %+v, want delivered on the third attempt", delivery)
+	}
+
+	// The waits between attempts double, and every attempt is the same delivery
+	requests := receiver.received()
+	if len(requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(requests))
+	}
+	for i, minWait := range []time.Duration{40 * time.Millisecond, 80 * time.Millisecond} {
+		if wait := requests[i+1].at.Sub(requests[i].at); wait < minWait {
+			t.Errorf("wait before attempt %d = %v, want at least %v", i+2, wait, minWait)
+		}
+		if requests[i+1].header.Get(WebhookDeliveryHeader) != delivery.ID {
+			t.Errorf("attempt %d has another delivery ID", i+2)
+		}
+	}
+}
+
+func TestWebhookClientErrorIsPermanent(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusBadRequest)
+	notifier, history := newTestNotifier(t, RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})
+
+	notifier.Notify(&ProcessingResult{JobID: "job-400"}, receiver.URL)
+	delivery := waitForDelivery(t, history, "job-400")
+	if delivery.Status != DeliveryFailed || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusBadRequest {
+		t.Errorf("delivery = %+v, want one failed attempt", delivery)
+	}
+}
+
+func TestWebhookReplay(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	notifier, history := newTestNotifier(t, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+	notifier.Notify(&ProcessingResult{JobID: "job-replay"}, receiver.URL)
+	failed := waitForDelivery(t, history, "job-replay")
+	if failed.Status != DeliveryFailed || failed.Attempts != 2 || failed.LastError == "" {
+		t.Fatalf("delivery = %+v, want failed after 2 attempts", failed)
+	}
+
+	// The receiver has recovered; a replay re-sends the logged payload
+	replayed, err := notifier.Replay(context.Background(), failed.ID)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if replayed.Status != DeliveryDelivered || replayed.Attempts != 3 || replayed.LastError != "" {
+		t.Errorf("replayed delivery = %+v", replayed)
+	}
+	stored, err := history.GetDelivery(failed.ID)
+	if err != nil || stored.Status != DeliveryDelivered {
+		t.Errorf("stored delivery = %+v, %v", stored, err)
+	}
+
+	requests := receiver.received()
+	last := requests[len(requests)-1]
+	if string(last.body) != string(failed.Payload) || last.header.Get(WebhookDeliveryHeader) != failed.ID {
+		t.Error("replay did not re-send the original delivery")
+	}
+	if unknown, err := notifier.Replay(context.Background(), "missing"); unknown != nil || err != nil {
+		t.Errorf("Replay of an unknown delivery = %+v, %v", unknown, err)
+	}
+}
+
+func TestWebhookCallbackRestrictions(t *testing.T) {
+	ctx := context.Background()
+	notifier := NewWebhookNotifier(testWebhookSecret, map[string]string{"acme": "http://hooks.internal:8080/cb"}, RetryPolicy{MaxAttempts: 1}, newTestHistory(t))
+
+	refused := []string{
+		"ftp://example.com/hook",
+		"/relative",
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/hook",
+		"http://[::1]/hook",
+		"http://100.64.1.1/hook",
+	}
+	for _, callback := range refused {
+		if err := notifier.ValidateCallback(ctx, callback); err == nil {
+			t.Errorf("ValidateCallback(%q) succeeded", callback)
+		}
+	}
+	for _, callback := range []string{"https://93.184.215.14/hook", "http://hooks.internal:8080/other"} {
+		if err := notifier.ValidateCallback(ctx, callback); err != nil {
+			t.Errorf("ValidateCallback(%q): %v", callback, err)
+		}
+	}
+
+	// With an allow-list only the listed hosts are accepted
+	notifier.SetAllowedHosts([]string{"hooks.example.com", "*.partner.example"})
+	for callback, ok := range map[string]bool{
+		"https://hooks.example.com/cb":     true,
+		"https://eu.partner.example/cb":    true,
+		"https://partner.example/cb":       false,
+		"https://93.184.215.14/hook":       false,
+		"https://hooks.example.com.evil/x": false,
+	} {
+		if err := notifier.ValidateCallback(ctx, callback); (err == nil) != ok {
+			t.Errorf("ValidateCallback(%q) = %v, want allowed %v", callback, err, ok)
+		}
+	}
+}
+
+func TestWebhookRefusesInternalAddressesWhenDelivering(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	history := newTestHistory(t)
+	notifier := NewWebhookNotifier(testWebhookSecret, nil, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, history)
+
+	// Delivery checks the address again, e.g. after a DNS change since validation
+	notifier.Notify(&ProcessingResult{JobID: "job-internal"}, receiver.URL)
+	delivery := waitForDelivery(t, history, "job-internal")
+	if delivery.Status != DeliveryFailed || delivery.Attempts != 1 {
+		t.Errorf("delivery = %+v, want one refused attempt", delivery)
+	}
+	if n := len(receiver.received()); n != 0 {
+		t.Errorf("internal receiver got %d requests", n)
+	}
+
+	// Redirects are not followed
+	redirect := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/", http.StatusFound))
+	defer redirect.Close()
+	u, _ := url.Parse(redirect.URL)
+	notifier.SetAllowedHosts([]string{u.Hostname()})
+	notifier.Notify(&ProcessingResult{JobID: "job-redirect"}, redirect.URL)
+	if delivery := waitForDelivery(t, history, "job-redirect"); delivery.ResponseCode != http.StatusFound {
+		t.Errorf("delivery = %+v, want the redirect response", delivery)
+	}
+}
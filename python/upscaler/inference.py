@@ -1,39 +1,87 @@
-import torch
-from .model import UpscalerNet
-from PIL import Image
-import numpy as np
+#!/usr/bin/env python3
+"""
+CNN image upscaler CLI
+Runs the trained UpscalerCNN weights with the same arguments as upscale.py,
+so the backend can register it as a script model
+"""
+
+import argparse
 import os
 import sys
 
+import numpy as np
+import torch
+from PIL import Image
+
+sys.path.insert(0, os.path.dirname(os.path.abspath(__file__)))
+from model import UpscalerCNN  # noqa: E402
+
+# The network upsamples with two 2x pixel shuffles
+MODEL_SCALE = 4
+
+
 class Upscaler:
     def __init__(self, model_path, device='cpu'):
+        if not os.path.exists(model_path):
+            raise FileNotFoundError(f"model weights not found: {model_path}")
+
         self.device = device
-        self.model = UpscalerNet().to(device)
-        
-        if os.path.exists(model_path):
-            self.model.load_state_dict(torch.load(model_path, map_location=device))
-        
+        self.model = UpscalerCNN(scale_factor=MODEL_SCALE).to(device)
+        self.model.load_state_dict(torch.load(model_path, map_location=device))
         self.model.eval()
-    
+
     def upscale(self, input_path, output_path):
         """Upscale image from file path"""
         img = Image.open(input_path).convert('RGB')
         img_array = np.array(img)
         img_tensor = torch.tensor(img_array).permute(2, 0, 1).unsqueeze(0).float() / 255.0
-        
+
         with torch.no_grad():
-            upscaled = self.model(img_tensor.to(self.device))
-        
-        upscaled_np = (upscaled.squeeze(0).permute(1, 2, 0).cpu().numpy() * 255).astype(np.uint8)
+            upscaled = self.model(img_tensor.to(self.device)).clamp(0, 1)
+
+        upscaled_np = (upscaled.squeeze(0).permute(1, 2, 0).cpu().numpy() * 255).round().astype(np.uint8)
         result = Image.fromarray(upscaled_np)
         result.save(output_path)
         return output_path
 
-if __name__ == "__main__":
-    if len(sys.argv) < 3:
-        print("Usage: python inference.py <input_image> <output_image>")
+
+def main():
+    parser = argparse.ArgumentParser(
+        description="Upscale images with the trained CNN"
+    )
+    parser.add_argument("--input", required=True, help="Path to input image")
+    parser.add_argument("--output", required=True, help="Path to output image")
+    parser.add_argument(
+        "--scale",
+        type=int,
+        default=MODEL_SCALE,
+        help=f"Upscaling factor; the model only supports {MODEL_SCALE}",
+    )
+    parser.add_argument(
+        "--weights",
+        default="models/upscaler.pth",
+        help="Path to the trained weights (default: models/upscaler.pth)",
+    )
+    parser.add_argument("--device", default="cpu", help="Torch device (default: cpu)")
+
+    args = parser.parse_args()
+
+    if args.scale != MODEL_SCALE:
+        print(f"ERROR: Scale must be {MODEL_SCALE}, got {args.scale}", file=sys.stderr)
+        sys.exit(1)
+    if not os.path.exists(args.input):
+        print(f"ERROR: Input file not found: {args.input}", file=sys.stderr)
         sys.exit(1)
-    
-    upscaler = Upscaler('models/upscaler.pth')
-    upscaler.upscale(sys.argv[1], sys.argv[2])
-    print(f"Upscaled image saved to {sys.argv[2]}")
\ No newline at end of file
+
+    try:
+        upscaler = Upscaler(args.weights, args.device)
+        upscaler.upscale(args.input, args.output)
+    except Exception as e:
+        print(f"ERROR: Upscaling failed: {str(e)}", file=sys.stderr)
+        sys.exit(1)
+
+    print(f"Upscaled image saved to: {args.output}", file=sys.stderr)
+
+
+if __name__ == "__main__":
+    main()
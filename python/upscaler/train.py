@@ -2,7 +2,7 @@ import torch
 import torch.nn as nn
 import torch.optim as optim
 from torch.utils.data import DataLoader
-from .model import UpscalerNet
+from .model import UpscalerCNN
 
 class Trainer:
     def __init__(self, model, device='cpu', learning_rate=0.001):